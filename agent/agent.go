@@ -1,14 +1,20 @@
 package agent
 
 import (
-	"bytes"
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"net/http"
+	"sort"
+	"strings"
 	"sync"
+	"sync/atomic"
+	"text/template"
+	"time"
 
+	"github.com/rs/zerolog"
 	"github.com/rs/zerolog/log"
 )
 
@@ -20,6 +26,272 @@ type Config struct {
 	SystemPrompt string
 	MaxLoops     int
 	Temperature  float64
+
+	// ParallelToolCalls is sent as "parallel_tool_calls" when set. It controls
+	// whether the model may emit several tool calls in one response.
+	ParallelToolCalls *bool
+
+	// StoreToolCallsInSession keeps the tool call and tool result messages
+	// of session turns in the history (default true). When false, only the
+	// user and assistant messages persist after a turn; the calls still run
+	// and are reported by Conversations.
+	StoreToolCallsInSession *bool
+
+	// ToolFormat selects how tools are sent: ToolFormatTools (default) or
+	// ToolFormatFunctions for endpoints that only support the deprecated
+	// "functions"/"function_call" format.
+	ToolFormat string
+
+	// FunctionCallRetryOnParse asks the model to resend a tool call whose
+	// arguments are not valid JSON instead of passing them to the handler, up
+	// to FunctionCallRetryMax times per run or turn (default 2).
+	FunctionCallRetryOnParse bool
+	FunctionCallRetryMax     int
+
+	// KeepWarmInterval, when set, pings the endpoint at this interval while a
+	// session is open so its connection stays in the pool
+	KeepWarmInterval time.Duration
+
+	// User is sent as the "user" request field for abuse tracking. The "user"
+	// key of WithRequestMetadata overrides it.
+	User string
+	// TraceHeader names the header carrying the ID set with WithTraceID
+	// (default "X-Request-ID")
+	TraceHeader string
+
+	// TitleModel is used by Session.GenerateTitle, Session.Summary and turn
+	// summaries (default Model). Pick a cheap model.
+	TitleModel string
+	// TitleRefreshMessages is how many messages the history may grow by
+	// before a cached title is regenerated (default 10)
+	TitleRefreshMessages int
+	// SummarizeTurns attaches a one-sentence TurnSummary to every
+	// EventTurnComplete. It costs one extra call per turn.
+	SummarizeTurns bool
+
+	// Provider selects a built-in ProviderAdapter, e.g. ProviderGroq
+	Provider string
+	// Adapter overrides the adapter selected by Provider
+	Adapter ProviderAdapter
+
+	// OnError is called for API call, response parsing and tool execution
+	// errors. Returning nil treats the error as a warning and continues: a
+	// failed API call is retried in the next iteration (counting against
+	// MaxLoops) and a failed tool reports the error to the model as usual.
+	// Returning an error aborts the run with it. phase is one of PhaseAPICall,
+	// PhaseResponseParse and PhaseToolExecution.
+	OnError func(ctx context.Context, err error, phase string) error
+
+	// MaxTokens caps the completion length, sent as "max_tokens" when set.
+	// It is also the completion budget of the context window pre-check.
+	MaxTokens int
+	// ContextWindow is the model's context size in tokens. It defaults to
+	// the built-in table of well-known models; the pre-check is skipped for
+	// unknown models.
+	ContextWindow int
+	// ContextSafetyMargin is the percentage of the context window kept free
+	// to absorb estimation errors (default 10)
+	ContextSafetyMargin int
+	// CompactOnOverflow compacts completed tool exchanges with
+	// CompactToolCalls when a request would not fit, before failing with
+	// ErrContextLengthExceeded. CompactToolNote formats the notes.
+	CompactOnOverflow bool
+	CompactToolNote   ToolNoteFunc
+
+	// RepromptOnEmpty asks the model once for a final answer when it stops
+	// without any text, which some models do after tool calls. Without it,
+	// or if the retry is empty too, Response.EmptyContent is set.
+	RepromptOnEmpty bool
+	// RejectEmptyCompletion fails runs and turns whose final answer is empty
+	// or whitespace, after one retry like RepromptOnEmpty, with an
+	// *EmptyCompletionError. Sessions then emit EventError instead of an
+	// empty EventTurnComplete.
+	RejectEmptyCompletion bool
+
+	// HTTPClient is used for all requests when set, in which case the
+	// connection pool settings below are ignored
+	HTTPClient *http.Client
+	// MaxIdleConnsPerHost is how many idle connections to the provider are
+	// kept for reuse (default 16; net/http defaults to 2)
+	MaxIdleConnsPerHost int
+	// IdleConnTimeout closes idle connections after this long (default 90s)
+	IdleConnTimeout time.Duration
+	// Clock replaces the system clock for timestamps, delays, timeouts and
+	// the datetime built-in, e.g. with a fake clock in tests
+	Clock Clock
+
+	// OnIterationEnd is called after every iteration that continues the
+	// loop, once its tool calls have run. The messages it returns are sent
+	// with the next request only and never enter the history, e.g. a
+	// corrective note after a poor tool choice.
+	OnIterationEnd func(ctx context.Context, iteration IterationResult) []ConversationMessage
+	// MaxInjectedMessages caps the messages OnIterationEnd may inject per
+	// run or turn (default 10). Further messages are dropped.
+	MaxInjectedMessages int
+
+	// APIKeyFunc returns the API key before each request, e.g. from a vault
+	// or a rotating pool. It takes precedence over APIKeys and APIKey.
+	APIKeyFunc func(ctx context.Context) (string, error)
+
+	// Memory keeps facts across sessions. When set, the "remember" and
+	// "recall" tools are registered and the MemoryRecallK (default 5) most
+	// relevant facts are appended to the system prompt of every new
+	// session and run.
+	Memory        Memory
+	MemoryRecallK int
+
+	// ContinueTimeout lets session turns pause instead of failing when they
+	// reach MaxLoops: the session emits EventNeedContinue and waits this
+	// long for Session.Continue, which grants another MaxLoops iterations.
+	// Disabled when zero.
+	ContinueTimeout time.Duration
+
+	// Logger receives the agent's logs instead of the global zerolog logger
+	Logger *zerolog.Logger
+	// QuietIterations logs the per-iteration lines (iteration start,
+	// responses, tool executions) at debug level, leaving the summary line
+	// logged at the end of every run and turn
+	QuietIterations bool
+
+	// ToolExecutor, when set, receives every tool call instead of the
+	// registered handlers, e.g. to run tools in a sandboxed service.
+	// Registered tools then only provide the schemas sent to the model and
+	// need no handler.
+	ToolExecutor func(ctx context.Context, name string, args json.RawMessage) (any, error)
+
+	// OnHistoryCompacted is called whenever messages are removed or
+	// condensed from a history, including during Run where no events are
+	// emitted. Sessions also emit EventHistoryCompacted.
+	OnHistoryCompacted func(compaction HistoryCompaction)
+
+	// MaxTotalTokens caps the tokens a run or turn may spend. Once reached
+	// the run fails with ErrTokenBudgetExceeded instead of continuing.
+	MaxTotalTokens int
+	// BudgetNote is a text/template for a system note sent with every
+	// request, telling the model its remaining budget so it can plan, e.g.
+	// DefaultBudgetNote. It is executed with a BudgetStatus and never
+	// stored in the history. Disabled when empty.
+	BudgetNote string
+
+	// MaxContextMessages caps the messages sent per request by leaving out
+	// the oldest ones. The system prompt and the latest user message are
+	// always sent, so it must be at least 2. The latest tool exchange of the
+	// turn is sent whole, even past the cap. The history itself is kept in
+	// full.
+	MaxContextMessages int
+
+	// MaxToolResultLength caps the bytes of a JSON-encoded tool result. The
+	// longest string values of a larger result are cut and end with
+	// "...[truncated]", so the result stays valid JSON. 0 means unlimited.
+	MaxToolResultLength int
+
+	// RedactFields lists dot-separated JSON paths (e.g. "address" or
+	// "user.email") whose values are replaced by Redacted in the tool
+	// arguments and results that are logged or emitted as events. Handlers
+	// still receive the real values.
+	RedactFields []string
+
+	// PerTurnReminder returns a short system message, e.g. the current date
+	// and the user's timezone, sent as the last message of every request
+	// where recency makes it most effective. It is never stored in the
+	// history. The session is nil for Run. An empty string sends nothing.
+	PerTurnReminder func(ctx context.Context, s *Session) string
+
+	// LargeInputHandling, when set, stores user messages over its threshold
+	// as attachments, keeps an excerpt in the history and registers the
+	// read_user_attachment tool to read the rest on demand
+	LargeInputHandling *LargeInputHandling
+
+	// PageTTL keeps the results of Paginated for this long. When zero they
+	// are dropped at the end of the turn or run.
+	PageTTL time.Duration
+
+	// CaptureHeaders lists the HTTP response headers, e.g. "x-request-id" or
+	// "x-ratelimit-remaining-requests", reported by Response.Headers and
+	// EventResponseHeaders. Headers not listed are discarded.
+	CaptureHeaders []string
+
+	// CoerceArgs converts string-encoded numbers and booleans in tool
+	// arguments, e.g. {"id": "5"}, to the parameter types the tool declares
+	// before calling it
+	CoerceArgs bool
+
+	// CompressRequests gzips request bodies and asks for gzip responses.
+	// When the endpoint answers 415 Unsupported Media Type the request is
+	// retried uncompressed and compression stays off for the agent.
+	CompressRequests bool
+
+	// Modalities sets the output modalities, e.g. []string{"text", "audio"}
+	// for audio-capable models. Omitted from requests when empty.
+	Modalities []string
+	// Audio configures audio output when Modalities includes "audio"
+	Audio *AudioOutput
+
+	// OnMaxLoops is what a run or turn does on reaching MaxLoops:
+	// MaxLoopsError (default) fails with ErrMaxLoopsExceeded, and
+	// MaxLoopsFinalize makes one last call with tool_choice "none" whose
+	// answer becomes the result. Sessions first wait for Continue when
+	// ContinueTimeout is set.
+	OnMaxLoops string
+	// MaxLoopsHandler, when set, replaces OnMaxLoops and picks the policy
+	// per case from the partial response: MaxLoopsError, MaxLoopsFinalize or
+	// MaxLoopsContinue, which grants another MaxLoops iterations
+	MaxLoopsHandler func(ctx context.Context, partial *Response) string
+
+	// ModelRouter picks the model of each iteration from the history so
+	// far, e.g. a small model for simple requests and a large one once
+	// tools are involved. Returning "" keeps Config.Model. The messages are
+	// a copy.
+	ModelRouter func(messages []ConversationMessage, iteration int) string
+
+	// APIKeys spreads requests across several keys, e.g. of different
+	// provider accounts, in proportion to their weights. It is ignored
+	// when APIKeyFunc is set and takes precedence over APIKey. A key
+	// answered with 401 or 429 is ejected for KeyCooldown (default 30s),
+	// then gets a single probe request that puts it back in rotation if it
+	// succeeds. Keys are logged by index and fingerprint only; see
+	// Agent.KeyHealth.
+	APIKeys []WeightedKey
+	// KeySelection is KeySelectionWeighted (default, weighted random) or
+	// KeySelectionRoundRobin
+	KeySelection string
+	KeyCooldown  time.Duration
+
+	// CircuitBreakerThreshold enables a circuit breaker around the
+	// provider: after this many consecutive failed calls within
+	// CircuitBreakerWindow (default 1m), calls fail fast with
+	// ErrCircuitOpen for CircuitBreakerCooldown (default 30s). A single
+	// probe call then tests whether the provider recovered. Disabled when
+	// zero; see Agent.CircuitState.
+	CircuitBreakerThreshold int
+	CircuitBreakerWindow    time.Duration
+	CircuitBreakerCooldown  time.Duration
+	// CircuitBreaker is used instead of the CircuitBreaker* fields when
+	// set. Share one between agents calling the same endpoint.
+	CircuitBreaker *CircuitBreaker
+
+	// Stream requests the completions of runs and turns as server-sent
+	// events, assembled before use. With StreamStallTimeout set, a stream
+	// that sends nothing for that long is aborted with ErrStreamStalled,
+	// and retried once without streaming when StreamFallback is set.
+	Stream             bool
+	StreamStallTimeout time.Duration
+	StreamFallback     bool
+}
+
+// AudioOutput is the "audio" block of requests with audio output
+type AudioOutput struct {
+	Voice  string `json:"voice"`  // e.g. "alloy"
+	Format string `json:"format"` // e.g. "wav", "mp3" or "pcm16"
+}
+
+// Audio is the audio returned by the model, see Config.Modalities
+type Audio struct {
+	ID         string // Provider ID of the audio
+	Data       []byte // Decoded audio in Format
+	Format     string // Config.Audio.Format
+	Transcript string // Text of the audio
+	ExpiresAt  time.Time
 }
 
 // Tool represents a registered tool
@@ -29,6 +301,38 @@ type Tool struct {
 	Parameters  map[string]Parameter
 	Required    []string
 	Handler     ToolHandler
+	Executor    ToolExecutor // Used instead of Handler when set
+	// StreamHandler is used instead of Handler when set. Its output is
+	// reported chunk by chunk with EventToolStreamChunk.
+	StreamHandler StreamingToolHandler
+	// Precondition is checked before every call. When it returns an error
+	// the handler is skipped and the error is sent to the model as the tool
+	// result, steering it to call the prerequisite tools first.
+	Precondition func(ctx context.Context, state SessionState) error
+	// RateLimit caps the calls per minute, 0 meaning unlimited. A call over
+	// the limit is not executed; the model receives a rate_limit_exceeded
+	// error with retry_after_seconds and EventToolRateLimited is emitted.
+	RateLimit int
+	// TransformArgs rewrites the arguments before they reach the handler,
+	// e.g. to normalize dates or expand shorthand. An error skips the
+	// handler and is returned as a *TransformError.
+	TransformArgs func(args json.RawMessage) (json.RawMessage, error)
+	// StateSnapshot returns the state the tool mutates, e.g. the rows of a
+	// task table. It is captured before and after each call and the
+	// difference emitted as EventStateChange, for auditing mutations.
+	StateSnapshot func(ctx context.Context) (any, error)
+
+	// Async tools run in the background: the model immediately gets
+	// {"status":"dispatched"} and never sees the handler's result. Delivery
+	// is at-most-once; a task still running when the process exits is lost.
+	Async bool
+
+	// Version and Changelog document the tool schema. They are not sent to
+	// the API but are reported by ListTools and ExportToolSchemas.
+	Version   string
+	Changelog []string
+
+	namespace string // Set by RegisterNamespace
 }
 
 // Parameter defines a tool parameter
@@ -46,26 +350,153 @@ type Items struct {
 // ToolHandler is the function that executes the tool
 type ToolHandler func(args json.RawMessage) (any, error)
 
+// ToolExecutor is implemented by struct-based tools that carry their own state
+type ToolExecutor interface {
+	Execute(ctx context.Context, args json.RawMessage) (any, error)
+}
+
+// ToolExecutorFunc adapts an ordinary function to the ToolExecutor interface
+type ToolExecutorFunc func(ctx context.Context, args json.RawMessage) (any, error)
+
+// Execute calls f(ctx, args)
+func (f ToolExecutorFunc) Execute(ctx context.Context, args json.RawMessage) (any, error) {
+	return f(ctx, args)
+}
+
 // Agent is the AI agent
 type Agent struct {
-	config Config
-	tools  map[string]*Tool
-	client *http.Client
+	config  Config
+	tools   map[string]*Tool
+	toolsMu sync.RWMutex
+	client  *http.Client
+	callSeq atomic.Int64
+	async   sync.WaitGroup // Async tools dispatched by Run
+	clock   Clock
+
+	budgetNote *template.Template // Parsed Config.BudgetNote
+	pagingOnce sync.Once          // Registers the next_page tool
+	toolLimits toolLimiter        // Token buckets of Tool.RateLimit
+
+	uncompressed atomic.Bool     // The endpoint rejected a compressed request
+	keys         *keyPool        // Rotation of Config.APIKeys, nil when unset
+	breaker      *CircuitBreaker // Nil when disabled
 }
 
-// Response is the agent's response
+// Response is the agent's response. Run may return a non-nil Response
+// together with an error; it then holds everything accumulated before the
+// failure.
 type Response struct {
 	Content      string
 	Usage        Usage
 	FinishReason string
 	LoopCount    int
+	// UsageAvailable is false when at least one API response of the run
+	// omitted usage, in which case Usage undercounts
+	UsageAvailable bool
+	Messages       []ConversationMessage // Transcript of the run, including the system prompt
+	ToolCalls      []ToolCallRecord      // Tool calls executed during the run, in order
+	// EmptyContent is true when the run ended without assistant text, even
+	// after a Config.RepromptOnEmpty retry
+	EmptyContent bool
+	// Headers holds the Config.CaptureHeaders of the last API response, keyed
+	// by lower-case name, e.g. "x-request-id" for support tickets
+	Headers map[string]string
+	// TimeToFirstToken is the delay between sending a request and
+	// receiving its first token, for the first API call of the run or turn.
+	// It is only measured when Config.Stream is set.
+	TimeToFirstToken time.Duration
+	// Audio is the final answer as audio when Config.Modalities includes
+	// "audio", or nil
+	Audio *Audio
+	// Iterations lists the API calls of the run with the tool calls each
+	// one requested, in order
+	Iterations []IterationRecord
 }
 
 // Usage contains token usage information
 type Usage struct {
-	PromptTokens     int
+	PromptTokens     int `json:"prompt_tokens"`
+	CompletionTokens int `json:"completion_tokens"`
+	TotalTokens      int `json:"total_tokens"`
+}
+
+// ConversationMessage is a single message of the conversation history
+type ConversationMessage struct {
+	Role       string     `json:"role"`
+	Content    string     `json:"content"`
+	ToolCalls  []ToolCall `json:"tool_calls,omitempty"`
+	ToolCallID string     `json:"tool_call_id,omitempty"`
+
+	// Transient messages stay in the history for display but are never sent
+	// to the provider
+	Transient bool `json:"-"`
+
+	// CreatedAt is when the message was added to the history. It is kept
+	// in GetHistory and JSON-encoded histories but never sent to the
+	// provider.
+	CreatedAt time.Time `json:"created_at,omitzero"`
+
+	turn string // ID of the running turn this is the user message of
+}
+
+// ToolCall is a tool invocation requested by the model
+type ToolCall struct {
+	ID       string       `json:"id"`
+	Type     string       `json:"type"`
+	Function FunctionCall `json:"function"`
+}
+
+// FunctionCall holds the name and raw JSON arguments of a tool call
+type FunctionCall struct {
+	Name      string `json:"name"`
+	Arguments string `json:"arguments"`
+}
+
+// ToolCallRecord describes a tool call executed by the agent
+type ToolCallRecord struct {
+	ID        string
+	Name      string
+	Arguments string
+	Result    string // Content sent back to the model
+	Error     string // Handler error, empty on success
+	Err       error  // The error behind Error, e.g. a *TransformError
+	Iteration int
+}
+
+// IterationRecord describes an iteration of a run: an API call and the tool
+// calls it requested
+type IterationRecord struct {
+	LoopNumber       int
+	ToolCalls        []ToolCallRecord
+	PromptTokens     int // Zero when the response carried no usage
 	CompletionTokens int
-	TotalTokens      int
+	ToolErrors       *ToolErrors // Nil unless a tool call failed
+}
+
+// ContextEstimate compares the estimated size of a request with the limit
+// derived from the model's context window
+type ContextEstimate struct {
+	EstimatedTokens int // Prompt estimate plus the MaxTokens completion budget
+	LimitTokens     int // Context window minus the safety margin
+}
+
+// IterationResult describes a completed loop iteration to OnIterationEnd
+type IterationResult struct {
+	Iteration    int
+	Content      string
+	FinishReason string
+	ToolCalls    []ToolCallRecord      // Calls executed in this iteration
+	ToolErrors   *ToolErrors           // Nil unless a tool call failed
+	Messages     []ConversationMessage // Copy of the history so far
+}
+
+// ConversationTurn groups a user message with the agent's answer to it
+type ConversationTurn struct {
+	ID               string // Turn ID, as set on the turn's events
+	UserMessage      string
+	AssistantMessage string
+	ToolCalls        []ToolCallRecord
+	Usage            Usage
 }
 
 // EventType represents the type of event emitted by the session
@@ -78,61 +509,276 @@ const (
 	EventNeedInput      EventType = "need_input"
 	EventTurnComplete   EventType = "turn_complete"
 	EventError          EventType = "error"
+
+	// EventRateLimitApproaching carries a RateLimitStatus as Data
+	EventRateLimitApproaching EventType = "rate_limit_approaching"
+	// EventToolResultInvalid is emitted when a tool result cannot be encoded
+	// as JSON; the model receives a tool error instead. Data is the tool name.
+	EventToolResultInvalid EventType = "tool_result_invalid"
+	// EventHistoryCompacted carries a HistoryCompaction as Data
+	EventHistoryCompacted EventType = "history_compacted"
+	// EventBatch groups events coalesced by Session.CoalesceEvents; Data is
+	// a BatchedEvents, Seq that of the last event in the batch and TurnID
+	// that of its turn
+	EventBatch EventType = "batch"
+	// EventNeedContinue is emitted when a turn reaches MaxLoops and waits
+	// for Session.Continue (see Config.ContinueTimeout)
+	EventNeedContinue EventType = "need_continue"
+	// EventContextEstimate carries a ContextEstimate as Data
+	EventContextEstimate EventType = "context_estimate"
+	// EventResponseHeaders carries the headers listed in
+	// Config.CaptureHeaders as Data, a map[string]string, after each API call
+	EventResponseHeaders EventType = "response_headers"
+	// EventToolRateLimited carries a ToolRateLimited as Data when a call is
+	// refused by Tool.RateLimit
+	EventToolRateLimited EventType = "tool_rate_limited"
+	// EventToolStreamChunk carries a ToolStreamChunk as Data for each chunk
+	// of output read from a Tool.StreamHandler
+	EventToolStreamChunk EventType = "tool_stream_chunk"
+	// EventCircuitOpen carries a CircuitOpened as Data when an API call
+	// fails while the circuit breaker is open
+	EventCircuitOpen EventType = "circuit_open"
+	// EventStateChange carries a StateChange as Data when a call changed
+	// the Tool.StateSnapshot of its tool
+	EventStateChange EventType = "state_change"
+	// EventToolFailures carries a *ToolErrors as Data when two or more tool
+	// calls of an iteration failed, after their EventToolResult events
+	EventToolFailures EventType = "tool_failures"
 )
 
 // AgentEvent represents an event emitted by the agent
 type AgentEvent struct {
-	Type      EventType
-	Content   string
-	Data      any
-	Iteration int
+	Type       EventType
+	Content    string
+	Data       any
+	Iteration  int
+	ToolCallID string // Set on EventToolCall and EventToolResult
+	Seq        int64  // Increases monotonically across the events of a session
+	TurnID     string // ID of the session turn that produced the event
 }
 
 // Session represents an interactive session with the agent
 type Session struct {
-	agent       *Agent
-	ctx         context.Context
-	cancel      context.CancelFunc
-	events      chan AgentEvent
-	input       chan string
-	messages    []any
-	mu          sync.RWMutex
-	closed      bool
-	totalUsage  Usage
-	loopCount   int
+	agent      *Agent
+	ctx        context.Context
+	cancel     context.CancelFunc
+	events     <-chan AgentEvent // Default subscription returned by Events
+	messages   []ConversationMessage
+	turns      []ConversationTurn
+	mu         sync.RWMutex
+	closed     bool
+	options    runOptions
+	title      string
+	titleLen   int // History length when the title was generated
+	auxUsage   Usage
+	totalUsage Usage
+	loopCount  int
+	maxLoops   int            // Loop budget, raised by Continue
+	continueCh chan struct{}  // Signals Continue to a waiting turn
+	awaiting   bool           // A turn is waiting for Continue
+	async      sync.WaitGroup // Async tools dispatched by the session
+	seq        atomic.Int64
+	subs       subscribers
+	stores     []*EventStore
+	coalesce   coalescer
+	turnSeq    atomic.Int64
+	lastTurn   chan struct{} // Closed when the most recently queued turn ends
+
+	attachments *attachmentStore // Large user messages, see LargeInputHandling
+	pages       *pageStore       // Paginated tool results
+	blocks      []ContextBlock   // Active context blocks, TTLTurns counting down
+
+	pending  []pendingInput // Input requests waiting for SendInput, oldest first
+	inputSeq int
+	closing  chan struct{} // Closed when Close starts
+
+	prompt string // System prompt before the locale instruction
 }
 
 // New creates a new agent
 func New(config Config) (*Agent, error) {
+	if err := config.applyDefaults(); err != nil {
+		return nil, err
+	}
+
+	budgetNote, err := parseBudgetNote(config.BudgetNote)
+	if err != nil {
+		return nil, err
+	}
+
+	client := config.HTTPClient
+	if client == nil {
+		client = &http.Client{Transport: newTransport(config)}
+	}
+
+	a := &Agent{
+		config:     config,
+		tools:      make(map[string]*Tool),
+		client:     client,
+		clock:      realClock{},
+		budgetNote: budgetNote,
+		keys:       newKeyPool(config, realClock{}),
+		breaker:    newCircuitBreaker(config),
+	}
+	if config.Clock != nil {
+		a.setClock(config.Clock)
+	}
+	if config.Memory != nil {
+		a.RegisterTools(memoryTools(config.Memory, config.MemoryRecallK)...)
+	}
+	if config.LargeInputHandling != nil {
+		a.RegisterTool(attachmentTool(config.LargeInputHandling.MaxReadChars))
+	}
+	return a, nil
+}
+
+// applyDefaults validates the configuration and fills in the defaults
+func (c *Config) applyDefaults() error {
 	// Checks
-	if config.APIURL == "" {
-		return nil, fmt.Errorf("API URL is required")
+	if c.APIURL == "" {
+		return ErrMissingAPIURL
+	}
+	if c.APIKey == "" && c.APIKeyFunc == nil && len(c.APIKeys) == 0 {
+		return ErrMissingAPIKey
+	}
+	for i, key := range c.APIKeys {
+		if key.Key == "" {
+			return fmt.Errorf("APIKeys[%d] is empty", i)
+		}
+	}
+	switch c.KeySelection {
+	case "", KeySelectionWeighted, KeySelectionRoundRobin:
+	default:
+		return fmt.Errorf("invalid KeySelection: %q", c.KeySelection)
+	}
+	if c.Model == "" {
+		return ErrMissingModel
 	}
-	if config.APIKey == "" {
-		return nil, fmt.Errorf("API key is required")
+	if c.SystemPrompt == "" {
+		return ErrMissingSystemPrompt
 	}
-	if config.Model == "" {
-		return nil, fmt.Errorf("model is required")
+	if c.MaxLoops == 0 {
+		c.MaxLoops = 20
 	}
-	if config.SystemPrompt == "" {
-		return nil, fmt.Errorf("system prompt is required")
+	switch c.OnMaxLoops {
+	case "", MaxLoopsError, MaxLoopsFinalize:
+	default:
+		return fmt.Errorf("invalid OnMaxLoops: %q", c.OnMaxLoops)
 	}
-	if config.MaxLoops == 0 {
-		config.MaxLoops = 20
+	if c.ContextSafetyMargin == 0 {
+		c.ContextSafetyMargin = defaultSafetyMargin
+	}
+	if c.MemoryRecallK == 0 {
+		c.MemoryRecallK = 5
+	}
+	if c.MaxContextMessages < 0 || c.MaxContextMessages == 1 {
+		return fmt.Errorf("invalid MaxContextMessages: %d, the system prompt and the latest user message need 2", c.MaxContextMessages)
+	}
+	if c.MaxInjectedMessages < 0 {
+		return fmt.Errorf("invalid MaxInjectedMessages: %d", c.MaxInjectedMessages)
+	}
+	if c.MaxInjectedMessages == 0 {
+		c.MaxInjectedMessages = 10
+	}
+	if c.LargeInputHandling != nil {
+		h := *c.LargeInputHandling
+		h.applyDefaults()
+		c.LargeInputHandling = &h
+	}
+	if c.MaxIdleConnsPerHost == 0 {
+		c.MaxIdleConnsPerHost = 16
+	}
+	if c.IdleConnTimeout == 0 {
+		c.IdleConnTimeout = 90 * time.Second
+	}
+	if c.TitleRefreshMessages == 0 {
+		c.TitleRefreshMessages = 10
+	}
+	if c.TraceHeader == "" {
+		c.TraceHeader = "X-Request-ID"
+	}
+	if c.FunctionCallRetryOnParse && c.FunctionCallRetryMax == 0 {
+		c.FunctionCallRetryMax = 2
+	}
+	if c.Adapter == nil {
+		adapter, err := newProviderAdapter(c.Provider)
+		if err != nil {
+			return err
+		}
+		c.Adapter = adapter
+	}
+	switch c.ToolFormat {
+	case "":
+		c.ToolFormat = ToolFormatTools
+	case ToolFormatTools, ToolFormatFunctions:
+	default:
+		return fmt.Errorf("unknown tool format: %s", c.ToolFormat)
 	}
 
-	return &Agent{
-		config: config,
-		tools:  make(map[string]*Tool),
-		client: &http.Client{},
-	}, nil
+	return nil
 }
 
-// RegisterTool registers a new tool
+// newTransport returns the default transport tuned with the connection pool
+// settings of config
+func newTransport(config Config) *http.Transport {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.MaxIdleConnsPerHost = config.MaxIdleConnsPerHost
+	if transport.MaxIdleConns < config.MaxIdleConnsPerHost {
+		transport.MaxIdleConns = config.MaxIdleConnsPerHost
+	}
+	transport.IdleConnTimeout = config.IdleConnTimeout
+	return transport
+}
+
+// log returns Config.Logger, or the global logger when it is unset
+func (a *Agent) log() *zerolog.Logger {
+	if a.config.Logger != nil {
+		return a.config.Logger
+	}
+	return &log.Logger
+}
+
+// GetConfig returns a copy of the agent configuration with the API key
+// masked, suitable for logging
+func (a *Agent) GetConfig() Config {
+	config := a.config
+	if config.APIKey != "" {
+		config.APIKey = "***"
+	}
+	if config.APIKeys != nil {
+		keys := make([]WeightedKey, len(config.APIKeys))
+		for i, key := range config.APIKeys {
+			keys[i] = WeightedKey{Key: "***", Weight: key.Weight}
+		}
+		config.APIKeys = keys
+	}
+	return config
+}
+
+// RegisterTool registers a new tool. It is safe to call concurrently with
+// running sessions.
 func (a *Agent) RegisterTool(tool *Tool) {
+	a.toolsMu.Lock()
+	defer a.toolsMu.Unlock()
+
+	if existing, ok := a.tools[tool.Name]; ok && existing.Version != tool.Version {
+		a.log().Warn().
+			Str("tool", tool.Name).
+			Str("old_version", existing.Version).
+			Str("new_version", tool.Version).
+			Msg("[Agent] Tool re-registered with a different version")
+	}
 	a.tools[tool.Name] = tool
 }
 
+// UnregisterTool removes a registered tool. Unknown names are ignored.
+func (a *Agent) UnregisterTool(name string) {
+	a.toolsMu.Lock()
+	defer a.toolsMu.Unlock()
+
+	delete(a.tools, name)
+}
+
 // RegisterTools registers multiple tools
 func (a *Agent) RegisterTools(tools ...*Tool) {
 	for _, tool := range tools {
@@ -140,58 +786,120 @@ func (a *Agent) RegisterTools(tools ...*Tool) {
 	}
 }
 
-// NewSession creates a new interactive session with the agent
-func (a *Agent) NewSession(ctx context.Context) *Session {
+// NewSession creates a new interactive session with the agent. The options
+// apply to every turn of the session.
+func (a *Agent) NewSession(ctx context.Context, opts ...RunOption) *Session {
 	sessionCtx, cancel := context.WithCancel(ctx)
-	return &Session{
-		agent:    a,
-		ctx:      sessionCtx,
-		cancel:   cancel,
-		events:   make(chan AgentEvent, 10),
-		input:    make(chan string),
-		messages: []any{map[string]string{"role": "system", "content": a.config.SystemPrompt}},
+	if a.config.KeepWarmInterval > 0 {
+		go a.keepWarm(sessionCtx, a.config.KeepWarmInterval)
 	}
+
+	attachments := &attachmentStore{}
+	sessionCtx = withAttachments(sessionCtx, attachments)
+	pages := &pageStore{}
+	sessionCtx = withPages(sessionCtx, pages)
+
+	options := newRunOptions(opts)
+	prompt := a.systemPrompt(ctx, "")
+	s := &Session{
+		agent:       a,
+		attachments: attachments,
+		pages:       pages,
+		ctx:         sessionCtx,
+		cancel:      cancel,
+		closing:     make(chan struct{}),
+		maxLoops:    a.config.MaxLoops,
+		continueCh:  make(chan struct{}, 1),
+		prompt:      prompt,
+		messages:    []ConversationMessage{{Role: "system", Content: localizedPrompt(prompt, options.locale), CreatedAt: a.clock.Now()}},
+		options:     options,
+		subs:        subscribers{replaySize: options.eventReplaySize},
+	}
+	s.events = s.Subscribe(WithSubscriberBuffer(10), WithOverflowPolicy(OverflowBlock))
+	return s
 }
 
 // Send sends a message to the agent and starts a new turn
-func (s *Session) Send(message string) error {
-	s.mu.Lock()
-	if s.closed {
-		s.mu.Unlock()
-		return fmt.Errorf("session is closed")
-	}
-	s.mu.Unlock()
+func (s *Session) Send(message string, opts ...SendOption) error {
+	_, err := s.SendTurn(message, opts...)
+	return err
+}
 
-	userMessage := map[string]string{
-		"role":    "user",
-		"content": message,
+// SendTurn is like Send but returns the ID of the new turn, which is set as
+// TurnID on every event of the turn. Turns sent concurrently, e.g. by several
+// users sharing the session, run one at a time in the order they were sent,
+// each seeing the history left by the previous one.
+func (s *Session) SendTurn(message string, opts ...SendOption) (string, error) {
+	options := newSendOptions(opts)
+	wait, done, err := s.queueTurn()
+	if err != nil {
+		return "", err
 	}
-	s.messages = append(s.messages, userMessage)
+	id := s.newTurnID()
 
-	log.Info().Str("message", message).Msg("[Session] User message sent")
+	s.agent.log().Info().Str("message", message).Str("turn", id).Msg("[Session] User message sent")
 
-	go s.runTurn()
-	return nil
+	go func() {
+		defer close(done)
+		<-wait
+		s.runTurn(id, message, options)
+	}()
+	return id, nil
 }
 
-// SendInput sends input to the agent when it asks for it
-func (s *Session) SendInput(input string) error {
-	s.mu.RLock()
+// queueTurn reserves the next slot in the session's turn queue. The caller
+// runs its turn once wait is closed and must close done when finished.
+func (s *Session) queueTurn() (wait <-chan struct{}, done chan struct{}, err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
 	if s.closed {
-		s.mu.RUnlock()
-		return fmt.Errorf("session is closed")
+		return nil, nil, fmt.Errorf("session is closed")
+	}
+	prev := s.lastTurn
+	if prev == nil {
+		prev = make(chan struct{})
+		close(prev)
 	}
-	s.mu.RUnlock()
+	done = make(chan struct{})
+	s.lastTurn = done
+	return prev, done, nil
+}
 
-	select {
-	case s.input <- input:
-		return nil
-	case <-s.ctx.Done():
-		return fmt.Errorf("session context cancelled")
+// newTurnID returns a turn ID unique within the session
+func (s *Session) newTurnID() string {
+	return fmt.Sprintf("turn-%d", s.turnSeq.Add(1))
+}
+
+// SendBatch sends several user messages that are processed in order, one
+// turn each, emitting an EventTurnComplete per message. Processing stops at
+// the first failed turn. Useful to replay a script of user messages for
+// evaluation. The batch takes a single slot in the turn queue, so turns
+// sent meanwhile run after the whole batch.
+func (s *Session) SendBatch(messages []string) error {
+	wait, done, err := s.queueTurn()
+	if err != nil {
+		return err
 	}
+
+	s.agent.log().Info().Int("messages", len(messages)).Msg("[Session] User message batch sent")
+
+	go func() {
+		defer close(done)
+		<-wait
+		for _, message := range messages {
+			if !s.runTurn(s.newTurnID(), message, sendOptions{}) {
+				return
+			}
+		}
+	}()
+	return nil
 }
 
-// Close closes the session
+// Close closes the session. It cancels the running turn, waits for it and
+// for async tools dispatched by the session to return, then releases the
+// session's resources. Queued turns are dropped. Close must not be called
+// from a tool handler, which would wait for its own turn.
 func (s *Session) Close() {
 	s.mu.Lock()
 	if s.closed {
@@ -199,355 +907,598 @@ func (s *Session) Close() {
 		return
 	}
 	s.closed = true
+	s.pending = nil
+	close(s.closing)
+	last := s.lastTurn
 	s.mu.Unlock()
 
+	// The turn queue must be drained before waiting for async tools, since a
+	// running turn may still dispatch one. Async tools outlive the
+	// cancellation.
 	s.cancel()
-	close(s.events)
-	close(s.input)
+	if last != nil {
+		<-last
+	}
+	s.async.Wait()
+	s.coalesce.wg.Wait()
+	s.subs.close()
+}
+
+// AppendMessage adds a message to the session history without starting a
+// turn, e.g. a UI notice marked Transient. A zero CreatedAt is set to now.
+// Transient messages cannot be tool messages or carry tool calls, so
+// skipping them never breaks tool_call pairing. Messages appended while a
+// turn runs are kept after the turn's messages.
+func (s *Session) AppendMessage(msg ConversationMessage) error {
+	if msg.Role == "" {
+		return fmt.Errorf("message role is required")
+	}
+	if msg.Transient && (msg.Role == "tool" || len(msg.ToolCalls) > 0) {
+		return fmt.Errorf("transient messages cannot be part of a tool call exchange")
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.closed {
+		return fmt.Errorf("session is closed")
+	}
+	if msg.CreatedAt.IsZero() {
+		msg.CreatedAt = s.agent.clock.Now()
+	}
+	s.messages = append(s.messages, msg)
+	return nil
 }
 
-// GetHistory returns the message history of the session
+// GetHistory returns the message history of the session. Every element is a
+// ConversationMessage.
 func (s *Session) GetHistory() []any {
 	s.mu.RLock()
 	defer s.mu.RUnlock()
 
 	history := make([]any, len(s.messages))
-	copy(history, s.messages)
+	for i, msg := range s.messages {
+		history[i] = msg
+	}
 	return history
 }
 
-// Events returns the channel for receiving agent events
+// withoutToolCalls drops the tool result messages and the tool calls of
+// assistant messages, and with them assistant messages left empty
+func withoutToolCalls(messages []ConversationMessage) []ConversationMessage {
+	kept := make([]ConversationMessage, 0, len(messages))
+	for _, msg := range messages {
+		if msg.Role == "tool" {
+			continue
+		}
+		if len(msg.ToolCalls) > 0 {
+			if msg.Content == "" {
+				continue
+			}
+			msg.ToolCalls = nil
+		}
+		kept = append(kept, msg)
+	}
+	return kept
+}
+
+// HistoryByRole returns the messages of the session history with the given
+// role ("system", "user", "assistant" or "tool"), in order
+func (s *Session) HistoryByRole(role string) []ConversationMessage {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	var messages []ConversationMessage
+	for _, msg := range s.messages {
+		if msg.Role == role {
+			messages = append(messages, msg)
+		}
+	}
+	return messages
+}
+
+// Events returns the session's default event channel. It is a blocking
+// subscription: turns wait until its events are read, so it must be drained
+// unless it is unsubscribed.
 func (s *Session) Events() <-chan AgentEvent {
 	return s.events
 }
 
-// runTurn executes a single turn of the agent in the session
-func (s *Session) runTurn() {
+// Conversations returns the completed turns of the session as user/assistant pairs
+func (s *Session) Conversations() []ConversationTurn {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	turns := make([]ConversationTurn, len(s.turns))
+	copy(turns, s.turns)
+	return turns
+}
+
+// runTurn executes a single turn of the agent in the session and reports
+// whether it completed. Turns are serialized by the turn queue, so only one
+// runs at a time.
+func (s *Session) runTurn(id, message string, options sendOptions) bool {
+	emit := func(event AgentEvent) {
+		event.TurnID = id
+		s.sendEvent(event)
+	}
+
 	s.mu.Lock()
-	messages := make([]any, len(s.messages))
+	if s.closed {
+		s.mu.Unlock()
+		return false
+	}
+	s.messages = append(s.messages, ConversationMessage{Role: "user", Content: s.agent.userContent(s.ctx, message), CreatedAt: s.agent.clock.Now(), turn: id})
+	messages := make([]ConversationMessage, len(s.messages))
 	copy(messages, s.messages)
+	ctx := context.WithValue(context.WithValue(s.ctx, sessionKey{}, s), turnKey{}, id)
+	ctx = withLocale(ctx, s.options.locale)
+	l := s.agent.newLoop(ctx, "[Session]", messages, s.options)
+	l.loopCount = s.loopCount
+	l.maxLoops = s.maxLoops
+	for _, turn := range s.turns {
+		l.priorCalls = append(l.priorCalls, turn.ToolCalls...)
+	}
+	l.blocks = withContextBlocks(s.blocks, options.blocks)
+	l.needContinue = func(iteration int) bool { return s.waitContinue(iteration, emit) }
+	l.emit = emit
+	l.session = s
+	l.async = &s.async
 	s.mu.Unlock()
 
-	reason := ""
-	turnLoopCount := 0
-	var lastResponse *apiResponse
-
-	for reason != "stop" {
-		turnLoopCount++
-		s.loopCount++
-
-		if s.loopCount > s.agent.config.MaxLoops {
-			s.sendEvent(AgentEvent{
-				Type:      EventError,
-				Content:   fmt.Sprintf("maximum loop iterations (%d) exceeded", s.agent.config.MaxLoops),
-				Iteration: s.loopCount,
-			})
-			return
-		}
+	err := l.run()
+	if s.agent.config.PageTTL <= 0 {
+		s.pages.clear()
+	}
 
-		s.sendEvent(AgentEvent{
-			Type:      EventIterationStart,
-			Content:   fmt.Sprintf("Starting iteration %d", s.loopCount),
-			Iteration: s.loopCount,
+	s.mu.Lock()
+	s.totalUsage.PromptTokens += l.usage.PromptTokens
+	s.totalUsage.CompletionTokens += l.usage.CompletionTokens
+	s.totalUsage.TotalTokens += l.usage.TotalTokens
+	// The user message is found by its turn ID: the history may have been
+	// compacted or reset while the turn ran
+	sent := turnMessage(s.messages, id)
+	if err == nil {
+		s.loopCount = l.loopCount
+		s.maxLoops = l.maxLoops
+		s.blocks = expireContextBlocks(l.blocks)
+		// Update session messages, keeping those appended during the turn
+		var appended []ConversationMessage
+		if sent >= 0 {
+			appended = s.messages[sent+1:]
+		}
+		system := s.messages[0] // May have changed with SetLocale
+		kept := l.messages
+		if c := s.agent.config.StoreToolCallsInSession; c != nil && !*c {
+			kept = withoutToolCalls(kept)
+		}
+		if i := turnMessage(kept, id); i >= 0 {
+			kept[i].turn = ""
+		}
+		s.messages = append(kept, appended...)
+		s.messages[0] = system
+		s.turns = append(s.turns, ConversationTurn{
+			ID:               id,
+			UserMessage:      message,
+			AssistantMessage: l.content(),
+			ToolCalls:        l.toolCalls,
+			Usage:            l.usage,
 		})
+	} else if sent >= 0 {
+		// Roll back to the last completed exchange: drop the user message of
+		// the failed turn, keeping those appended during it. Its assistant
+		// and tool messages only ever lived in the loop, and its iterations
+		// don't count against MaxLoops.
+		s.messages = append(s.messages[:sent], s.messages[sent+1:]...)
+	}
+	recoverable := !s.closed && s.ctx.Err() == nil
+	s.mu.Unlock()
 
-		log.Info().Int("iteration", s.loopCount).Msg("[Session] Starting iteration")
+	if err != nil {
+		emit(AgentEvent{
+			Type:      EventError,
+			Content:   err.Error(),
+			Data:      TurnError{Err: err, Recoverable: recoverable},
+			Iteration: l.loopCount,
+		})
+		return false
+	}
 
-		resp, err := s.agent.callAPI(messages)
-		if err != nil {
-			s.sendEvent(AgentEvent{
-				Type:      EventError,
-				Content:   fmt.Sprintf("API call error: %v", err),
-				Iteration: s.loopCount,
-			})
-			return
+	event := AgentEvent{
+		Type:      EventTurnComplete,
+		Content:   l.content(),
+		Iteration: l.loopCount,
+	}
+	if s.agent.config.SummarizeTurns {
+		if summary := s.summarizeTurn(message, l.content(), l.toolCalls); summary != nil {
+			event.Data = *summary
 		}
+	}
 
-		lastResponse = resp
-		reason = resp.Choices[0].FinishReason
-
-		// Accumulate token usage
-		s.totalUsage.PromptTokens += resp.Usage.PromptTokens
-		s.totalUsage.CompletionTokens += resp.Usage.CompletionTokens
-		s.totalUsage.TotalTokens += resp.Usage.TotalTokens
-
-		log.Info().
-			Int("iteration", s.loopCount).
-			Str("finish_reason", reason).
-			Int("num_tool_calls", len(resp.Choices[0].Message.ToolCalls)).
-			Msg("[Session] Received response")
-
-		if reason == "tool_calls" {
-			// Add assistant message with tool_calls
-			assistantMessage := map[string]any{
-				"role":       "assistant",
-				"tool_calls": resp.Choices[0].Message.ToolCalls,
-			}
-			messages = append(messages, assistantMessage)
-
-			// Execute each tool call
-			for _, toolCall := range resp.Choices[0].Message.ToolCalls {
-				log.Info().
-					Str("tool_name", toolCall.Function.Name).
-					Str("arguments", toolCall.Function.Arguments).
-					Msg("[Session] Executing tool")
-
-				s.sendEvent(AgentEvent{
-					Type:      EventToolCall,
-					Content:   toolCall.Function.Name,
-					Data:      toolCall.Function.Arguments,
-					Iteration: s.loopCount,
-				})
-
-				result, err := s.agent.executeTool(toolCall.Function.Name, json.RawMessage(toolCall.Function.Arguments))
-
-				var content string
-				if err != nil {
-					log.Error().Err(err).Str("tool", toolCall.Function.Name).Msg("[Session] Tool execution error")
-					content = fmt.Sprintf(`{"error": "%s"}`, err.Error())
-				} else {
-					resultJSON, err := json.Marshal(result)
-					if err != nil {
-						s.sendEvent(AgentEvent{
-							Type:      EventError,
-							Content:   fmt.Sprintf("error encoding tool result: %v", err),
-							Iteration: s.loopCount,
-						})
-						return
-					}
-					content = string(resultJSON)
-				}
-
-				s.sendEvent(AgentEvent{
-					Type:      EventToolResult,
-					Content:   content,
-					Data:      toolCall.Function.Name,
-					Iteration: s.loopCount,
-				})
-
-				// Add tool response
-				toolResponse := map[string]string{
-					"role":         "tool",
-					"content":      content,
-					"tool_call_id": toolCall.ID,
-				}
-				messages = append(messages, toolResponse)
-			}
+	// Emit turn complete event
+	emit(event)
+	return true
+}
+
+// turnMessage returns the index of the user message of turn id in messages,
+// -1 when it is not there
+func turnMessage(messages []ConversationMessage, id string) int {
+	for i := len(messages) - 1; i >= 0; i-- {
+		if messages[i].turn == id {
+			return i
 		}
 	}
+	return -1
+}
 
-	if lastResponse == nil || len(lastResponse.Choices) == 0 {
-		s.sendEvent(AgentEvent{
-			Type:      EventError,
-			Content:   "no response from API",
-			Iteration: s.loopCount,
-		})
-		return
+// Continue resumes a turn waiting after EventNeedContinue, granting it
+// another MaxLoops iterations. It returns an error if no turn is waiting.
+func (s *Session) Continue() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if !s.awaiting {
+		return fmt.Errorf("no turn is waiting to continue")
 	}
+	s.awaiting = false
+	s.continueCh <- struct{}{}
+	return nil
+}
 
-	// Add final assistant message
-	finalMessage := map[string]string{
-		"role":    "assistant",
-		"content": lastResponse.Choices[0].Message.Content,
+// waitContinue emits EventNeedContinue and reports whether Continue was
+// called within Config.ContinueTimeout
+func (s *Session) waitContinue(iteration int, emit func(AgentEvent)) bool {
+	timeout := s.agent.config.ContinueTimeout
+	if timeout <= 0 {
+		return false
 	}
-	messages = append(messages, finalMessage)
 
-	// Update session messages
 	s.mu.Lock()
-	s.messages = messages
+	s.awaiting = true
 	s.mu.Unlock()
 
-	// Emit turn complete event
-	s.sendEvent(AgentEvent{
-		Type:      EventTurnComplete,
-		Content:   lastResponse.Choices[0].Message.Content,
-		Iteration: s.loopCount,
+	emit(AgentEvent{
+		Type:      EventNeedContinue,
+		Content:   fmt.Sprintf("Reached %d iterations, call Continue to resume", iteration-1),
+		Iteration: iteration,
 	})
-}
 
-// sendEvent sends an event to the session's event channel
-func (s *Session) sendEvent(event AgentEvent) {
 	select {
-	case s.events <- event:
+	case <-s.continueCh:
+		s.agent.log().Info().Int("iteration", iteration).Msg("[Session] Continuing after MaxLoops")
+		return true
+	case <-s.agent.clock.After(timeout):
 	case <-s.ctx.Done():
-		log.Info().Msg("[Session] Context cancelled, stopping event emission")
 	}
+
+	s.mu.Lock()
+	s.awaiting = false
+	// Continue may have won the race with the timeout
+	select {
+	case <-s.continueCh:
+	default:
+	}
+	s.mu.Unlock()
+	return false
 }
 
-// Run executes the agent with a prompt
-func (a *Agent) Run(prompt string) (*Response, error) {
-	messages := []any{
-		map[string]string{"role": "system", "content": a.config.SystemPrompt},
-		map[string]string{"role": "user", "content": prompt},
+// sendEvent sends an event to the session's event channel
+func (s *Session) sendEvent(event AgentEvent) {
+	event.Seq = s.seq.Add(1)
+	s.recordEvent(event)
+
+	s.emitEvent(event)
+}
+
+// deliverEvent broadcasts an event to the subscribers, including the
+// default Events channel
+func (s *Session) deliverEvent(event AgentEvent) {
+	if s.ctx.Err() != nil {
+		s.agent.log().Info().Msg("[Session] Context cancelled, stopping event emission")
+		return
 	}
 
-	log.Info().Str("prompt", prompt).Msg("[Agent] Starting run")
+	s.subs.broadcast(event, s.ctx.Done())
+}
 
-	reason := ""
-	loopCount := 0
-	var lastResponse *apiResponse
-	var totalUsage Usage
+// Run executes the agent with a prompt. When the run fails midway the
+// returned Response is still non-nil and carries the usage, loop count,
+// transcript and tool calls accumulated so far.
+func (a *Agent) Run(prompt string, opts ...RunOption) (*Response, error) {
+	return a.RunContext(context.Background(), prompt, opts...)
+}
 
-	for reason != "stop" {
-		loopCount++
+// RunContext is like Run but stops as soon as ctx is cancelled
+func (a *Agent) RunContext(ctx context.Context, prompt string, opts ...RunOption) (*Response, error) {
+	ctx = withAttachments(ctx, &attachmentStore{})
+	ctx = withPages(ctx, &pageStore{})
+	options := newRunOptions(opts)
+	ctx = withLocale(ctx, options.locale)
+	now := a.clock.Now()
+	messages := []ConversationMessage{
+		{Role: "system", Content: localizedPrompt(a.systemPrompt(ctx, prompt), options.locale), CreatedAt: now},
+		{Role: "user", Content: a.userContent(ctx, prompt), CreatedAt: now},
+	}
 
-		if loopCount > a.config.MaxLoops {
-			return nil, fmt.Errorf("maximum loop iterations (%d) exceeded", a.config.MaxLoops)
-		}
+	a.log().Info().Str("prompt", prompt).Msg("[Agent] Starting run")
 
-		log.Info().Int("iteration", loopCount).Msg("[Agent] Starting iteration")
+	l := a.newLoop(ctx, "[Agent]", messages, options)
+	err := l.run()
+	return l.response(), err
+}
 
-		resp, err := a.callAPI(messages)
-		if err != nil {
-			return nil, fmt.Errorf("API call error: %w", err)
-		}
+// RunHandle is an in-progress run started with RunCancelable
+type RunHandle struct {
+	cancel context.CancelFunc
+	done   chan struct{}
+	resp   *Response
+	err    error
+}
 
-		lastResponse = resp
-		reason = resp.Choices[0].FinishReason
-
-		// Accumulate token usage from this iteration
-		totalUsage.PromptTokens += resp.Usage.PromptTokens
-		totalUsage.CompletionTokens += resp.Usage.CompletionTokens
-		totalUsage.TotalTokens += resp.Usage.TotalTokens
-
-		log.Info().
-			Int("iteration", loopCount).
-			Str("finish_reason", reason).
-			Int("num_tool_calls", len(resp.Choices[0].Message.ToolCalls)).
-			Msg("[Agent] Received response")
-
-		if reason == "tool_calls" {
-			// Add assistant message with tool_calls
-			assistantMessage := map[string]any{
-				"role":       "assistant",
-				"tool_calls": resp.Choices[0].Message.ToolCalls,
-			}
-			messages = append(messages, assistantMessage)
-
-			// Execute each tool call
-			for _, toolCall := range resp.Choices[0].Message.ToolCalls {
-				log.Info().
-					Str("tool_name", toolCall.Function.Name).
-					Str("arguments", toolCall.Function.Arguments).
-					Msg("[Agent] Executing tool")
-
-				result, err := a.executeTool(toolCall.Function.Name, json.RawMessage(toolCall.Function.Arguments))
-
-				var content string
-				if err != nil {
-					log.Error().Err(err).Str("tool", toolCall.Function.Name).Msg("[Agent] Tool execution error")
-					content = fmt.Sprintf(`{"error": "%s"}`, err.Error())
-				} else {
-					resultJSON, err := json.Marshal(result)
-					if err != nil {
-						return nil, fmt.Errorf("error encoding tool result: %w", err)
-					}
-					content = string(resultJSON)
-				}
-
-				// Add tool response
-				toolResponse := map[string]string{
-					"role":         "tool",
-					"content":      content,
-					"tool_call_id": toolCall.ID,
-				}
-				messages = append(messages, toolResponse)
-			}
-		}
+// RunCancelable starts Run in the background and returns a handle that can
+// abort it from another goroutine, for callers that don't thread contexts
+func (a *Agent) RunCancelable(prompt string, opts ...RunOption) *RunHandle {
+	ctx, cancel := context.WithCancel(context.Background())
+	h := &RunHandle{
+		cancel: cancel,
+		done:   make(chan struct{}),
 	}
 
-	if len(lastResponse.Choices) == 0 {
-		return nil, fmt.Errorf("no response from API")
-	}
+	go func() {
+		defer close(h.done)
+		defer cancel()
+		h.resp, h.err = a.RunContext(ctx, prompt, opts...)
+	}()
+
+	return h
+}
+
+// Cancel aborts the run. It is safe to call more than once and after the run
+// has finished.
+func (h *RunHandle) Cancel() {
+	h.cancel()
+}
+
+// Done is closed when the run has finished
+func (h *RunHandle) Done() <-chan struct{} {
+	return h.done
+}
 
-	return &Response{
-		Content:      lastResponse.Choices[0].Message.Content,
-		Usage:        totalUsage,
-		FinishReason: lastResponse.Choices[0].FinishReason,
-		LoopCount:    loopCount,
-	}, nil
+// Wait blocks until the run has finished and returns its result
+func (h *RunHandle) Wait() (*Response, error) {
+	<-h.done
+	return h.resp, h.err
+}
+
+// lookupTool returns a registered tool or nil
+func (a *Agent) lookupTool(name string) *Tool {
+	a.toolsMu.RLock()
+	defer a.toolsMu.RUnlock()
+
+	return a.tools[name]
 }
 
 // executeTool executes a registered tool
-func (a *Agent) executeTool(name string, args json.RawMessage) (any, error) {
-	tool, ok := a.tools[name]
-	if !ok {
+func (a *Agent) executeTool(ctx context.Context, name string, args json.RawMessage) (any, error) {
+	tool := a.lookupTool(name)
+	ctx, local := stripNamespace(ctx, tool)
+	if tool != nil && tool.TransformArgs != nil {
+		transformed, err := tool.TransformArgs(args)
+		if err != nil {
+			return nil, &TransformError{Tool: name, Args: args, Err: err}
+		}
+		args = transformed
+	}
+	if a.config.ToolExecutor != nil {
+		if local != "" {
+			name = local
+		}
+		return a.config.ToolExecutor(ctx, name, args)
+	}
+
+	if tool == nil {
 		return nil, fmt.Errorf("tool not found: %s", name)
 	}
+	if a.config.CoerceArgs {
+		args = coerceArgs(tool, args)
+	}
 
+	if tool.Executor != nil {
+		return tool.Executor.Execute(ctx, args)
+	}
+	if tool.StreamHandler != nil {
+		return readToolStream(ctx, tool.StreamHandler, args)
+	}
+	if tool.Handler == nil {
+		return nil, fmt.Errorf("tool has no handler: %s", name)
+	}
 	return tool.Handler(args)
 }
 
-// callAPI calls the API with the url provided in the config
-func (a *Agent) callAPI(messages []any) (*apiResponse, error) {
+// apiRequest describes a single call to the API
+type apiRequest struct {
+	messages []ConversationMessage
+	options  runOptions
+	model    string // Overrides Config.Model when set
+	noTools  bool   // Omit the tool definitions
+	stream   bool   // Request server-sent events
+	textOnly bool   // Omit Config.Modalities, e.g. for side calls
+	// toolChoice is sent as "tool_choice" (or "function_call") when set
+	toolChoice string
+	maxTokens  int
+	// extra messages are sent after messages for this call only and never
+	// enter the history
+	extra []ConversationMessage
+}
+
+// buildRequestBody builds the JSON request body for an API call
+func (a *Agent) buildRequestBody(r apiRequest) map[string]any {
+	model := a.config.Model
+	if r.model != "" {
+		model = r.model
+	}
+
+	requestBody := map[string]any{
+		"model": model,
+	}
+
 	// Convert tools to API format
-	apiTools := make([]apiTool, 0, len(a.tools))
-	for _, tool := range a.tools {
-		properties := make(map[string]apiParameter)
-		for name, param := range tool.Parameters {
-			apiParam := apiParameter{
-				Type:        param.Type,
-				Description: param.Description,
-			}
-			if param.Items != nil {
-				apiParam.Items = &apiItems{Type: param.Items.Type}
-			}
-			properties[name] = apiParam
+	var apiTools []apiTool
+	if !r.noTools {
+		a.toolsMu.RLock()
+		apiTools = make([]apiTool, 0, len(a.tools))
+		for _, tool := range a.tools {
+			apiTools = append(apiTools, toAPITool(tool))
 		}
-
-		apiTools = append(apiTools, apiTool{
-			Type: "function",
-			Function: apiFunction{
-				Name:        tool.Name,
-				Description: tool.Description,
-				Parameters: apiParameters{
-					Type:       "object",
-					Properties: properties,
-					Required:   tool.Required,
-				},
-			},
+		a.toolsMu.RUnlock()
+		// Sorted so that identical requests have identical bodies
+		sort.Slice(apiTools, func(i, j int) bool {
+			return apiTools[i].Function.Name < apiTools[j].Function.Name
 		})
 	}
 
-	requestBody := map[string]any{
-		"model":    a.config.Model,
-		"messages": messages,
-		"tools":    apiTools,
+	messages := make([]ConversationMessage, 0, len(r.messages)+len(r.extra))
+	for _, msg := range r.messages {
+		if !msg.Transient {
+			msg.CreatedAt = time.Time{}
+			messages = append(messages, msg)
+		}
+	}
+	messages = append(messages, r.extra...)
+
+	if a.config.ToolFormat == ToolFormatFunctions {
+		requestBody["messages"] = toLegacyMessages(messages)
+		if !r.noTools {
+			if r.toolChoice != "" && len(apiTools) > 0 {
+				requestBody["function_call"] = r.toolChoice
+			}
+			functions := make([]apiFunction, len(apiTools))
+			for i, tool := range apiTools {
+				functions[i] = tool.Function
+			}
+			requestBody["functions"] = functions
+		}
+	} else {
+		requestBody["messages"] = messages
+		if !r.noTools {
+			requestBody["tools"] = apiTools
+			if r.toolChoice != "" && len(apiTools) > 0 {
+				requestBody["tool_choice"] = r.toolChoice
+			}
+		}
 	}
 
 	if a.config.Temperature > 0 {
 		requestBody["temperature"] = a.config.Temperature
 	}
 
+	if r.maxTokens > 0 {
+		requestBody["max_tokens"] = r.maxTokens
+	}
+
+	if r.stream {
+		requestBody["stream"] = true
+		requestBody["stream_options"] = map[string]any{"include_usage": true}
+	}
+
+	user := a.config.User
+	if u, ok := r.options.metadata["user"]; ok {
+		user = u
+	}
+	if user != "" {
+		requestBody["user"] = user
+	}
+	if len(r.options.metadata) > 0 {
+		requestBody["metadata"] = r.options.metadata
+	}
+
+	if a.config.ParallelToolCalls != nil && a.config.ToolFormat == ToolFormatTools && len(apiTools) > 0 {
+		requestBody["parallel_tool_calls"] = *a.config.ParallelToolCalls
+	}
+
+	if len(a.config.Modalities) > 0 && !r.textOnly {
+		requestBody["modalities"] = a.config.Modalities
+		if a.config.Audio != nil {
+			requestBody["audio"] = a.config.Audio
+		}
+	}
+
+	return requestBody
+}
+
+// sendAPI calls the API with the url provided in the config
+func (a *Agent) sendAPI(ctx context.Context, r apiRequest) (*apiResponse, error) {
+	requestBody := a.buildRequestBody(r)
+
 	jsonBody, err := json.Marshal(requestBody)
 	if err != nil {
 		return nil, fmt.Errorf("error encoding request: %w", err)
 	}
 
-	req, err := http.NewRequest("POST", a.config.APIURL, bytes.NewBuffer(jsonBody))
+	start := a.clock.Now()
+	compress := a.config.CompressRequests && !a.uncompressed.Load()
+	resp, err := a.post(ctx, r, jsonBody, compress)
 	if err != nil {
-		return nil, fmt.Errorf("error creating request: %w", err)
+		return nil, err
 	}
+	if compress && resp.StatusCode == http.StatusUnsupportedMediaType {
+		// The endpoint does not accept gzip bodies; stop compressing
+		io.Copy(io.Discard, resp.Body)
+		resp.Body.Close()
+		a.uncompressed.Store(true)
+		a.log().Warn().Msg("[Agent] Endpoint rejected a compressed request, retrying uncompressed")
+
+		if resp, err = a.post(ctx, r, jsonBody, false); err != nil {
+			return nil, err
+		}
+	}
+	defer resp.Body.Close()
 
-	req.Header.Set("Authorization", "Bearer "+a.config.APIKey)
-	req.Header.Set("Content-Type", "application/json")
+	var rateLimit *RateLimitStatus
+	if a.config.Adapter != nil {
+		rateLimit = a.config.Adapter.AfterResponse(resp)
+	}
 
-	resp, err := a.client.Do(req)
-	if err != nil {
-		return nil, fmt.Errorf("error making request: %w", err)
+	if r.stream && isEventStream(resp) {
+		apiResp, err := a.readStream(ctx, resp, start)
+		if errors.Is(err, ErrStreamStalled) && a.config.StreamFallback {
+			a.log().Warn().Err(err).Msg("[Agent] Stream stalled, retrying without streaming")
+			r.stream = false
+			return a.sendAPI(ctx, r)
+		}
+		if err != nil {
+			return nil, err
+		}
+		a.normalizeToolCalls(apiResp)
+		a.normalizeFinishReasons(apiResp)
+		apiResp.rateLimit = rateLimit
+		apiResp.headers = a.captureHeaders(resp.Header)
+		return apiResp, nil
 	}
-	defer resp.Body.Close()
 
-	body, err := io.ReadAll(resp.Body)
+	body, err := readBody(resp)
 	if err != nil {
 		return nil, fmt.Errorf("error reading response: %w", err)
 	}
 
 	var apiResp apiResponse
 	if err := json.Unmarshal(body, &apiResp); err != nil {
-		return nil, fmt.Errorf("error parsing response: %w", err)
+		return nil, fmt.Errorf("%w: %w", ErrResponseParse, err)
+	}
+	if len(apiResp.Choices) == 0 {
+		if apiResp.Error != nil && apiResp.Error.Message != "" {
+			return nil, fmt.Errorf("%w: %s", ErrEmptyAPIResponse, apiResp.Error.Message)
+		}
+		return nil, ErrEmptyAPIResponse
 	}
 
+	a.normalizeFunctionCalls(&apiResp)
+	a.normalizeToolCalls(&apiResp)
+	a.normalizeFinishReasons(&apiResp)
+	apiResp.rateLimit = rateLimit
+	apiResp.headers = a.captureHeaders(resp.Header)
+	apiResp.raw = body
+
 	return &apiResp, nil
 }
 
@@ -556,31 +1507,55 @@ func (a *Agent) callAPI(messages []any) (*apiResponse, error) {
 type apiResponse struct {
 	ID      string      `json:"id"`
 	Choices []apiChoice `json:"choices"`
-	Usage   Usage       `json:"usage"`
+	Usage   *Usage      `json:"usage"` // Nil when omitted or null
+	Error   *struct {
+		Message string `json:"message"`
+	} `json:"error"` // Set by providers that report errors in the body
+
+	rateLimit  *RateLimitStatus
+	headers    map[string]string // Config.CaptureHeaders present in the response
+	firstToken time.Duration     // Time to the first streamed token, 0 when not streamed
+	raw        []byte            // Response body
+}
+
+// captureHeaders returns the Config.CaptureHeaders present in header, keyed
+// by lower-case name, or nil when there are none
+func (a *Agent) captureHeaders(header http.Header) map[string]string {
+	var captured map[string]string
+	for _, name := range a.config.CaptureHeaders {
+		if value := header.Get(name); value != "" {
+			if captured == nil {
+				captured = make(map[string]string)
+			}
+			captured[strings.ToLower(name)] = value
+		}
+	}
+	return captured
 }
 
 type apiChoice struct {
 	Index        int        `json:"index"`
 	Message      apiMessage `json:"message"`
 	FinishReason string     `json:"finish_reason"`
+	// StopReason is sent instead by some gateways; vLLM sends a token ID or
+	// null alongside finish_reason
+	StopReason json.RawMessage `json:"stop_reason"`
 }
 
 type apiMessage struct {
-	Role       string        `json:"role"`
-	Content    string        `json:"content,omitempty"`
-	ToolCalls  []apiToolCall `json:"tool_calls,omitempty"`
-	ToolCallID string        `json:"tool_call_id,omitempty"`
+	Role         string        `json:"role"`
+	Content      string        `json:"content,omitempty"`
+	ToolCalls    []ToolCall    `json:"tool_calls,omitempty"`
+	ToolCallID   string        `json:"tool_call_id,omitempty"`
+	FunctionCall *FunctionCall `json:"function_call,omitempty"`
+	Audio        *apiAudio     `json:"audio,omitempty"`
 }
 
-type apiToolCall struct {
-	ID       string          `json:"id"`
-	Type     string          `json:"type"`
-	Function apiFunctionCall `json:"function"`
-}
-
-type apiFunctionCall struct {
-	Name      string `json:"name"`
-	Arguments string `json:"arguments"`
+type apiAudio struct {
+	ID         string `json:"id"`
+	Data       string `json:"data"` // Base64
+	Transcript string `json:"transcript"`
+	ExpiresAt  int64  `json:"expires_at"`
 }
 
 type apiTool struct {
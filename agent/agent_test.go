@@ -0,0 +1,124 @@
+package agent_test
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/rs/zerolog"
+	"github.com/trogui/go-agent-sdk/agent"
+	"github.com/trogui/go-agent-sdk/agent/agenttest"
+)
+
+func TestMain(m *testing.M) {
+	zerolog.SetGlobalLevel(zerolog.Disabled)
+	os.Exit(m.Run())
+}
+
+// echoTool returns its arguments as its result
+func echoTool(name string) *agent.Tool {
+	return &agent.Tool{
+		Name:        name,
+		Description: "Echo the arguments",
+		Parameters:  map[string]agent.Parameter{"text": {Type: "string", Description: "Text to echo"}},
+		Handler: func(args json.RawMessage) (any, error) {
+			return json.RawMessage(args), nil
+		},
+	}
+}
+
+// requestField decodes the top-level fields of a recorded request body
+func requestField(t *testing.T, req agenttest.Request, name string) (json.RawMessage, bool) {
+	t.Helper()
+
+	var body map[string]json.RawMessage
+	if err := json.Unmarshal(req.Body, &body); err != nil {
+		t.Fatalf("decoding request body: %v", err)
+	}
+	value, ok := body[name]
+	return value, ok
+}
+
+// rawTransport replies with raw response bodies, in order, for payloads
+// agenttest.Provider cannot script, and records the request bodies
+type rawTransport struct {
+	mu       sync.Mutex
+	bodies   []string
+	requests [][]byte
+}
+
+func (rt *rawTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	body, err := io.ReadAll(req.Body)
+	if err != nil {
+		return nil, err
+	}
+
+	rt.mu.Lock()
+	defer rt.mu.Unlock()
+
+	rt.requests = append(rt.requests, body)
+	if len(rt.bodies) == 0 {
+		return nil, fmt.Errorf("no response left for request %d", len(rt.requests))
+	}
+	reply := rt.bodies[0]
+	rt.bodies = rt.bodies[1:]
+	return &http.Response{
+		StatusCode: http.StatusOK,
+		Header:     http.Header{"Content-Type": {"application/json"}},
+		Body:       io.NopCloser(strings.NewReader(reply)),
+		Request:    req,
+	}, nil
+}
+
+// newRawAgent creates an agent whose API replies with bodies, in order
+func newRawAgent(t *testing.T, config agent.Config, bodies ...string) (*agent.Agent, *rawTransport) {
+	t.Helper()
+
+	rt := &rawTransport{bodies: bodies}
+	config.APIKey = "test"
+	config.APIURL = "http://raw.invalid/v1/chat/completions"
+	config.Model = "test-model"
+	if config.SystemPrompt == "" {
+		config.SystemPrompt = "You are a test assistant."
+	}
+	config.HTTPClient = &http.Client{Transport: rt}
+
+	a, err := agent.New(config)
+	if err != nil {
+		t.Fatalf("creating agent: %v", err)
+	}
+	return a, rt
+}
+
+func TestParallelToolCalls(t *testing.T) {
+	disabled, enabled := false, true
+	tests := []struct {
+		name    string
+		setting *bool
+		want    string // Empty when the field must be absent
+	}{
+		{"unset", nil, ""},
+		{"false", &disabled, "false"},
+		{"true", &enabled, "true"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			e := agenttest.NewEval(t, agent.Config{ParallelToolCalls: tt.setting}, agenttest.Response{Content: "done"})
+			e.Agent.RegisterTool(echoTool("echo"))
+			e.Run("hi").AssertNoError()
+
+			value, ok := requestField(t, e.Provider.Requests()[0], "parallel_tool_calls")
+			switch {
+			case tt.want == "" && ok:
+				t.Errorf("parallel_tool_calls = %s, want it absent", value)
+			case tt.want != "" && string(value) != tt.want:
+				t.Errorf("parallel_tool_calls = %s, want %s", value, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,263 @@
+package agenttest
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"reflect"
+	"regexp"
+	"time"
+
+	"github.com/trogui/go-agent-sdk/agent"
+)
+
+// TB is the subset of testing.TB used by the eval helpers
+type TB interface {
+	Helper()
+	Errorf(format string, args ...any)
+	Fatalf(format string, args ...any)
+}
+
+// turnTimeout bounds how long Converse waits for a single turn
+const turnTimeout = 30 * time.Second
+
+// Eval scripts the model side of an interaction so agent behavior can be
+// asserted deterministically: the same script and tools always yield the
+// same messages and results.
+type Eval struct {
+	t        TB
+	Provider *Provider
+	Agent    *agent.Agent
+}
+
+// NewEval creates an agent from config that talks to a Provider scripted
+// with responses. APIKey, APIURL, Model and SystemPrompt get placeholder
+// values when empty, and HTTPClient is replaced by the provider. Register
+// the tools under test on Eval.Agent.
+func NewEval(t TB, config agent.Config, responses ...Response) *Eval {
+	t.Helper()
+
+	provider := NewProvider(responses...)
+	if config.APIKey == "" && config.APIKeyFunc == nil {
+		config.APIKey = "agenttest"
+	}
+	if config.APIURL == "" {
+		config.APIURL = "http://agenttest.invalid/v1/chat/completions"
+	}
+	if config.Model == "" {
+		config.Model = "agenttest"
+	}
+	if config.SystemPrompt == "" {
+		config.SystemPrompt = "You are a test assistant."
+	}
+	config.HTTPClient = provider.Client()
+
+	ag, err := agent.New(config)
+	if err != nil {
+		t.Fatalf("agenttest: creating agent: %v", err)
+	}
+	return &Eval{t: t, Provider: provider, Agent: ag}
+}
+
+// Result is the outcome of an evaluated run or conversation
+type Result struct {
+	t         TB
+	Content   string                      // Final assistant content
+	Messages  []agent.ConversationMessage // Full history
+	ToolCalls []agent.ToolCallRecord      // Tool calls of all turns, in order
+	Response  *agent.Response             // Set by Run
+	Err       error
+}
+
+// Run runs prompt through Agent.Run
+func (e *Eval) Run(prompt string, opts ...agent.RunOption) *Result {
+	e.t.Helper()
+
+	resp, err := e.Agent.Run(prompt, opts...)
+	result := &Result{t: e.t, Response: resp, Err: err}
+	if resp != nil {
+		result.Content = resp.Content
+		result.Messages = resp.Messages
+		result.ToolCalls = resp.ToolCalls
+	}
+	return result
+}
+
+// Converse sends messages to a new session one turn at a time and returns
+// the result after the last turn, or after the first failed one
+func (e *Eval) Converse(messages ...string) *Result {
+	e.t.Helper()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	session := e.Agent.NewSession(ctx)
+	defer session.Close()
+
+	result := &Result{t: e.t}
+	for _, message := range messages {
+		if err := session.Send(message); err != nil {
+			result.Err = err
+			break
+		}
+		if result.Err = waitTurn(session); result.Err != nil {
+			break
+		}
+	}
+
+	for _, msg := range session.GetHistory() {
+		result.Messages = append(result.Messages, msg.(agent.ConversationMessage))
+	}
+	for _, turn := range session.Conversations() {
+		result.ToolCalls = append(result.ToolCalls, turn.ToolCalls...)
+		result.Content = turn.AssistantMessage
+	}
+	return result
+}
+
+// waitTurn consumes session events until the current turn ends
+func waitTurn(session *agent.Session) error {
+	timeout := time.After(turnTimeout)
+	for {
+		select {
+		case event, ok := <-session.Events():
+			if !ok {
+				return errors.New("session closed during turn")
+			}
+			switch event.Type {
+			case agent.EventTurnComplete:
+				return nil
+			case agent.EventError:
+				return errors.New(event.Content)
+			}
+		case <-timeout:
+			return errors.New("timed out waiting for the turn to complete")
+		}
+	}
+}
+
+// AssertNoError fails the test if the run or conversation failed
+func (r *Result) AssertNoError() *Result {
+	r.t.Helper()
+	if r.Err != nil {
+		r.t.Errorf("unexpected error: %v", r.Err)
+	}
+	return r
+}
+
+// AssertError fails the test unless the run or conversation failed
+func (r *Result) AssertError() *Result {
+	r.t.Helper()
+	if r.Err == nil {
+		r.t.Errorf("expected an error, got none")
+	}
+	return r
+}
+
+// AssertErrorIs fails the test unless the error matches target, as
+// reported by errors.Is, e.g. agent.ErrMaxLoopsExceeded
+func (r *Result) AssertErrorIs(target error) *Result {
+	r.t.Helper()
+	if !errors.Is(r.Err, target) {
+		r.t.Errorf("error = %v, want %v", r.Err, target)
+	}
+	return r
+}
+
+// AssertContent fails the test unless the final content equals want
+func (r *Result) AssertContent(want string) *Result {
+	r.t.Helper()
+	if r.Content != want {
+		r.t.Errorf("final content = %q, want %q", r.Content, want)
+	}
+	return r
+}
+
+// AssertContentMatches fails the test unless the final content matches the
+// regular expression pattern
+func (r *Result) AssertContentMatches(pattern string) *Result {
+	r.t.Helper()
+	if !regexp.MustCompile(pattern).MatchString(r.Content) {
+		r.t.Errorf("final content %q does not match %q", r.Content, pattern)
+	}
+	return r
+}
+
+// AssertToolCalled fails the test unless tool name was called with args,
+// compared as JSON so key order and spacing don't matter. Nil args match
+// any arguments.
+func (r *Result) AssertToolCalled(name string, args any) *Result {
+	r.t.Helper()
+
+	for _, call := range r.ToolCalls {
+		if call.Name == name && (args == nil || sameJSON(call.Arguments, args)) {
+			return r
+		}
+	}
+	if args == nil {
+		r.t.Errorf("tool %s was not called; calls: %s", name, describeCalls(r.ToolCalls))
+	} else {
+		r.t.Errorf("tool %s was not called with %v; calls: %s", name, args, describeCalls(r.ToolCalls))
+	}
+	return r
+}
+
+// AssertToolNotCalled fails the test if tool name was called
+func (r *Result) AssertToolNotCalled(name string) *Result {
+	r.t.Helper()
+	for _, call := range r.ToolCalls {
+		if call.Name == name {
+			r.t.Errorf("tool %s was called with %s", name, call.Arguments)
+			return r
+		}
+	}
+	return r
+}
+
+// AssertToolCallCount fails the test unless exactly n tool calls were made
+func (r *Result) AssertToolCallCount(n int) *Result {
+	r.t.Helper()
+	if len(r.ToolCalls) != n {
+		r.t.Errorf("%d tool calls, want %d; calls: %s", len(r.ToolCalls), n, describeCalls(r.ToolCalls))
+	}
+	return r
+}
+
+// sameJSON reports whether the JSON text got encodes the same value as
+// want, which is either JSON text or a value to encode
+func sameJSON(got string, want any) bool {
+	var wantJSON []byte
+	switch w := want.(type) {
+	case string:
+		wantJSON = []byte(w)
+	case []byte:
+		wantJSON = w
+	case json.RawMessage:
+		wantJSON = w
+	default:
+		var err error
+		if wantJSON, err = json.Marshal(w); err != nil {
+			return false
+		}
+	}
+
+	var a, b any
+	if json.Unmarshal([]byte(got), &a) != nil || json.Unmarshal(wantJSON, &b) != nil {
+		return false
+	}
+	return reflect.DeepEqual(a, b)
+}
+
+// describeCalls lists tool calls for failure messages
+func describeCalls(calls []agent.ToolCallRecord) string {
+	if len(calls) == 0 {
+		return "none"
+	}
+	desc := ""
+	for i, call := range calls {
+		if i > 0 {
+			desc += ", "
+		}
+		desc += call.Name + call.Arguments
+	}
+	return desc
+}
@@ -0,0 +1,104 @@
+package agenttest
+
+import (
+	"encoding/json"
+	"sync"
+	"time"
+
+	"github.com/trogui/go-agent-sdk/agent"
+)
+
+// MockCall is a single invocation recorded by a MockRecorder
+type MockCall struct {
+	Args json.RawMessage
+	Time time.Time
+}
+
+// MockRecorder records the invocations of a tool created by MockTool. It is
+// safe for concurrent use.
+type MockRecorder struct {
+	name  string
+	mu    sync.Mutex
+	calls []MockCall
+}
+
+// MockTool returns a tool that answers every call with response, or fails
+// with it when it is an error, and a recorder of the calls it receives.
+// Register the tool without parameters, or set Parameters and Required on
+// it before registering.
+func MockTool(name, description string, response any) (*agent.Tool, *MockRecorder) {
+	recorder := &MockRecorder{name: name}
+	tool := &agent.Tool{
+		Name:        name,
+		Description: description,
+		Parameters:  map[string]agent.Parameter{},
+		Handler: func(args json.RawMessage) (any, error) {
+			recorder.mu.Lock()
+			recorder.calls = append(recorder.calls, MockCall{
+				Args: append(json.RawMessage(nil), args...),
+				Time: time.Now(),
+			})
+			recorder.mu.Unlock()
+
+			if err, ok := response.(error); ok {
+				return nil, err
+			}
+			return response, nil
+		},
+	}
+	return tool, recorder
+}
+
+// Calls returns the recorded calls, in order
+func (r *MockRecorder) Calls() []MockCall {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	return append([]MockCall(nil), r.calls...)
+}
+
+// CallCount returns the number of recorded calls
+func (r *MockRecorder) CallCount() int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	return len(r.calls)
+}
+
+// AssertCalledOnce fails the test unless the tool was called exactly once
+func (r *MockRecorder) AssertCalledOnce(t TB) *MockRecorder {
+	t.Helper()
+	if n := r.CallCount(); n != 1 {
+		t.Errorf("tool %s called %d times, want 1; calls: %s", r.name, n, r.describe())
+	}
+	return r
+}
+
+// AssertCalledWith fails the test unless the tool was called with args,
+// compared as JSON like Result.AssertToolCalled
+func (r *MockRecorder) AssertCalledWith(t TB, args any) *MockRecorder {
+	t.Helper()
+	for _, call := range r.Calls() {
+		if sameJSON(string(call.Args), args) {
+			return r
+		}
+	}
+	t.Errorf("tool %s was not called with %v; calls: %s", r.name, args, r.describe())
+	return r
+}
+
+// describe lists the recorded arguments for failure messages
+func (r *MockRecorder) describe() string {
+	calls := r.Calls()
+	if len(calls) == 0 {
+		return "none"
+	}
+	desc := ""
+	for i, call := range calls {
+		if i > 0 {
+			desc += ", "
+		}
+		desc += string(call.Args)
+	}
+	return desc
+}
@@ -0,0 +1,238 @@
+// Package agenttest provides a scripted model provider and helpers for
+// testing and evaluating agents without calling a real API.
+package agenttest
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+
+	"github.com/trogui/go-agent-sdk/agent"
+)
+
+// Response is a scripted model reply
+type Response struct {
+	Content   string
+	ToolCalls []ToolCall
+	// FinishReason defaults to "tool_calls" when ToolCalls is set and to
+	// "stop" otherwise
+	FinishReason string
+	Usage        *agent.Usage
+	// Status is the HTTP status code (default 200). Error statuses send
+	// Content as the body.
+	Status int
+}
+
+// ToolCall is a tool call in a scripted reply
+type ToolCall struct {
+	ID        string // Defaults to "call_<n>", numbered across the script
+	Name      string
+	Arguments string // JSON arguments, "{}" when empty
+}
+
+// Request is a request received by the Provider
+type Request struct {
+	Model    string                      `json:"model"`
+	Messages []agent.ConversationMessage `json:"messages"`
+	Tools    []json.RawMessage           `json:"tools"`
+	Body     json.RawMessage             `json:"-"` // The request body, decompressed
+	Header   http.Header                 `json:"-"`
+}
+
+// Provider is an http.RoundTripper answering chat completion requests with
+// scripted responses, in order. Use it as the transport of Config.HTTPClient.
+type Provider struct {
+	mu        sync.Mutex
+	responses []Response
+	repeat    *Response // Reply once responses is exhausted
+	requests  []Request
+	calls     int
+}
+
+// NewProvider returns a provider replying with responses, in order
+func NewProvider(responses ...Response) *Provider {
+	return &Provider{responses: responses}
+}
+
+// Add appends responses to the script
+func (p *Provider) Add(responses ...Response) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.responses = append(p.responses, responses...)
+}
+
+// Repeat sets a response replied to every request once the scripted
+// responses are used up, e.g. a tool call to drive a run into MaxLoops.
+// Tool call IDs stay unique across repetitions.
+func (p *Provider) Repeat(resp Response) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.repeat = &resp
+}
+
+// Client returns an HTTP client using the provider as its transport
+func (p *Provider) Client() *http.Client {
+	return &http.Client{Transport: p}
+}
+
+// Requests returns the requests received so far
+func (p *Provider) Requests() []Request {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	requests := make([]Request, len(p.requests))
+	copy(requests, p.requests)
+	return requests
+}
+
+// Remaining returns how many scripted responses have not been used
+func (p *Provider) Remaining() int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	return len(p.responses)
+}
+
+// RoundTrip records the request and replies with the next scripted response.
+// It fails once the script is exhausted, unless Repeat was called. HEAD
+// requests, such as warm-up pings, get an empty 200 response without
+// consuming the script.
+func (p *Provider) RoundTrip(req *http.Request) (*http.Response, error) {
+	if req.Method == http.MethodHead {
+		return reply(req, http.StatusOK, nil), nil
+	}
+
+	var body []byte
+	if req.Body != nil {
+		var err error
+		if body, err = io.ReadAll(req.Body); err != nil {
+			return nil, err
+		}
+		req.Body.Close()
+	}
+	body, err := decodeBody(req.Header, body)
+	if err != nil {
+		return nil, err
+	}
+
+	recorded := Request{Body: body, Header: req.Header.Clone()}
+	if err := json.Unmarshal(body, &recorded); err != nil {
+		return nil, fmt.Errorf("agenttest: invalid request body: %w", err)
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.requests = append(p.requests, recorded)
+	var resp Response
+	switch {
+	case len(p.responses) > 0:
+		resp = p.responses[0]
+		p.responses = p.responses[1:]
+	case p.repeat != nil:
+		resp = *p.repeat
+	default:
+		return nil, fmt.Errorf("agenttest: no scripted response left for request %d", len(p.requests))
+	}
+
+	if resp.Status != 0 && resp.Status != http.StatusOK {
+		return reply(req, resp.Status, []byte(resp.Content)), nil
+	}
+
+	data, err := json.Marshal(p.completion(resp))
+	if err != nil {
+		return nil, err
+	}
+	return reply(req, http.StatusOK, data), nil
+}
+
+// decodeBody decompresses a request body sent with Content-Encoding gzip,
+// as with Config.CompressRequests
+func decodeBody(header http.Header, body []byte) ([]byte, error) {
+	if !strings.EqualFold(header.Get("Content-Encoding"), "gzip") {
+		return body, nil
+	}
+	zr, err := gzip.NewReader(bytes.NewReader(body))
+	if err != nil {
+		return nil, fmt.Errorf("agenttest: invalid gzip request body: %w", err)
+	}
+	defer zr.Close()
+	return io.ReadAll(zr)
+}
+
+// completion renders a scripted response in the chat completions format.
+// It must be called with mu held.
+func (p *Provider) completion(resp Response) map[string]any {
+	message := map[string]any{
+		"role":    "assistant",
+		"content": resp.Content,
+	}
+
+	if len(resp.ToolCalls) > 0 {
+		calls := make([]agent.ToolCall, len(resp.ToolCalls))
+		for i, call := range resp.ToolCalls {
+			p.calls++
+			id := call.ID
+			if id == "" {
+				id = fmt.Sprintf("call_%d", p.calls)
+			}
+			args := call.Arguments
+			if args == "" {
+				args = "{}"
+			}
+			calls[i] = agent.ToolCall{
+				ID:       id,
+				Type:     "function",
+				Function: agent.FunctionCall{Name: call.Name, Arguments: args},
+			}
+		}
+		message["tool_calls"] = calls
+	}
+
+	reason := resp.FinishReason
+	if reason == "" {
+		reason = "stop"
+		if len(resp.ToolCalls) > 0 {
+			reason = "tool_calls"
+		}
+	}
+
+	completion := map[string]any{
+		"id": fmt.Sprintf("agenttest-%d", len(p.requests)),
+		"choices": []map[string]any{{
+			"index":         0,
+			"message":       message,
+			"finish_reason": reason,
+		}},
+	}
+	if resp.Usage != nil {
+		completion["usage"] = map[string]int{
+			"prompt_tokens":     resp.Usage.PromptTokens,
+			"completion_tokens": resp.Usage.CompletionTokens,
+			"total_tokens":      resp.Usage.TotalTokens,
+		}
+	}
+	return completion
+}
+
+// reply builds an HTTP response to req
+func reply(req *http.Request, status int, body []byte) *http.Response {
+	return &http.Response{
+		StatusCode:    status,
+		Status:        fmt.Sprintf("%d %s", status, http.StatusText(status)),
+		Proto:         "HTTP/1.1",
+		ProtoMajor:    1,
+		ProtoMinor:    1,
+		Header:        http.Header{"Content-Type": []string{"application/json"}},
+		Body:          io.NopCloser(bytes.NewReader(body)),
+		ContentLength: int64(len(body)),
+		Request:       req,
+	}
+}
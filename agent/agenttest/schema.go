@@ -0,0 +1,88 @@
+package agenttest
+
+import (
+	"encoding/json"
+	"regexp"
+
+	"github.com/trogui/go-agent-sdk/agent"
+)
+
+// validName matches the tool names accepted by OpenAI-compatible APIs
+var validName = regexp.MustCompile(`^[a-zA-Z0-9_-]{1,64}$`)
+
+// jsonSchemaTypes are the parameter types of JSON Schema
+var jsonSchemaTypes = map[string]bool{
+	"string":  true,
+	"number":  true,
+	"integer": true,
+	"boolean": true,
+	"array":   true,
+	"object":  true,
+	"null":    true,
+}
+
+// schemaTool is a tool schema as exported by Agent.ExportToolSchemas
+type schemaTool struct {
+	Type     string `json:"type"`
+	Function struct {
+		Name        string `json:"name"`
+		Description string `json:"description"`
+		Parameters  struct {
+			Type       string `json:"type"`
+			Properties map[string]struct {
+				Type        string `json:"type"`
+				Description string `json:"description"`
+				Items       *struct {
+					Type string `json:"type"`
+				} `json:"items"`
+			} `json:"properties"`
+			Required []string `json:"required"`
+		} `json:"parameters"`
+	} `json:"function"`
+}
+
+// ValidateToolSchemas checks the schema of every tool registered on ag as
+// sent to the API and reports each problem as a test error: a missing or
+// invalid name, an empty description, an unknown parameter type, an array
+// without item type or a required parameter that is not declared. Call it
+// once per test binary, e.g. from TestMain or a dedicated test.
+func ValidateToolSchemas(t TB, ag *agent.Agent) {
+	t.Helper()
+
+	data, err := ag.ExportToolSchemas()
+	if err != nil {
+		t.Fatalf("exporting tool schemas: %v", err)
+		return
+	}
+	var tools []schemaTool
+	if err := json.Unmarshal(data, &tools); err != nil {
+		t.Fatalf("decoding tool schemas: %v", err)
+		return
+	}
+
+	for _, tool := range tools {
+		fn := tool.Function
+		if !validName.MatchString(fn.Name) {
+			t.Errorf("tool %q: name must be 1-64 letters, digits, underscores or dashes", fn.Name)
+		}
+		if fn.Description == "" {
+			t.Errorf("tool %s: empty description", fn.Name)
+		}
+		if fn.Parameters.Type != "object" {
+			t.Errorf("tool %s: parameters type is %q, want \"object\"", fn.Name, fn.Parameters.Type)
+		}
+		for name, param := range fn.Parameters.Properties {
+			if !jsonSchemaTypes[param.Type] {
+				t.Errorf("tool %s: parameter %s has invalid type %q", fn.Name, name, param.Type)
+			}
+			if param.Type == "array" && (param.Items == nil || !jsonSchemaTypes[param.Items.Type]) {
+				t.Errorf("tool %s: array parameter %s needs a valid item type", fn.Name, name)
+			}
+		}
+		for _, name := range fn.Parameters.Required {
+			if _, ok := fn.Parameters.Properties[name]; !ok {
+				t.Errorf("tool %s: required parameter %s is not declared", fn.Name, name)
+			}
+		}
+	}
+}
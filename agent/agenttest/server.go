@@ -0,0 +1,167 @@
+package agenttest
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+
+	"github.com/trogui/go-agent-sdk/agent"
+)
+
+// chatCompletionsPath is the path of the endpoint served by Server
+const chatCompletionsPath = "/v1/chat/completions"
+
+// validRoles are the message roles accepted by Server
+var validRoles = map[string]bool{"system": true, "user": true, "assistant": true, "tool": true}
+
+// Server is an HTTP server simulating a chat completions API with scripted
+// responses, for tests that exercise the real transport: timeouts, proxies
+// or a custom Config.HTTPClient. Unlike a bare Provider it also checks
+// that requests carry APIKey and are well-formed chat completion requests.
+// The embedded Provider scripts the replies and records the requests.
+type Server struct {
+	*Provider
+	URL    string // Chat completions endpoint, for Config.APIURL
+	APIKey string // Key expected as a Bearer token, for Config.APIKey
+
+	t      TB
+	server *httptest.Server
+}
+
+// NewServer starts a server replying with responses, in order. Requests
+// without the "Bearer <APIKey>" Authorization header or with a malformed
+// body are answered with 401 or 400 and reported with t.Errorf. The server
+// is closed when the test ends if t has a Cleanup method, as testing.T
+// does; call Close otherwise.
+func NewServer(t TB, responses ...Response) *Server {
+	t.Helper()
+
+	s := &Server{
+		Provider: NewProvider(responses...),
+		APIKey:   "agenttest-key",
+		t:        t,
+	}
+	s.server = httptest.NewServer(http.HandlerFunc(s.serveHTTP))
+	s.URL = s.server.URL + chatCompletionsPath
+	if c, ok := t.(interface{ Cleanup(func()) }); ok {
+		c.Cleanup(s.Close)
+	}
+	return s
+}
+
+// Close shuts the server down
+func (s *Server) Close() {
+	s.server.Close()
+}
+
+// serveHTTP validates a request and replies with the next scripted response
+func (s *Server) serveHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path != chatCompletionsPath {
+		s.fail(w, http.StatusNotFound, fmt.Sprintf("unexpected path %s", r.URL.Path))
+		return
+	}
+	if r.Method == http.MethodHead {
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+	if r.Method != http.MethodPost {
+		s.fail(w, http.StatusMethodNotAllowed, fmt.Sprintf("unexpected method %s", r.Method))
+		return
+	}
+	if got, want := r.Header.Get("Authorization"), "Bearer "+s.APIKey; got != want {
+		s.fail(w, http.StatusUnauthorized, fmt.Sprintf("Authorization header is %q, want %q", got, want))
+		return
+	}
+
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		s.fail(w, http.StatusBadRequest, fmt.Sprintf("reading request body: %v", err))
+		return
+	}
+	decoded, err := decodeBody(r.Header, body)
+	if err != nil {
+		s.fail(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	if err := validateRequest(decoded); err != nil {
+		s.fail(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	// RoundTrip decodes the body again when it records the request
+	r.Body = io.NopCloser(bytes.NewReader(body))
+
+	resp, err := s.RoundTrip(r)
+	if err != nil {
+		s.fail(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	defer resp.Body.Close()
+
+	for name, values := range resp.Header {
+		w.Header()[name] = values
+	}
+	w.WriteHeader(resp.StatusCode)
+	io.Copy(w, resp.Body)
+}
+
+// fail reports a rejected request to the test and answers it with an
+// OpenAI-style error body
+func (s *Server) fail(w http.ResponseWriter, status int, message string) {
+	s.t.Errorf("agenttest: server: %s", message)
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(map[string]any{
+		"error": map[string]string{"message": message},
+	})
+}
+
+// validateRequest checks the structure of a chat completion request body
+func validateRequest(body []byte) error {
+	var req struct {
+		Model    string `json:"model"`
+		Messages []struct {
+			Role       string           `json:"role"`
+			ToolCallID string           `json:"tool_call_id"`
+			ToolCalls  []agent.ToolCall `json:"tool_calls"`
+		} `json:"messages"`
+		Tools []struct {
+			Type     string `json:"type"`
+			Function struct {
+				Name string `json:"name"`
+			} `json:"function"`
+		} `json:"tools"`
+	}
+	if err := json.Unmarshal(body, &req); err != nil {
+		return fmt.Errorf("invalid request body: %v", err)
+	}
+
+	if req.Model == "" {
+		return fmt.Errorf("request has no model")
+	}
+	if len(req.Messages) == 0 {
+		return fmt.Errorf("request has no messages")
+	}
+	for i, msg := range req.Messages {
+		if !validRoles[msg.Role] {
+			return fmt.Errorf("messages[%d] has invalid role %q", i, msg.Role)
+		}
+		if msg.Role == "tool" && msg.ToolCallID == "" {
+			return fmt.Errorf("messages[%d] is a tool message without tool_call_id", i)
+		}
+		for j, call := range msg.ToolCalls {
+			if call.ID == "" || call.Function.Name == "" {
+				return fmt.Errorf("messages[%d].tool_calls[%d] needs an id and a function name", i, j)
+			}
+		}
+	}
+	for i, tool := range req.Tools {
+		if tool.Type != "function" || tool.Function.Name == "" {
+			return fmt.Errorf("tools[%d] must be a function with a name", i)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,166 @@
+package agenttest_test
+
+import (
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/trogui/go-agent-sdk/agent"
+	"github.com/trogui/go-agent-sdk/agent/agenttest"
+)
+
+// fakeTB records the failures reported by a Server
+type fakeTB struct {
+	mu     sync.Mutex
+	errors []string
+}
+
+func (f *fakeTB) Helper() {}
+
+func (f *fakeTB) Errorf(format string, args ...any) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.errors = append(f.errors, fmt.Sprintf(format, args...))
+}
+
+func (f *fakeTB) Fatalf(format string, args ...any) {
+	f.Errorf(format, args...)
+}
+
+func (f *fakeTB) reported() []string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	return append([]string(nil), f.errors...)
+}
+
+func newServer(t *testing.T, responses ...agenttest.Response) (*agenttest.Server, *fakeTB) {
+	tb := &fakeTB{}
+	s := agenttest.NewServer(tb, responses...)
+	t.Cleanup(s.Close)
+	return s, tb
+}
+
+func TestServer(t *testing.T) {
+	for _, compress := range []bool{false, true} {
+		t.Run(fmt.Sprintf("compress=%t", compress), func(t *testing.T) {
+			s, tb := newServer(t, agenttest.Response{Content: "hello"})
+			a, err := agent.New(agent.Config{
+				APIKey:           s.APIKey,
+				APIURL:           s.URL,
+				Model:            "test-model",
+				SystemPrompt:     "You are a helpful assistant.",
+				CompressRequests: compress,
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			resp, err := a.Run("hi")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if resp.Content != "hello" {
+				t.Errorf("Content = %q, want hello", resp.Content)
+			}
+			if errs := tb.reported(); len(errs) > 0 {
+				t.Errorf("server reported %q for a valid request", errs)
+			}
+			req := s.Requests()[0]
+			if req.Model != "test-model" || len(req.Messages) != 2 {
+				t.Errorf("recorded request = %+v, want the decoded body", req)
+			}
+		})
+	}
+}
+
+func TestServerAuthorization(t *testing.T) {
+	s, tb := newServer(t, agenttest.Response{Content: "hello"})
+	a, err := agent.New(agent.Config{
+		APIKey:       "wrong-key",
+		APIURL:       s.URL,
+		Model:        "test-model",
+		SystemPrompt: "You are a helpful assistant.",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := a.Run("hi"); err == nil {
+		t.Fatal("Run succeeded with the wrong key")
+	}
+	errs := tb.reported()
+	if len(errs) != 1 || !strings.Contains(errs[0], `Authorization header is "Bearer wrong-key"`) {
+		t.Errorf("server reported %q, want the Authorization header", errs)
+	}
+	if s.Remaining() != 1 {
+		t.Error("a rejected request consumed the script")
+	}
+}
+
+func TestServerValidation(t *testing.T) {
+	gzipped := func(body string) []byte {
+		var buf bytes.Buffer
+		zw := gzip.NewWriter(&buf)
+		zw.Write([]byte(body))
+		zw.Close()
+		return buf.Bytes()
+	}
+
+	tests := []struct {
+		name   string
+		method string
+		path   string
+		gzip   bool
+		body   []byte
+		status int
+		want   string
+	}{
+		{"path", http.MethodPost, "/v1/completions", false, []byte(`{}`), http.StatusNotFound, "unexpected path /v1/completions"},
+		{"method", http.MethodGet, "", false, nil, http.StatusMethodNotAllowed, "unexpected method GET"},
+		{"not JSON", http.MethodPost, "", false, []byte(`model: m`), http.StatusBadRequest, "invalid request body"},
+		{"no model", http.MethodPost, "", false, []byte(`{"messages":[{"role":"user","content":"hi"}]}`), http.StatusBadRequest, "request has no model"},
+		{"no messages", http.MethodPost, "", false, []byte(`{"model":"m","messages":[]}`), http.StatusBadRequest, "request has no messages"},
+		{"role", http.MethodPost, "", false, []byte(`{"model":"m","messages":[{"role":"bot","content":"hi"}]}`), http.StatusBadRequest, `messages[0] has invalid role "bot"`},
+		{"tool message", http.MethodPost, "", false, []byte(`{"model":"m","messages":[{"role":"user"},{"role":"tool","content":"42"}]}`), http.StatusBadRequest, "messages[1] is a tool message without tool_call_id"},
+		{"tool call", http.MethodPost, "", false, []byte(`{"model":"m","messages":[{"role":"assistant","tool_calls":[{"id":"c1","type":"function","function":{"arguments":"{}"}}]}]}`), http.StatusBadRequest, "messages[0].tool_calls[0] needs an id and a function name"},
+		{"tool", http.MethodPost, "", false, []byte(`{"model":"m","messages":[{"role":"user"}],"tools":[{"type":"function","function":{}}]}`), http.StatusBadRequest, "tools[0] must be a function with a name"},
+		{"gzipped and invalid", http.MethodPost, "", true, gzipped(`{"messages":[{"role":"user"}]}`), http.StatusBadRequest, "request has no model"},
+		{"corrupt gzip", http.MethodPost, "", true, []byte("not gzip"), http.StatusBadRequest, "invalid gzip request body"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s, tb := newServer(t, agenttest.Response{Content: "hello"})
+			url := s.URL
+			if tt.path != "" {
+				url = strings.TrimSuffix(s.URL, "/v1/chat/completions") + tt.path
+			}
+			req, err := http.NewRequest(tt.method, url, bytes.NewReader(tt.body))
+			if err != nil {
+				t.Fatal(err)
+			}
+			req.Header.Set("Authorization", "Bearer "+s.APIKey)
+			if tt.gzip {
+				req.Header.Set("Content-Encoding", "gzip")
+			}
+
+			resp, err := http.DefaultClient.Do(req)
+			if err != nil {
+				t.Fatal(err)
+			}
+			resp.Body.Close()
+			if resp.StatusCode != tt.status {
+				t.Errorf("status = %d, want %d", resp.StatusCode, tt.status)
+			}
+			errs := tb.reported()
+			if len(errs) != 1 || !strings.Contains(errs[0], tt.want) {
+				t.Errorf("server reported %q, want %q", errs, tt.want)
+			}
+		})
+	}
+}
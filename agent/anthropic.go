@@ -0,0 +1,91 @@
+package agent
+
+import (
+	"encoding/json"
+	"fmt"
+)
+
+// anthropicMessage is a message in Anthropic's Messages API format
+type anthropicMessage struct {
+	Role    string           `json:"role"`
+	Content []anthropicBlock `json:"content"`
+}
+
+// anthropicBlock is a content block of an anthropicMessage
+type anthropicBlock struct {
+	Type      string          `json:"type"`
+	Text      string          `json:"text,omitempty"`
+	ID        string          `json:"id,omitempty"`
+	Name      string          `json:"name,omitempty"`
+	Input     json.RawMessage `json:"input,omitempty"`
+	ToolUseID string          `json:"tool_use_id,omitempty"`
+	Content   string          `json:"content,omitempty"`
+}
+
+// ConvertMessagesToAnthropic translates messages to the "messages" array of
+// Anthropic's Messages API. Tool calls become tool_use blocks and tool
+// responses tool_result blocks in a user message. Consecutive messages with
+// the same role are merged, as the API requires alternating roles. System
+// and transient messages are left out; send the system prompt in the
+// top-level "system" field.
+func (a *Agent) ConvertMessagesToAnthropic(messages []ConversationMessage) ([]byte, error) {
+	converted := make([]anthropicMessage, 0, len(messages))
+
+	for _, msg := range messages {
+		if msg.Transient || msg.Role == "system" {
+			continue
+		}
+
+		var role string
+		var blocks []anthropicBlock
+		switch msg.Role {
+		case "user":
+			role = "user"
+			blocks = append(blocks, anthropicBlock{Type: "text", Text: msg.Content})
+		case "assistant":
+			role = "assistant"
+			if msg.Content != "" {
+				blocks = append(blocks, anthropicBlock{Type: "text", Text: msg.Content})
+			}
+			for _, call := range msg.ToolCalls {
+				input := json.RawMessage(call.Function.Arguments)
+				if len(input) == 0 {
+					input = json.RawMessage("{}")
+				}
+				if !json.Valid(input) {
+					return nil, fmt.Errorf("tool call %s has invalid JSON arguments", call.ID)
+				}
+				blocks = append(blocks, anthropicBlock{
+					Type:  "tool_use",
+					ID:    call.ID,
+					Name:  call.Function.Name,
+					Input: input,
+				})
+			}
+		case "tool":
+			role = "user"
+			blocks = append(blocks, anthropicBlock{
+				Type:      "tool_result",
+				ToolUseID: msg.ToolCallID,
+				Content:   msg.Content,
+			})
+		default:
+			return nil, fmt.Errorf("unsupported message role: %s", msg.Role)
+		}
+
+		if len(blocks) == 0 {
+			continue
+		}
+		if n := len(converted); n > 0 && converted[n-1].Role == role {
+			converted[n-1].Content = append(converted[n-1].Content, blocks...)
+			continue
+		}
+		converted = append(converted, anthropicMessage{Role: role, Content: blocks})
+	}
+
+	data, err := json.Marshal(converted)
+	if err != nil {
+		return nil, fmt.Errorf("error encoding messages: %w", err)
+	}
+	return data, nil
+}
@@ -0,0 +1,54 @@
+package agent
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"math"
+	"math/big"
+)
+
+// DecodeArgs decodes tool arguments into v. Numbers that land in interface
+// values (any, map[string]any, []any) are decoded as json.Number instead of
+// float64, so large integer IDs survive exactly. Convert them with
+// NumberToInt64 or NumberToFloat64.
+func DecodeArgs(args json.RawMessage, v any) error {
+	dec := json.NewDecoder(bytes.NewReader(args))
+	dec.UseNumber()
+	if err := dec.Decode(v); err != nil {
+		return fmt.Errorf("invalid arguments: %w", err)
+	}
+	return nil
+}
+
+// NumberToInt64 converts a JSON number to an int64. Integral values written
+// with a fraction or exponent such as 3.0 or 1e3 are accepted. The error
+// message is meant to be returned to the model as is.
+func NumberToInt64(n json.Number) (int64, error) {
+	if i, err := n.Int64(); err == nil {
+		return i, nil
+	}
+
+	f, _, err := big.ParseFloat(n.String(), 10, 256, big.ToNearestEven)
+	if err != nil {
+		return 0, fmt.Errorf("%q is not a valid number", n.String())
+	}
+	if !f.IsInt() {
+		return 0, fmt.Errorf("%s is not an integer", n.String())
+	}
+	i, accuracy := f.Int64()
+	if accuracy != big.Exact {
+		return 0, fmt.Errorf("%s is out of range for a 64-bit integer", n.String())
+	}
+	return i, nil
+}
+
+// NumberToFloat64 converts a JSON number to a float64, rejecting values that
+// overflow. The error message is meant to be returned to the model as is.
+func NumberToFloat64(n json.Number) (float64, error) {
+	f, err := n.Float64()
+	if err != nil || math.IsInf(f, 0) {
+		return 0, fmt.Errorf("%q is not a representable number", n.String())
+	}
+	return f, nil
+}
@@ -0,0 +1,53 @@
+package agent
+
+import (
+	"context"
+	"encoding/json"
+	"sync"
+)
+
+// asyncAck is returned to the model when an async tool has been dispatched
+var asyncAck = map[string]string{"status": "dispatched"}
+
+// dispatchAsync runs an async tool in the background and returns the
+// acknowledgment sent to the model. The handler keeps running when the run or
+// session context is cancelled; wg tracks it until it returns.
+func (a *Agent) dispatchAsync(ctx context.Context, wg *sync.WaitGroup, name string, args json.RawMessage) any {
+	ctx = context.WithoutCancel(ctx)
+	args = append(json.RawMessage(nil), args...)
+
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+
+		if _, err := a.executeTool(ctx, name, args); err != nil {
+			a.log().Error().Err(err).Str("tool", name).Msg("[Agent] Async tool execution error")
+			return
+		}
+		a.log().Debug().Str("tool", name).Msg("[Agent] Async tool finished")
+	}()
+
+	return asyncAck
+}
+
+// WaitAsync blocks until every async tool dispatched by Run has returned
+func (a *Agent) WaitAsync() {
+	a.async.Wait()
+}
+
+// AsyncToolHandler wraps handler so that it runs in a background goroutine.
+// The returned handler answers {"status":"accepted"} to the model at once
+// and calls callback, if non-nil, with the handler's outcome when it returns.
+// Unlike Tool.Async, the goroutine is not tracked by Close or WaitAsync.
+func AsyncToolHandler(handler ToolHandler, callback func(result any, err error)) ToolHandler {
+	return func(args json.RawMessage) (any, error) {
+		args = append(json.RawMessage(nil), args...)
+		go func() {
+			result, err := handler(args)
+			if callback != nil {
+				callback(result, err)
+			}
+		}()
+		return map[string]string{"status": "accepted"}, nil
+	}
+}
@@ -0,0 +1,152 @@
+package agent
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sync"
+)
+
+// ReadUserAttachment is the name of the tool registered when
+// Config.LargeInputHandling is set
+const ReadUserAttachment = "read_user_attachment"
+
+// LargeInputHandling configures how oversized user messages are handled.
+// Such a message is stored in full for the run or session and replaced in
+// the history by an excerpt and a note; the model reads the rest on demand
+// with the read_user_attachment tool.
+type LargeInputHandling struct {
+	Threshold    int // Estimated tokens above which a message is stored (default 8000)
+	ExcerptChars int // Characters of the message kept in the history (default 2000)
+	MaxReadChars int // Characters returned by one read_user_attachment call (default 8000)
+}
+
+// applyDefaults fills in the zero fields
+func (h *LargeInputHandling) applyDefaults() {
+	if h.Threshold == 0 {
+		h.Threshold = 8000
+	}
+	if h.ExcerptChars == 0 {
+		h.ExcerptChars = 2000
+	}
+	if h.MaxReadChars == 0 {
+		h.MaxReadChars = 8000
+	}
+}
+
+// attachmentStore holds the full text of the large user messages of a run
+// or session
+type attachmentStore struct {
+	mu    sync.Mutex
+	texts [][]rune
+}
+
+// attachmentsKey is the context key of the attachment store
+type attachmentsKey struct{}
+
+// withAttachments returns ctx carrying store for the read_user_attachment tool
+func withAttachments(ctx context.Context, store *attachmentStore) context.Context {
+	return context.WithValue(ctx, attachmentsKey{}, store)
+}
+
+// add stores text and returns its attachment ID
+func (s *attachmentStore) add(text string) string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.texts = append(s.texts, []rune(text))
+	return fmt.Sprintf("attachment-%d", len(s.texts))
+}
+
+// get returns the text of an attachment, or nil
+func (s *attachmentStore) get(id string) []rune {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var n int
+	if _, err := fmt.Sscanf(id, "attachment-%d", &n); err != nil || n < 1 || n > len(s.texts) {
+		return nil
+	}
+	return s.texts[n-1]
+}
+
+// clear drops every attachment
+func (s *attachmentStore) clear() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.texts = nil
+}
+
+// userContent returns the history content of a user message. Messages over
+// the LargeInputHandling threshold are stored in the context's attachment
+// store and replaced by an excerpt and a note pointing to the attachment.
+func (a *Agent) userContent(ctx context.Context, message string) string {
+	h := a.config.LargeInputHandling
+	if h == nil || len(message)/charsPerToken <= h.Threshold {
+		return message
+	}
+	store, ok := ctx.Value(attachmentsKey{}).(*attachmentStore)
+	if !ok {
+		return message
+	}
+
+	text := []rune(message)
+	id := store.add(message)
+	a.log().Info().
+		Str("attachment", id).
+		Int("chars", len(text)).
+		Msg("[Agent] Large user message stored as attachment")
+
+	excerpt := string(text[:min(h.ExcerptChars, len(text))])
+	return fmt.Sprintf("%s\n\n[Truncated: this message has %d characters. The full text is stored as attachment %q; call %s to read it.]",
+		excerpt, len(text), id, ReadUserAttachment)
+}
+
+// attachmentTool returns the read_user_attachment tool, reading at most
+// limit characters per call
+func attachmentTool(limit int) *Tool {
+	return &Tool{
+		Name:        ReadUserAttachment,
+		Description: "Read a range of characters of a long user message that was truncated and stored as an attachment.",
+		Parameters: map[string]Parameter{
+			"id":     {Type: "string", Description: "Attachment ID from the truncation note, e.g. \"attachment-1\""},
+			"offset": {Type: "integer", Description: "Index of the first character to read, starting at 0"},
+			"length": {Type: "integer", Description: fmt.Sprintf("Number of characters to read, at most %d", limit)},
+		},
+		Required: []string{"id"},
+		Version:  "1",
+		Executor: ToolExecutorFunc(func(ctx context.Context, args json.RawMessage) (any, error) {
+			var payload struct {
+				ID     string `json:"id"`
+				Offset int    `json:"offset"`
+				Length int    `json:"length"`
+			}
+			if err := json.Unmarshal(args, &payload); err != nil {
+				return nil, err
+			}
+
+			store, ok := ctx.Value(attachmentsKey{}).(*attachmentStore)
+			if !ok {
+				return nil, fmt.Errorf("no attachments in this conversation")
+			}
+			text := store.get(payload.ID)
+			if text == nil {
+				return nil, fmt.Errorf("unknown attachment: %s", payload.ID)
+			}
+
+			if payload.Length <= 0 || payload.Length > limit {
+				payload.Length = limit
+			}
+			start := min(max(payload.Offset, 0), len(text))
+			end := min(start+payload.Length, len(text))
+			return map[string]any{
+				"id":          payload.ID,
+				"offset":      start,
+				"text":        string(text[start:end]),
+				"total_chars": len(text),
+				"more":        end < len(text),
+			}, nil
+		}),
+	}
+}
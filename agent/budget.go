@@ -0,0 +1,69 @@
+package agent
+
+import (
+	"fmt"
+	"strings"
+	"text/template"
+)
+
+// DefaultBudgetNote is a ready-made Config.BudgetNote
+const DefaultBudgetNote = "You have {{.IterationsLeft}} iterations{{if .TokenLimited}} and ~{{.TokensLeft}} tokens{{end}} remaining, including this one. Plan your remaining steps accordingly and answer before the budget runs out."
+
+// BudgetStatus is the data of the Config.BudgetNote template
+type BudgetStatus struct {
+	Iteration      int  // Current iteration, from 1
+	IterationsLeft int  // Iterations left including the current one
+	TokensUsed     int  // Tokens spent so far in the run or turn
+	TokensLeft     int  // MaxTotalTokens minus TokensUsed, when TokenLimited
+	TokenLimited   bool // Whether MaxTotalTokens is set
+}
+
+// parseBudgetNote parses Config.BudgetNote, returning nil when it is unset
+func parseBudgetNote(note string) (*template.Template, error) {
+	if note == "" {
+		return nil, nil
+	}
+	tmpl, err := template.New("budget").Parse(note)
+	if err != nil {
+		return nil, fmt.Errorf("invalid budget note template: %w", err)
+	}
+	return tmpl, nil
+}
+
+// budget returns the budget left at the start of the current iteration
+func (l *loop) budget() BudgetStatus {
+	status := BudgetStatus{
+		Iteration:      l.loopCount,
+		IterationsLeft: l.maxLoops - l.loopCount + 1,
+		TokensUsed:     l.usage.TotalTokens,
+	}
+	if limit := l.agent.config.MaxTotalTokens; limit > 0 {
+		status.TokenLimited = true
+		status.TokensLeft = max(limit-l.usage.TotalTokens, 0)
+	}
+	return status
+}
+
+// budgetNote queues the budget note for the next request
+func (l *loop) budgetNote() {
+	if l.agent.budgetNote == nil {
+		return
+	}
+
+	var note strings.Builder
+	if err := l.agent.budgetNote.Execute(&note, l.budget()); err != nil {
+		l.agent.log().Warn().Err(err).Msg(l.logPrefix + " Budget note failed")
+		return
+	}
+	l.extra = append(l.extra, ConversationMessage{Role: "system", Content: note.String()})
+}
+
+// checkTokenBudget returns ErrTokenBudgetExceeded once the run or turn has
+// spent Config.MaxTotalTokens
+func (l *loop) checkTokenBudget() error {
+	limit := l.agent.config.MaxTotalTokens
+	if limit <= 0 || l.usage.TotalTokens < limit {
+		return nil
+	}
+	return fmt.Errorf("%w: used %d of %d tokens", ErrTokenBudgetExceeded, l.usage.TotalTokens, limit)
+}
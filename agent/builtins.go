@@ -0,0 +1,416 @@
+package agent
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"strconv"
+	"time"
+	"unicode"
+)
+
+// Names of the built-in tools accepted by EnableBuiltins
+const (
+	BuiltinCalculator = "calculator"
+	BuiltinDatetime   = "datetime"
+)
+
+// Limits protecting the calculator from pathological input
+const (
+	maxExpressionLength = 1000
+	maxExpressionDepth  = 100
+)
+
+// builtinTools returns the built-in tools by name, reading the time from
+// the given clock
+var builtinTools = map[string]func(c Clock) *Tool{
+	BuiltinCalculator: func(Clock) *Tool { return BuiltinCalculatorTool() },
+	BuiltinDatetime:   datetimeTool,
+}
+
+// EnableBuiltins registers the named built-in tools. Unknown names return an
+// error and register nothing.
+func (a *Agent) EnableBuiltins(names []string) error {
+	tools := make([]*Tool, 0, len(names))
+	for _, name := range names {
+		newTool, ok := builtinTools[name]
+		if !ok {
+			return fmt.Errorf("unknown builtin tool: %s", name)
+		}
+		tools = append(tools, newTool(a.clock))
+	}
+
+	a.RegisterTools(tools...)
+	return nil
+}
+
+// BuiltinCalculatorTool returns a tool evaluating arithmetic expressions with
+// + - * / % ^ and parentheses. Expressions are parsed, never executed as code.
+func BuiltinCalculatorTool() *Tool {
+	return &Tool{
+		Name:        BuiltinCalculator,
+		Description: "Evaluate an arithmetic expression exactly. Supports + - * / % ^ (power), unary minus, decimals and parentheses. Use it for any arithmetic instead of computing in your head.",
+		Parameters: map[string]Parameter{
+			"expression": {
+				Type:        "string",
+				Description: "The expression to evaluate, e.g. \"(12.5 * 4) ^ 2 % 7\"",
+			},
+		},
+		Required: []string{"expression"},
+		Version:  "1",
+		Handler: func(args json.RawMessage) (any, error) {
+			var payload struct {
+				Expression string `json:"expression"`
+			}
+			if err := json.Unmarshal(args, &payload); err != nil {
+				return nil, err
+			}
+
+			result, err := evaluateExpression(payload.Expression)
+			if err != nil {
+				return nil, err
+			}
+			return map[string]any{
+				"expression": payload.Expression,
+				"result":     result,
+			}, nil
+		},
+	}
+}
+
+// evaluateExpression parses and evaluates an arithmetic expression
+func evaluateExpression(expr string) (float64, error) {
+	if len(expr) > maxExpressionLength {
+		return 0, fmt.Errorf("expression is longer than %d characters", maxExpressionLength)
+	}
+
+	p := &exprParser{input: []rune(expr)}
+	value, err := p.parseSum()
+	if err != nil {
+		return 0, err
+	}
+	p.skipSpaces()
+	if p.pos < len(p.input) {
+		return 0, fmt.Errorf("unexpected %q at position %d", p.input[p.pos], p.pos+1)
+	}
+	if math.IsInf(value, 0) || math.IsNaN(value) {
+		return 0, fmt.Errorf("result overflows")
+	}
+	return value, nil
+}
+
+// exprParser is a recursive descent parser over the grammar:
+//
+//	sum     = product { ("+" | "-") product }
+//	product = unary { ("*" | "/" | "%") unary }
+//	unary   = "-" unary | "+" unary | power
+//	power   = primary [ "^" unary ]
+//	primary = number | "(" sum ")"
+type exprParser struct {
+	input []rune
+	pos   int
+	depth int
+}
+
+func (p *exprParser) skipSpaces() {
+	for p.pos < len(p.input) && unicode.IsSpace(p.input[p.pos]) {
+		p.pos++
+	}
+}
+
+// peek returns the next non-space rune, or 0 at the end of input
+func (p *exprParser) peek() rune {
+	p.skipSpaces()
+	if p.pos >= len(p.input) {
+		return 0
+	}
+	return p.input[p.pos]
+}
+
+func (p *exprParser) parseSum() (float64, error) {
+	left, err := p.parseProduct()
+	if err != nil {
+		return 0, err
+	}
+	for {
+		op := p.peek()
+		if op != '+' && op != '-' {
+			return left, nil
+		}
+		p.pos++
+		right, err := p.parseProduct()
+		if err != nil {
+			return 0, err
+		}
+		if op == '+' {
+			left += right
+		} else {
+			left -= right
+		}
+	}
+}
+
+func (p *exprParser) parseProduct() (float64, error) {
+	left, err := p.parseUnary()
+	if err != nil {
+		return 0, err
+	}
+	for {
+		op := p.peek()
+		if op != '*' && op != '/' && op != '%' {
+			return left, nil
+		}
+		p.pos++
+		right, err := p.parseUnary()
+		if err != nil {
+			return 0, err
+		}
+		switch op {
+		case '*':
+			left *= right
+		case '/':
+			if right == 0 {
+				return 0, fmt.Errorf("division by zero")
+			}
+			left /= right
+		case '%':
+			if right == 0 {
+				return 0, fmt.Errorf("modulo by zero")
+			}
+			left = math.Mod(left, right)
+		}
+	}
+}
+
+func (p *exprParser) parseUnary() (float64, error) {
+	switch p.peek() {
+	case '-':
+		p.pos++
+		value, err := p.nested(p.parseUnary)
+		return -value, err
+	case '+':
+		p.pos++
+		return p.nested(p.parseUnary)
+	}
+	return p.parsePower()
+}
+
+func (p *exprParser) parsePower() (float64, error) {
+	base, err := p.parsePrimary()
+	if err != nil {
+		return 0, err
+	}
+	if p.peek() != '^' {
+		return base, nil
+	}
+	p.pos++
+	// Right associative: 2^3^2 is 2^(3^2)
+	exponent, err := p.nested(p.parseUnary)
+	if err != nil {
+		return 0, err
+	}
+	result := math.Pow(base, exponent)
+	if math.IsNaN(result) {
+		return 0, fmt.Errorf("%g ^ %g is not a real number", base, exponent)
+	}
+	return result, nil
+}
+
+func (p *exprParser) parsePrimary() (float64, error) {
+	r := p.peek()
+	switch {
+	case r == 0:
+		return 0, fmt.Errorf("unexpected end of expression")
+	case r == '(':
+		p.pos++
+		value, err := p.nested(p.parseSum)
+		if err != nil {
+			return 0, err
+		}
+		if p.peek() != ')' {
+			return 0, fmt.Errorf("missing closing parenthesis")
+		}
+		p.pos++
+		return value, nil
+	case unicode.IsDigit(r) || r == '.':
+		start := p.pos
+		for p.pos < len(p.input) && (unicode.IsDigit(p.input[p.pos]) || p.input[p.pos] == '.') {
+			p.pos++
+		}
+		value, err := strconv.ParseFloat(string(p.input[start:p.pos]), 64)
+		if err != nil {
+			return 0, fmt.Errorf("invalid number %q", string(p.input[start:p.pos]))
+		}
+		return value, nil
+	default:
+		return 0, fmt.Errorf("unexpected %q at position %d", r, p.pos+1)
+	}
+}
+
+// nested calls parse one nesting level deeper, bounding the recursion
+func (p *exprParser) nested(parse func() (float64, error)) (float64, error) {
+	p.depth++
+	defer func() { p.depth-- }()
+	if p.depth > maxExpressionDepth {
+		return 0, fmt.Errorf("expression is nested too deeply")
+	}
+	return parse()
+}
+
+// BuiltinDatetimeTool returns a tool for the current time, timezone
+// conversion, date arithmetic, differences and formatting. Timezones are IANA
+// names and need the system tz database (or an import of time/tzdata).
+// EnableBuiltins registers it with the agent's Config.Clock instead of the
+// system clock.
+func BuiltinDatetimeTool() *Tool {
+	return datetimeTool(realClock{})
+}
+
+// datetimeTool returns the datetime tool reading the current time from c
+func datetimeTool(c Clock) *Tool {
+	return &Tool{
+		Name:        BuiltinDatetime,
+		Description: "Date and time utility. Operations: \"now\" (current time in a timezone), \"convert\" (datetime to another timezone), \"add\" (add years/months/days/hours/minutes/seconds, negative to subtract), \"diff\" (time between datetime and other), \"format\" (render datetime with a Go layout).",
+		Parameters: map[string]Parameter{
+			"operation": {Type: "string", Description: "One of now, convert, add, diff, format"},
+			"datetime":  {Type: "string", Description: "Input datetime in RFC 3339, e.g. 2025-03-01T14:00:00Z (not used by now)"},
+			"other":     {Type: "string", Description: "Second RFC 3339 datetime for diff"},
+			"timezone":  {Type: "string", Description: "IANA timezone for now, or to convert into for convert, e.g. Europe/Paris (default UTC)"},
+			"years":     {Type: "integer", Description: "Years to add"},
+			"months":    {Type: "integer", Description: "Months to add"},
+			"days":      {Type: "integer", Description: "Days to add"},
+			"hours":     {Type: "integer", Description: "Hours to add"},
+			"minutes":   {Type: "integer", Description: "Minutes to add"},
+			"seconds":   {Type: "integer", Description: "Seconds to add"},
+			"layout":    {Type: "string", Description: "Go time layout for format, e.g. \"Monday, 02 Jan 2006 15:04\""},
+		},
+		Required: []string{"operation"},
+		Version:  "1",
+		Handler:  datetimeHandler(c),
+	}
+}
+
+// datetimeArgs are the arguments of the datetime tool
+type datetimeArgs struct {
+	Operation string `json:"operation"`
+	Datetime  string `json:"datetime"`
+	Other     string `json:"other"`
+	Timezone  string `json:"timezone"`
+	Years     int    `json:"years"`
+	Months    int    `json:"months"`
+	Days      int    `json:"days"`
+	Hours     int    `json:"hours"`
+	Minutes   int    `json:"minutes"`
+	Seconds   int    `json:"seconds"`
+	Layout    string `json:"layout"`
+}
+
+// datetimeHandler returns the handler of the datetime tool, with "now"
+// reading c
+func datetimeHandler(c Clock) ToolHandler {
+	return func(args json.RawMessage) (any, error) {
+		return datetime(c, args)
+	}
+}
+
+func datetime(c Clock, args json.RawMessage) (any, error) {
+	var payload datetimeArgs
+	if err := json.Unmarshal(args, &payload); err != nil {
+		return nil, err
+	}
+
+	loc := time.UTC
+	if payload.Timezone != "" {
+		var err error
+		if loc, err = time.LoadLocation(payload.Timezone); err != nil {
+			return nil, fmt.Errorf("unknown timezone %q", payload.Timezone)
+		}
+	}
+
+	if payload.Operation == "now" {
+		return describeTime(c.Now().In(loc)), nil
+	}
+
+	t, err := parseDatetime("datetime", payload.Datetime)
+	if err != nil {
+		return nil, err
+	}
+
+	switch payload.Operation {
+	case "convert":
+		return describeTime(t.In(loc)), nil
+	case "add":
+		offset, err := datetimeOffset(payload)
+		if err != nil {
+			return nil, err
+		}
+		t = t.AddDate(payload.Years, payload.Months, payload.Days).Add(offset)
+		return describeTime(t), nil
+	case "diff":
+		other, err := parseDatetime("other", payload.Other)
+		if err != nil {
+			return nil, err
+		}
+		d := other.Sub(t)
+		return map[string]any{
+			"seconds":  int64(d.Seconds()),
+			"days":     d.Hours() / 24,
+			"duration": d.String(),
+		}, nil
+	case "format":
+		if payload.Layout == "" {
+			return nil, fmt.Errorf("layout is required for format")
+		}
+		return map[string]any{"formatted": t.Format(payload.Layout)}, nil
+	default:
+		return nil, fmt.Errorf("unknown operation %q, expected now, convert, add, diff or format", payload.Operation)
+	}
+}
+
+// datetimeOffset sums the hours, minutes and seconds of an add operation,
+// failing when they do not fit in a time.Duration (about 292 years)
+func datetimeOffset(payload datetimeArgs) (time.Duration, error) {
+	var total time.Duration
+	for _, part := range []struct {
+		name  string
+		value int
+		unit  time.Duration
+	}{
+		{"hours", payload.Hours, time.Hour},
+		{"minutes", payload.Minutes, time.Minute},
+		{"seconds", payload.Seconds, time.Second},
+	} {
+		limit := int64(math.MaxInt64 / part.unit)
+		if int64(part.value) > limit || int64(part.value) < -limit {
+			return 0, fmt.Errorf("%s is out of range, use years, months or days for spans over 292 years", part.name)
+		}
+		d := time.Duration(part.value) * part.unit
+		if (d > 0 && total > math.MaxInt64-d) || (d < 0 && total < math.MinInt64-d) {
+			return 0, fmt.Errorf("hours, minutes and seconds add up to more than 292 years, use years, months or days instead")
+		}
+		total += d
+	}
+	return total, nil
+}
+
+// parseDatetime parses an RFC 3339 argument
+func parseDatetime(field, value string) (time.Time, error) {
+	if value == "" {
+		return time.Time{}, fmt.Errorf("%s is required", field)
+	}
+	t, err := time.Parse(time.RFC3339, value)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("%s must be RFC 3339, e.g. 2025-03-01T14:00:00Z", field)
+	}
+	return t, nil
+}
+
+// describeTime is the tool result for a single point in time
+func describeTime(t time.Time) map[string]any {
+	return map[string]any{
+		"datetime": t.Format(time.RFC3339),
+		"timezone": t.Location().String(),
+		"weekday":  t.Weekday().String(),
+		"unix":     t.Unix(),
+	}
+}
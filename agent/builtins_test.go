@@ -0,0 +1,163 @@
+package agent_test
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/trogui/go-agent-sdk/agent"
+)
+
+func TestCalculator(t *testing.T) {
+	tests := []struct {
+		expression string
+		want       float64
+		wantErr    string
+	}{
+		{expression: "1 + 2 * 3", want: 7},
+		{expression: "(1 + 2) * 3", want: 9},
+		{expression: "2 ^ 3 ^ 2", want: 512},
+		{expression: "-2 ^ 2", want: -4},
+		{expression: "7 % 3", want: 1},
+		{expression: "0.1 + 0.2", want: 0.30000000000000004},
+		{expression: "1 / 0", wantErr: "division by zero"},
+		{expression: "5 % 0", wantErr: "modulo by zero"},
+		{expression: "10 ^ 400", wantErr: "result overflows"},
+		{expression: "(-8) ^ 0.5", wantErr: "is not a real number"},
+		{expression: "(1 + 2", wantErr: "missing closing parenthesis"},
+		{expression: "1 +", wantErr: "unexpected end of expression"},
+		{expression: "2 x 3", wantErr: "unexpected 'x' at position 3"},
+		{expression: "os.Exit(1)", wantErr: "unexpected 'o'"},
+		{expression: strings.Repeat("(", 200) + "1" + strings.Repeat(")", 200), wantErr: "nested too deeply"},
+		{expression: strings.Repeat("1+", 600) + "1", wantErr: "longer than 1000 characters"},
+	}
+	handler := agent.BuiltinCalculatorTool().Handler
+	for _, tt := range tests {
+		name := tt.expression
+		if len(name) > 20 {
+			name = name[:20]
+		}
+		t.Run(name, func(t *testing.T) {
+			args, _ := json.Marshal(map[string]string{"expression": tt.expression})
+			result, err := handler(args)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("error = %v, want it to contain %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("error = %v", err)
+			}
+			if got := result.(map[string]any)["result"]; got != tt.want {
+				t.Errorf("result = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestDatetime(t *testing.T) {
+	tests := []struct {
+		name    string
+		args    string
+		want    map[string]any
+		wantErr string
+	}{
+		{
+			name: "convert",
+			args: `{"operation":"convert","datetime":"2025-03-01T14:00:00Z","timezone":"Asia/Tokyo"}`,
+			want: map[string]any{"datetime": "2025-03-01T23:00:00+09:00", "timezone": "Asia/Tokyo", "weekday": "Saturday"},
+		},
+		{
+			name: "add across a month end",
+			args: `{"operation":"add","datetime":"2025-01-31T10:00:00Z","days":1,"hours":-2}`,
+			want: map[string]any{"datetime": "2025-02-01T08:00:00Z"},
+		},
+		{
+			name: "diff",
+			args: `{"operation":"diff","datetime":"2025-03-01T00:00:00Z","other":"2025-03-02T12:00:00Z"}`,
+			want: map[string]any{"seconds": int64(129600), "days": 1.5, "duration": "36h0m0s"},
+		},
+		{
+			name: "format",
+			args: `{"operation":"format","datetime":"2025-03-01T14:00:00Z","layout":"Monday, 02 Jan 2006"}`,
+			want: map[string]any{"formatted": "Saturday, 01 Mar 2025"},
+		},
+		{
+			name: "add a large offset",
+			args: `{"operation":"add","datetime":"2025-03-01T00:00:00Z","hours":2000000,"seconds":-3600}`,
+			want: map[string]any{"datetime": "2253-04-28T07:00:00Z"},
+		},
+		{
+			name:    "hours overflow",
+			args:    `{"operation":"add","datetime":"2025-03-01T00:00:00Z","hours":3000000}`,
+			wantErr: "hours is out of range",
+		},
+		{
+			name:    "negative seconds overflow",
+			args:    `{"operation":"add","datetime":"2025-03-01T00:00:00Z","seconds":-10000000000000}`,
+			wantErr: "seconds is out of range",
+		},
+		{
+			name:    "sum overflows",
+			args:    `{"operation":"add","datetime":"2025-03-01T00:00:00Z","hours":2500000,"minutes":150000000}`,
+			wantErr: "add up to more than 292 years",
+		},
+		{
+			name:    "invalid timezone",
+			args:    `{"operation":"now","timezone":"Mars/Olympus_Mons"}`,
+			wantErr: `unknown timezone "Mars/Olympus_Mons"`,
+		},
+		{
+			name:    "invalid datetime",
+			args:    `{"operation":"convert","datetime":"March 1st"}`,
+			wantErr: "datetime must be RFC 3339",
+		},
+		{
+			name:    "missing other",
+			args:    `{"operation":"diff","datetime":"2025-03-01T00:00:00Z"}`,
+			wantErr: "other is required",
+		},
+		{
+			name:    "format without layout",
+			args:    `{"operation":"format","datetime":"2025-03-01T00:00:00Z"}`,
+			wantErr: "layout is required",
+		},
+		{
+			name:    "unknown operation",
+			args:    `{"operation":"tomorrow","datetime":"2025-03-01T00:00:00Z"}`,
+			wantErr: `unknown operation "tomorrow"`,
+		},
+	}
+	handler := agent.BuiltinDatetimeTool().Handler
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result, err := handler(json.RawMessage(tt.args))
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("error = %v, want it to contain %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("error = %v", err)
+			}
+			got := result.(map[string]any)
+			for key, want := range tt.want {
+				if got[key] != want {
+					t.Errorf("%s = %v (%T), want %v (%T)", key, got[key], got[key], want, want)
+				}
+			}
+		})
+	}
+}
+
+func TestEnableBuiltinsRejectsUnknownNames(t *testing.T) {
+	a, _ := newRawAgent(t, agent.Config{})
+	if err := a.EnableBuiltins([]string{agent.BuiltinCalculator, "shell"}); err == nil {
+		t.Fatal("EnableBuiltins() accepted an unknown tool")
+	}
+	if tools := a.ListTools(); len(tools) != 0 {
+		t.Errorf("registered %d tools, want none", len(tools))
+	}
+}
@@ -0,0 +1,269 @@
+package agent
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// Circuit breaker states reported by Agent.CircuitState
+const (
+	CircuitClosed   = "closed"    // Calls go through
+	CircuitOpen     = "open"      // Calls fail fast with ErrCircuitOpen
+	CircuitHalfOpen = "half_open" // A probe call tests whether the provider recovered
+)
+
+// Circuit breaker defaults
+const (
+	defaultCircuitThreshold = 5
+	defaultCircuitWindow    = time.Minute
+	defaultCircuitCooldown  = 30 * time.Second
+)
+
+// CircuitOpened is the Data of EventCircuitOpen
+type CircuitOpened struct {
+	RetryAfterSeconds int // Until the breaker half-opens
+}
+
+// CircuitBreaker stops calling the provider after Threshold consecutive
+// failures within Window. It stays open for Cooldown, then lets a single
+// probe call through: success closes it, failure opens it again. Set the
+// same breaker as Config.CircuitBreaker of several agents to share its
+// state across an endpoint. The zero value uses the defaults.
+type CircuitBreaker struct {
+	Threshold int           // Default 5
+	Window    time.Duration // Default 1m
+	Cooldown  time.Duration // Default 30s
+	// OnStateChange is called on every transition, e.g. to export the
+	// state as a metric. It must not block.
+	OnStateChange func(from, to string)
+
+	mu       sync.Mutex
+	state    string
+	failures int       // Consecutive failures
+	first    time.Time // First failure of the streak
+	openedAt time.Time
+	probing  bool  // The half-open probe is in flight
+	clock    Clock // Config.Clock of the agent using the breaker, if any
+}
+
+// newCircuitBreaker returns Config.CircuitBreaker, or a breaker built from
+// the CircuitBreaker* fields, or nil when neither is set
+func newCircuitBreaker(config Config) *CircuitBreaker {
+	if config.CircuitBreaker != nil {
+		return config.CircuitBreaker
+	}
+	if config.CircuitBreakerThreshold <= 0 {
+		return nil
+	}
+	return &CircuitBreaker{
+		Threshold: config.CircuitBreakerThreshold,
+		Window:    config.CircuitBreakerWindow,
+		Cooldown:  config.CircuitBreakerCooldown,
+	}
+}
+
+// State returns the current state, on the Config.Clock of the agents the
+// breaker is set on
+func (b *CircuitBreaker) State() string {
+	b.mu.Lock()
+	c := b.clock
+	b.mu.Unlock()
+
+	if c == nil {
+		c = realClock{}
+	}
+	return b.current(c.Now())
+}
+
+// setClock implements clockUser
+func (b *CircuitBreaker) setClock(c Clock) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.clock = c
+}
+
+func (b *CircuitBreaker) threshold() int {
+	if b.Threshold <= 0 {
+		return defaultCircuitThreshold
+	}
+	return b.Threshold
+}
+
+func (b *CircuitBreaker) window() time.Duration {
+	if b.Window <= 0 {
+		return defaultCircuitWindow
+	}
+	return b.Window
+}
+
+func (b *CircuitBreaker) cooldown() time.Duration {
+	if b.Cooldown <= 0 {
+		return defaultCircuitCooldown
+	}
+	return b.Cooldown
+}
+
+// set changes the state and returns the transition for notify. It must be
+// called with mu held.
+func (b *CircuitBreaker) set(state string) [2]string {
+	from := b.state
+	if from == "" {
+		from = CircuitClosed
+	}
+	b.state = state
+	if from == state {
+		return [2]string{}
+	}
+	return [2]string{from, state}
+}
+
+// notify calls OnStateChange for a transition returned by set. It must be
+// called without mu held.
+func (b *CircuitBreaker) notify(change [2]string) {
+	if change[1] != "" && b.OnStateChange != nil {
+		b.OnStateChange(change[0], change[1])
+	}
+}
+
+// allow reports whether a call may go through at now, returning
+// ErrCircuitOpen otherwise
+func (b *CircuitBreaker) allow(now time.Time) error {
+	b.mu.Lock()
+	var change [2]string
+	defer func() { b.notify(change) }()
+	defer b.mu.Unlock()
+
+	if b.state == CircuitOpen {
+		if wait := b.openedAt.Add(b.cooldown()).Sub(now); wait > 0 {
+			return fmt.Errorf("%w: retry in %s", ErrCircuitOpen, wait.Round(time.Millisecond))
+		}
+		change = b.set(CircuitHalfOpen)
+	}
+	if b.state == CircuitHalfOpen {
+		if b.probing {
+			return fmt.Errorf("%w: waiting for a probe call", ErrCircuitOpen)
+		}
+		b.probing = true
+	}
+	return nil
+}
+
+// record registers the outcome of a call let through by allow and returns
+// the new state when the call changed it, "" otherwise
+func (b *CircuitBreaker) record(now time.Time, err error) string {
+	b.mu.Lock()
+	var change [2]string
+	defer func() { b.notify(change) }()
+	defer b.mu.Unlock()
+
+	b.probing = false
+	if err == nil {
+		b.failures = 0
+		change = b.set(CircuitClosed)
+		return change[1]
+	}
+
+	if b.state == CircuitHalfOpen {
+		b.openedAt = now
+		change = b.set(CircuitOpen)
+		return change[1]
+	}
+	if b.failures == 0 || now.Sub(b.first) > b.window() {
+		b.failures = 0
+		b.first = now
+	}
+	b.failures++
+	if b.failures >= b.threshold() {
+		b.openedAt = now
+		b.failures = 0
+		change = b.set(CircuitOpen)
+	}
+	return change[1]
+}
+
+// release ends a call let through by allow without counting it, e.g. a
+// cancelled one
+func (b *CircuitBreaker) release() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.probing = false
+}
+
+// current returns the state at now
+func (b *CircuitBreaker) current(now time.Time) string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	switch {
+	case b.state == "":
+		return CircuitClosed
+	case b.state == CircuitOpen && !now.Before(b.openedAt.Add(b.cooldown())):
+		return CircuitHalfOpen
+	}
+	return b.state
+}
+
+// retryAfter returns the wait until an open breaker half-opens
+func (b *CircuitBreaker) retryAfter(now time.Time) time.Duration {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.state != CircuitOpen {
+		return 0
+	}
+	return max(0, b.openedAt.Add(b.cooldown()).Sub(now))
+}
+
+// CircuitState returns the state of the circuit breaker, CircuitClosed when
+// none is configured. It is meant for health endpoints.
+func (a *Agent) CircuitState() string {
+	if a.breaker == nil {
+		return CircuitClosed
+	}
+	return a.breaker.current(a.clock.Now())
+}
+
+// callAPI makes a single API call through the circuit breaker, if any.
+// Cancelled calls don't count as failures.
+func (a *Agent) callAPI(ctx context.Context, r apiRequest) (*apiResponse, error) {
+	if a.breaker == nil {
+		return a.sendAPI(ctx, r)
+	}
+	if err := a.breaker.allow(a.clock.Now()); err != nil {
+		return nil, err
+	}
+
+	resp, err := a.sendAPI(ctx, r)
+	if err != nil && ctx.Err() != nil && (errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded)) {
+		a.breaker.release()
+		return resp, err
+	}
+	switch a.breaker.record(a.clock.Now(), err) {
+	case CircuitOpen:
+		a.log().Warn().Err(err).Msg("[Agent] Circuit breaker opened")
+	case CircuitClosed:
+		a.log().Info().Msg("[Agent] Circuit breaker closed")
+	}
+	return resp, err
+}
+
+// circuitOpen emits EventCircuitOpen after a failed call when the breaker
+// is open, so that apps can show a status banner
+func (l *loop) circuitOpen(err error) {
+	breaker := l.agent.breaker
+	if breaker == nil || (!errors.Is(err, ErrCircuitOpen) && l.agent.CircuitState() != CircuitOpen) {
+		return
+	}
+	seconds := int((breaker.retryAfter(l.agent.clock.Now()) + time.Second - 1) / time.Second)
+	l.emit(AgentEvent{
+		Type:      EventCircuitOpen,
+		Content:   fmt.Sprintf("Provider unavailable, retry in %ds", seconds),
+		Data:      CircuitOpened{RetryAfterSeconds: seconds},
+		Iteration: l.loopCount,
+	})
+}
@@ -0,0 +1,111 @@
+package agent_test
+
+import (
+	"net/http"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/trogui/go-agent-sdk/agent"
+	"github.com/trogui/go-agent-sdk/agent/agenttest"
+)
+
+func TestCircuitBreakerTransitions(t *testing.T) {
+	clock := &fakeClock{now: time.Date(2025, 3, 1, 14, 0, 0, 0, time.UTC)}
+	var transitions []string
+	breaker := &agent.CircuitBreaker{
+		Threshold: 2,
+		Cooldown:  30 * time.Second,
+		OnStateChange: func(from, to string) {
+			transitions = append(transitions, from+"->"+to)
+		},
+	}
+	failure := agenttest.Response{Status: http.StatusInternalServerError, Content: `{"error":{"message":"down"}}`}
+	e := agenttest.NewEval(t, agent.Config{Clock: clock, CircuitBreaker: breaker},
+		failure,
+		failure,
+		failure, // Failed probe
+		agenttest.Response{Content: "back"},
+	)
+
+	e.Run("one").AssertError()
+	if got := e.Agent.CircuitState(); got != agent.CircuitClosed {
+		t.Fatalf("state after one failure = %s, want %s", got, agent.CircuitClosed)
+	}
+	e.Run("two").AssertError()
+	if got := e.Agent.CircuitState(); got != agent.CircuitOpen {
+		t.Fatalf("state after two failures = %s, want %s", got, agent.CircuitOpen)
+	}
+
+	// Open: calls fail fast without reaching the provider
+	e.Run("blocked").AssertErrorIs(agent.ErrCircuitOpen)
+	if got := len(e.Provider.Requests()); got != 2 {
+		t.Fatalf("provider got %d requests while open, want 2", got)
+	}
+
+	// Half-open after the cooldown; a failed probe opens the breaker again
+	<-clock.After(30 * time.Second)
+	if got := e.Agent.CircuitState(); got != agent.CircuitHalfOpen {
+		t.Fatalf("state after cooldown = %s, want %s", got, agent.CircuitHalfOpen)
+	}
+	e.Run("probe").AssertError()
+	if got := e.Agent.CircuitState(); got != agent.CircuitOpen {
+		t.Fatalf("state after failed probe = %s, want %s", got, agent.CircuitOpen)
+	}
+	e.Run("blocked").AssertErrorIs(agent.ErrCircuitOpen)
+
+	// A successful probe closes it
+	<-clock.After(30 * time.Second)
+	e.Run("probe").AssertNoError().AssertContent("back")
+	if got := e.Agent.CircuitState(); got != agent.CircuitClosed {
+		t.Fatalf("state after successful probe = %s, want %s", got, agent.CircuitClosed)
+	}
+
+	want := []string{
+		"closed->open",
+		"open->half_open", "half_open->open",
+		"open->half_open", "half_open->closed",
+	}
+	if !reflect.DeepEqual(transitions, want) {
+		t.Errorf("transitions = %v, want %v", transitions, want)
+	}
+}
+
+func TestCircuitBreakerWindow(t *testing.T) {
+	clock := &fakeClock{now: time.Date(2025, 3, 1, 14, 0, 0, 0, time.UTC)}
+	failure := agenttest.Response{Status: http.StatusInternalServerError, Content: `{"error":{"message":"down"}}`}
+	e := agenttest.NewEval(t, agent.Config{
+		Clock:                   clock,
+		CircuitBreakerThreshold: 2,
+		CircuitBreakerWindow:    time.Minute,
+	}, failure, failure, failure)
+
+	e.Run("call 0").AssertError()
+	<-clock.After(2 * time.Minute)
+	e.Run("call 1").AssertError()
+	if got := e.Agent.CircuitState(); got != agent.CircuitClosed {
+		t.Fatalf("state after failures further apart than the window = %s, want %s", got, agent.CircuitClosed)
+	}
+	e.Run("call 2").AssertError()
+	if got := e.Agent.CircuitState(); got != agent.CircuitOpen {
+		t.Fatalf("state after two failures within the window = %s, want %s", got, agent.CircuitOpen)
+	}
+}
+
+func TestCircuitBreakerStateUsesClock(t *testing.T) {
+	clock := &fakeClock{now: time.Date(2025, 3, 1, 14, 0, 0, 0, time.UTC)}
+	breaker := &agent.CircuitBreaker{Threshold: 1, Cooldown: time.Hour}
+	e := agenttest.NewEval(t, agent.Config{Clock: clock, CircuitBreaker: breaker},
+		agenttest.Response{Status: http.StatusInternalServerError, Content: `{"error":{"message":"down"}}`},
+	)
+
+	e.Run("fail").AssertError()
+	if got := breaker.State(); got != agent.CircuitOpen {
+		t.Fatalf("State() = %s, want %s", got, agent.CircuitOpen)
+	}
+	// The cooldown elapses on the fake clock only
+	<-clock.After(time.Hour)
+	if got := breaker.State(); got != agent.CircuitHalfOpen {
+		t.Errorf("State() after the cooldown = %s, want %s", got, agent.CircuitHalfOpen)
+	}
+}
@@ -0,0 +1,56 @@
+package agent
+
+import (
+	"context"
+	"time"
+)
+
+// Clock abstracts time so that retries, backoff and other delays can be
+// driven by a fake clock in tests, see Config.Clock. After must deliver once
+// d has elapsed on the clock.
+type Clock interface {
+	Now() time.Time
+	After(d time.Duration) <-chan time.Time
+}
+
+// realClock is the clock used outside of tests
+type realClock struct{}
+
+func (realClock) Now() time.Time                         { return time.Now() }
+func (realClock) After(d time.Duration) <-chan time.Time { return time.After(d) }
+
+// clockUser is implemented by components that keep their own clock, such as
+// provider adapters, so setClock can reach them
+type clockUser interface {
+	setClock(c Clock)
+}
+
+// setClock replaces the agent's clock with Config.Clock
+func (a *Agent) setClock(c Clock) {
+	a.clock = c
+	if user, ok := a.config.Adapter.(clockUser); ok {
+		user.setClock(c)
+	}
+	if user, ok := a.config.Memory.(clockUser); ok {
+		user.setClock(c)
+	}
+	if a.keys != nil {
+		a.keys.setClock(c)
+	}
+	if a.breaker != nil {
+		a.breaker.setClock(c)
+	}
+}
+
+// sleep waits for d on clock c or until ctx is done
+func sleep(ctx context.Context, c Clock, d time.Duration) error {
+	if d <= 0 {
+		return nil
+	}
+	select {
+	case <-c.After(d):
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
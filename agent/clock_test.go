@@ -0,0 +1,69 @@
+package agent_test
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/trogui/go-agent-sdk/agent"
+	"github.com/trogui/go-agent-sdk/agent/agenttest"
+)
+
+// fakeClock is a Clock stopped at a fixed time. After advances it by d and
+// fires at once, so delays take no real time.
+type fakeClock struct {
+	mu  sync.Mutex
+	now time.Time
+}
+
+func (c *fakeClock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.now
+}
+
+func (c *fakeClock) After(d time.Duration) <-chan time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.now = c.now.Add(d)
+	ch := make(chan time.Time, 1)
+	ch <- c.now
+	return ch
+}
+
+func ExampleConfig_clock() {
+	provider := agenttest.NewProvider(
+		agenttest.Response{ToolCalls: []agenttest.ToolCall{{Name: "datetime", Arguments: `{"operation":"now","timezone":"UTC"}`}}},
+		agenttest.Response{Content: "It is Saturday afternoon."},
+	)
+	a, err := agent.New(agent.Config{
+		APIKey:       "test",
+		APIURL:       "http://example.invalid/v1/chat/completions",
+		Model:        "test-model",
+		SystemPrompt: "You are a helpful assistant.",
+		HTTPClient:   provider.Client(),
+		Clock:        &fakeClock{now: time.Date(2025, 3, 1, 14, 0, 0, 0, time.UTC)},
+	})
+	if err != nil {
+		panic(err)
+	}
+	if err := a.EnableBuiltins([]string{agent.BuiltinDatetime}); err != nil {
+		panic(err)
+	}
+
+	resp, err := a.Run("What day is it?")
+	if err != nil {
+		panic(err)
+	}
+	for _, msg := range resp.Messages {
+		if msg.Role == "tool" {
+			fmt.Println(msg.Content)
+		}
+	}
+	fmt.Println(resp.Content)
+	// Output:
+	// {"datetime":"2025-03-01T14:00:00Z","timezone":"UTC","unix":1740837600,"weekday":"Saturday"}
+	// It is Saturday afternoon.
+}
@@ -0,0 +1,140 @@
+package agent
+
+import (
+	"sync"
+	"time"
+)
+
+// BatchedEvents is the Data of an EventBatch
+type BatchedEvents struct {
+	Events []AgentEvent // In emission order
+}
+
+// unbatched are the events that end or pause a turn, delivered on their own
+// so that consumers see them without delay
+var unbatched = map[EventType]bool{
+	EventTurnComplete: true,
+	EventError:        true,
+	EventNeedInput:    true,
+	EventNeedContinue: true,
+}
+
+// coalescer buffers session events for CoalesceEvents
+type coalescer struct {
+	mu        sync.Mutex
+	window    time.Duration
+	pending   []AgentEvent
+	scheduled bool
+
+	flushMu sync.Mutex     // Serializes deliveries so events keep their order
+	wg      sync.WaitGroup // Running flushes, awaited by Close
+}
+
+// CoalesceEvents buffers the events emitted within window of the first
+// buffered one and delivers them as a single EventBatch, so bursts such as
+// the results of parallel tool calls don't overwhelm consumers. A lone event
+// is delivered as is. Events that end or pause a turn are never batched:
+// they deliver the buffered events first, then arrive on their own.
+// CoalesceEvents(0) delivers the buffered events at once and disables
+// coalescing. Events are recorded in attached EventStores individually.
+func (s *Session) CoalesceEvents(window time.Duration) {
+	c := &s.coalesce
+	c.mu.Lock()
+	c.window = window
+	c.mu.Unlock()
+
+	if window <= 0 {
+		c.flushMu.Lock()
+		defer c.flushMu.Unlock()
+
+		s.deliverBatch(c.take())
+	}
+}
+
+// emitEvent delivers an event, buffering it when it can be coalesced
+func (s *Session) emitEvent(event AgentEvent) {
+	c := &s.coalesce
+	c.mu.Lock()
+	if c.window <= 0 && len(c.pending) == 0 {
+		c.mu.Unlock()
+		s.deliverEvent(event)
+		return
+	}
+	if c.window > 0 && !unbatched[event.Type] {
+		c.pending = append(c.pending, event)
+		if !c.scheduled {
+			c.scheduled = true
+			c.wg.Add(1)
+			go s.flushEvents(c.window)
+		}
+		c.mu.Unlock()
+		return
+	}
+	c.mu.Unlock()
+
+	// Buffered events go first
+	c.flushMu.Lock()
+	defer c.flushMu.Unlock()
+
+	s.deliverBatch(c.take())
+	s.deliverEvent(event)
+}
+
+// take returns the buffered events and empties the buffer
+func (c *coalescer) take() []AgentEvent {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	events := c.pending
+	c.pending = nil
+	c.scheduled = false
+	return events
+}
+
+// flushEvents delivers the events buffered during window. Those still
+// buffered when the session is cancelled are dropped, as deliverEvent
+// would drop them.
+func (s *Session) flushEvents(window time.Duration) {
+	c := &s.coalesce
+	defer c.wg.Done()
+
+	select {
+	case <-s.agent.clock.After(window):
+	case <-s.ctx.Done():
+		c.take()
+		return
+	}
+
+	c.flushMu.Lock()
+	defer c.flushMu.Unlock()
+
+	s.deliverBatch(c.take())
+}
+
+// deliverBatch delivers buffered events, as an EventBatch when there are
+// several. It must be called with flushMu held.
+func (s *Session) deliverBatch(events []AgentEvent) {
+	switch len(events) {
+	case 0:
+		return
+	case 1:
+		s.deliverEvent(events[0])
+		return
+	}
+
+	last := events[len(events)-1]
+	batch := AgentEvent{
+		Type:      EventBatch,
+		Data:      BatchedEvents{Events: events},
+		Iteration: last.Iteration,
+		Seq:       last.Seq,
+		TurnID:    last.TurnID,
+	}
+	for _, event := range events {
+		if event.TurnID != last.TurnID {
+			batch.TurnID = ""
+			break
+		}
+	}
+	s.deliverEvent(batch)
+}
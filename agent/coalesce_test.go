@@ -0,0 +1,223 @@
+package agent_test
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/trogui/go-agent-sdk/agent"
+	"github.com/trogui/go-agent-sdk/agent/agenttest"
+)
+
+// nextEvent returns the next event of the session's Events channel
+func nextEvent(t *testing.T, session *agent.Session) agent.AgentEvent {
+	t.Helper()
+
+	select {
+	case event, ok := <-session.Events():
+		if !ok {
+			t.Fatal("events channel closed")
+		}
+		return event
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for an event")
+	}
+	return agent.AgentEvent{}
+}
+
+// blockingTool returns a tool that signals started and waits for release
+func blockingTool(started, release chan struct{}) *agent.Tool {
+	return &agent.Tool{
+		Name:        "block",
+		Description: "Block until released",
+		Handler: func(json.RawMessage) (any, error) {
+			close(started)
+			<-release
+			return "released", nil
+		},
+	}
+}
+
+// flatten returns the events delivered, with batches expanded
+func flatten(events []agent.AgentEvent) []agent.AgentEvent {
+	var flat []agent.AgentEvent
+	for _, event := range events {
+		if batch, ok := event.Data.(agent.BatchedEvents); ok {
+			flat = append(flat, batch.Events...)
+			continue
+		}
+		flat = append(flat, event)
+	}
+	return flat
+}
+
+func assertSeqOrder(t *testing.T, events []agent.AgentEvent) {
+	t.Helper()
+
+	for i := 1; i < len(events); i++ {
+		if events[i].Seq <= events[i-1].Seq {
+			t.Errorf("event %d (%s) has Seq %d after %d", i, events[i].Type, events[i].Seq, events[i-1].Seq)
+		}
+	}
+}
+
+func TestCoalesceEvents(t *testing.T) {
+	e := agenttest.NewEval(t, agent.Config{},
+		agenttest.Response{ToolCalls: []agenttest.ToolCall{
+			{Name: "echo", Arguments: `{"text":"a"}`},
+			{Name: "echo", Arguments: `{"text":"b"}`},
+		}},
+		agenttest.Response{Content: "done"},
+	)
+	e.Agent.RegisterTool(echoTool("echo"))
+	session := e.Agent.NewSession(t.Context())
+	defer session.Close()
+	// Longer than the test: only the end of the turn flushes the buffer
+	session.CoalesceEvents(time.Hour)
+
+	if err := session.Send("echo twice"); err != nil {
+		t.Fatal(err)
+	}
+	batch := nextEvent(t, session)
+	done := nextEvent(t, session)
+
+	if batch.Type != agent.EventBatch {
+		t.Fatalf("first event is %s, want a batch", batch.Type)
+	}
+	events := batch.Data.(agent.BatchedEvents).Events
+	results := 0
+	for _, event := range events {
+		if event.Type == agent.EventToolResult {
+			results++
+		}
+		if event.TurnID != batch.TurnID {
+			t.Errorf("batched %s has TurnID %q, batch has %q", event.Type, event.TurnID, batch.TurnID)
+		}
+	}
+	if results != 2 {
+		t.Errorf("batch has %d tool results, want 2", results)
+	}
+	if batch.TurnID == "" || batch.Seq != events[len(events)-1].Seq {
+		t.Errorf("batch TurnID = %q, Seq = %d, want those of its last event", batch.TurnID, batch.Seq)
+	}
+	if done.Type != agent.EventTurnComplete {
+		t.Errorf("second event is %s, want the turn completion on its own", done.Type)
+	}
+	assertSeqOrder(t, flatten([]agent.AgentEvent{batch, done}))
+}
+
+func TestCoalesceEventsWindow(t *testing.T) {
+	e := agenttest.NewEval(t, agent.Config{},
+		agenttest.Response{ToolCalls: []agenttest.ToolCall{{Name: "block"}}},
+		agenttest.Response{Content: "done"},
+	)
+	started, release := make(chan struct{}), make(chan struct{})
+	e.Agent.RegisterTool(blockingTool(started, release))
+	session := e.Agent.NewSession(t.Context())
+	defer session.Close()
+	session.CoalesceEvents(20 * time.Millisecond)
+
+	if err := session.Send("block"); err != nil {
+		t.Fatal(err)
+	}
+	<-started
+	// The window ends while the tool still runs
+	batch := nextEvent(t, session)
+	if batch.Type != agent.EventBatch {
+		t.Fatalf("first event is %s, want a batch", batch.Type)
+	}
+	types := []agent.EventType{}
+	for _, event := range batch.Data.(agent.BatchedEvents).Events {
+		types = append(types, event.Type)
+	}
+	if len(types) != 2 || types[0] != agent.EventIterationStart || types[1] != agent.EventToolCall {
+		t.Errorf("batch holds %v, want the iteration start and the tool call", types)
+	}
+	close(release)
+	waitTurn(t, session)
+}
+
+func TestCoalesceEventsLoneEvent(t *testing.T) {
+	e := agenttest.NewEval(t, agent.Config{}, agenttest.Response{Content: "done"})
+	session := e.Agent.NewSession(t.Context())
+	defer session.Close()
+	session.CoalesceEvents(time.Hour)
+
+	if err := session.Send("hi"); err != nil {
+		t.Fatal(err)
+	}
+	if event := nextEvent(t, session); event.Type != agent.EventIterationStart {
+		t.Errorf("first event is %s, want the lone iteration start as is", event.Type)
+	}
+	if event := nextEvent(t, session); event.Type != agent.EventTurnComplete {
+		t.Errorf("second event is %s, want the turn completion", event.Type)
+	}
+}
+
+func TestCoalesceEventsDisabledWithPending(t *testing.T) {
+	e := agenttest.NewEval(t, agent.Config{},
+		agenttest.Response{ToolCalls: []agenttest.ToolCall{{Name: "block"}}},
+		agenttest.Response{Content: "done"},
+	)
+	started, release := make(chan struct{}), make(chan struct{})
+	e.Agent.RegisterTool(blockingTool(started, release))
+	session := e.Agent.NewSession(t.Context())
+	defer session.Close()
+	session.CoalesceEvents(time.Hour)
+
+	if err := session.Send("block"); err != nil {
+		t.Fatal(err)
+	}
+	<-started
+	session.CoalesceEvents(0)
+	close(release)
+
+	var events []agent.AgentEvent
+	for {
+		event := nextEvent(t, session)
+		events = append(events, event)
+		if event.Type == agent.EventTurnComplete {
+			break
+		}
+	}
+	if events[0].Type != agent.EventBatch {
+		t.Errorf("first event is %s, want the pending batch", events[0].Type)
+	}
+	for _, event := range events[1:] {
+		if event.Type == agent.EventBatch {
+			t.Error("an event was batched after coalescing was disabled")
+		}
+	}
+	assertSeqOrder(t, flatten(events))
+}
+
+func TestCoalesceEventsClose(t *testing.T) {
+	e := agenttest.NewEval(t, agent.Config{},
+		agenttest.Response{ToolCalls: []agenttest.ToolCall{{Name: "block"}}},
+		agenttest.Response{Content: "done"},
+	)
+	started, release := make(chan struct{}), make(chan struct{})
+	e.Agent.RegisterTool(blockingTool(started, release))
+	session := e.Agent.NewSession(t.Context())
+	session.CoalesceEvents(time.Hour)
+
+	if err := session.Send("block"); err != nil {
+		t.Fatal(err)
+	}
+	<-started
+
+	closed := make(chan struct{})
+	go func() {
+		session.Close()
+		close(closed)
+	}()
+	close(release)
+	select {
+	case <-closed:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Close waited for the coalescing window")
+	}
+	// The events channel is closed once the pending events are dropped
+	for range session.Events() {
+	}
+}
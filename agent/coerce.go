@@ -0,0 +1,86 @@
+package agent
+
+import (
+	"encoding/json"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// coerceArgs converts string-encoded numbers and booleans in args, e.g.
+// {"id": "5"} or {"done": "true"}, to the types the tool declares, for
+// models that quote every value. Arguments that are not an object, values
+// that do not parse and undeclared parameters are left as they are.
+func coerceArgs(tool *Tool, args json.RawMessage) json.RawMessage {
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(args, &fields); err != nil {
+		return args
+	}
+
+	changed := false
+	for name, raw := range fields {
+		param, ok := tool.Parameters[name]
+		if !ok {
+			continue
+		}
+		if coerced, ok := coerceValue(param.Type, param.Items, raw); ok {
+			fields[name] = coerced
+			changed = true
+		}
+	}
+	if !changed {
+		return args
+	}
+
+	coerced, err := json.Marshal(fields)
+	if err != nil {
+		return args
+	}
+	return coerced
+}
+
+// coerceValue converts raw to typ and reports whether it changed. Array
+// elements are converted to the item type.
+func coerceValue(typ string, items *Items, raw json.RawMessage) (json.RawMessage, bool) {
+	if typ == "array" && items != nil {
+		var elems []json.RawMessage
+		if err := json.Unmarshal(raw, &elems); err != nil {
+			return raw, false
+		}
+		changed := false
+		for i, elem := range elems {
+			if coerced, ok := coerceValue(items.Type, nil, elem); ok {
+				elems[i] = coerced
+				changed = true
+			}
+		}
+		if !changed {
+			return raw, false
+		}
+		coerced, err := json.Marshal(elems)
+		return coerced, err == nil
+	}
+
+	var s string
+	if err := json.Unmarshal(raw, &s); err != nil {
+		return raw, false
+	}
+	s = strings.TrimSpace(s)
+
+	switch typ {
+	case "integer":
+		if _, err := strconv.ParseInt(s, 10, 64); err == nil {
+			return json.RawMessage(s), true
+		}
+	case "number":
+		// NaN and infinities parse but have no JSON encoding
+		if f, err := strconv.ParseFloat(s, 64); err == nil && !math.IsNaN(f) && !math.IsInf(f, 0) {
+			return json.RawMessage(strconv.FormatFloat(f, 'g', -1, 64)), true
+		}
+	case "boolean":
+		if b, err := strconv.ParseBool(s); err == nil {
+			return json.RawMessage(strconv.FormatBool(b)), true
+		}
+	}
+	return raw, false
+}
@@ -0,0 +1,51 @@
+package agent
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func TestCoerceArgs(t *testing.T) {
+	tool := &Tool{
+		Name: "update",
+		Parameters: map[string]Parameter{
+			"id":    {Type: "integer"},
+			"price": {Type: "number"},
+			"done":  {Type: "boolean"},
+			"ids":   {Type: "array", Items: &Items{Type: "integer"}},
+			"name":  {Type: "string"},
+		},
+	}
+
+	tests := []struct {
+		name string
+		args string
+		want string
+	}{
+		{"integer", `{"id":"5"}`, `{"id":5}`},
+		{"integer with spaces", `{"id":" 42 "}`, `{"id":42}`},
+		{"number", `{"price":"19.90"}`, `{"price":19.9}`},
+		{"number exponent", `{"price":"1e3"}`, `{"price":1000}`},
+		{"boolean", `{"done":"true"}`, `{"done":true}`},
+		{"boolean false", `{"done":"FALSE"}`, `{"done":false}`},
+		{"array items", `{"ids":["1","2",3]}`, `{"ids":[1,2,3]}`},
+		{"several fields", `{"id":"5","done":"false","name":"7"}`, `{"done":false,"id":5,"name":"7"}`},
+		{"already typed", `{"id":5,"done":true}`, `{"id":5,"done":true}`},
+		{"string parameter", `{"name":"42"}`, `{"name":"42"}`},
+		{"undeclared parameter", `{"other":"5"}`, `{"other":"5"}`},
+		{"integer not parsing", `{"id":"five"}`, `{"id":"five"}`},
+		{"fractional integer", `{"id":"5.5"}`, `{"id":"5.5"}`},
+		{"non-finite number", `{"price":"NaN","id":"5"}`, `{"id":5,"price":"NaN"}`},
+		{"infinite number", `{"price":"Inf"}`, `{"price":"Inf"}`},
+		{"not an object", `["5"]`, `["5"]`},
+		{"invalid JSON", `{"id":`, `{"id":`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := coerceArgs(tool, json.RawMessage(tt.args))
+			if string(got) != tt.want {
+				t.Errorf("coerceArgs(%s) = %s, want %s", tt.args, got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,197 @@
+package agent
+
+import (
+	"fmt"
+	"strings"
+	"unicode/utf8"
+)
+
+// ToolNoteFunc formats the note that replaces a compacted tool call. result
+// is the content that was sent back to the model for the call.
+type ToolNoteFunc func(call ToolCall, result string) string
+
+// maxToolNoteResult caps how much of a result DefaultToolNote keeps
+const maxToolNoteResult = 200
+
+// DefaultToolNote renders a tool call as `called name(args) → result`
+func DefaultToolNote(call ToolCall, result string) string {
+	if len(result) > maxToolNoteResult {
+		// Back off to a rune boundary so the note stays valid UTF-8
+		cut := maxToolNoteResult
+		for cut > 0 && !utf8.RuneStart(result[cut]) {
+			cut--
+		}
+		result = result[:cut] + "..."
+	}
+	return fmt.Sprintf("called %s(%s) → %s", call.Function.Name, call.Function.Arguments, result)
+}
+
+// CompactToolCalls replaces every completed tool exchange, that is an
+// assistant message with tool_calls followed by a tool response for each of
+// them, with a single assistant message holding any text sent with the
+// calls, then one note per call. Exchanges with missing responses are left
+// untouched so tool_call pairing is never broken. A nil note uses
+// DefaultToolNote.
+func CompactToolCalls(messages []ConversationMessage, note ToolNoteFunc) []ConversationMessage {
+	if note == nil {
+		note = DefaultToolNote
+	}
+
+	compacted := make([]ConversationMessage, 0, len(messages))
+	for i := 0; i < len(messages); i++ {
+		msg := messages[i]
+		if msg.Role != "assistant" || len(msg.ToolCalls) == 0 {
+			compacted = append(compacted, msg)
+			continue
+		}
+
+		// Collect the tool responses that directly follow the assistant message
+		results := make(map[string]string, len(msg.ToolCalls))
+		end := i + 1
+		for end < len(messages) && messages[end].Role == "tool" {
+			results[messages[end].ToolCallID] = messages[end].Content
+			end++
+		}
+
+		notes := make([]string, 0, len(msg.ToolCalls))
+		for _, call := range msg.ToolCalls {
+			result, ok := results[call.ID]
+			if !ok {
+				notes = nil
+				break
+			}
+			notes = append(notes, note(call, result))
+		}
+		if notes == nil {
+			// Incomplete exchange, keep it verbatim
+			compacted = append(compacted, messages[i:end]...)
+		} else {
+			if msg.Content != "" {
+				notes = append([]string{msg.Content}, notes...)
+			}
+			compacted = append(compacted, ConversationMessage{
+				Role:      "assistant",
+				Content:   strings.Join(notes, "\n"),
+				CreatedAt: messages[i].CreatedAt,
+			})
+		}
+		i = end - 1
+	}
+
+	return compacted
+}
+
+// Strategies reported in HistoryCompaction
+const (
+	CompactionToolCalls = "tool_calls" // CompactToolCalls
+	CompactionTrim      = "trim"       // Oldest messages left out by MaxContextMessages
+)
+
+// HistoryCompaction describes messages removed or condensed from a history.
+// It is the Data of EventHistoryCompacted.
+type HistoryCompaction struct {
+	Strategy        string
+	MessagesRemoved int
+	TokensRemoved   int // Estimated
+}
+
+// CompactHistory compacts the completed tool exchanges of the session history
+// with CompactToolCalls and returns how many messages were removed
+func (s *Session) CompactHistory(note ToolNoteFunc) int {
+	s.mu.Lock()
+	before := s.messages
+	s.messages = CompactToolCalls(s.messages, note)
+	after := s.messages
+	s.mu.Unlock()
+
+	s.agent.historyCompacted(s.sendEvent, CompactionToolCalls, before, after, 0)
+	return len(before) - len(after)
+}
+
+// historyCompacted reports a compaction from before to after through emit
+// and Config.OnHistoryCompacted. Nothing is reported when no message was
+// removed or condensed.
+func (a *Agent) historyCompacted(emit func(AgentEvent), strategy string, before, after []ConversationMessage, iteration int) {
+	if len(before) == len(after) {
+		return
+	}
+
+	compaction := HistoryCompaction{
+		Strategy:        strategy,
+		MessagesRemoved: len(before) - len(after),
+		TokensRemoved:   a.estimateTokens(before) - a.estimateTokens(after),
+	}
+	a.log().Info().
+		Str("strategy", strategy).
+		Int("messages_removed", compaction.MessagesRemoved).
+		Int("tokens_removed", compaction.TokensRemoved).
+		Msg("[Agent] History compacted")
+
+	if a.config.OnHistoryCompacted != nil {
+		a.config.OnHistoryCompacted(compaction)
+	}
+	emit(AgentEvent{
+		Type:      EventHistoryCompacted,
+		Content:   fmt.Sprintf("%d earlier messages condensed", compaction.MessagesRemoved),
+		Data:      compaction,
+		Iteration: iteration,
+	})
+}
+
+// trimMessages returns at most max of the messages that are sent, dropping
+// the oldest ones but always keeping the leading system prompt and the most
+// recent user message. A tool response whose call was dropped is dropped
+// too, so the result never breaks tool_call pairing. The latest tool
+// exchange after that user message is never split, even when it exceeds max.
+func trimMessages(messages []ConversationMessage, max int) []ConversationMessage {
+	sent := make([]ConversationMessage, 0, len(messages))
+	for _, msg := range messages {
+		if !msg.Transient {
+			sent = append(sent, msg)
+		}
+	}
+	if max <= 0 || len(sent) <= max {
+		return sent
+	}
+
+	var head []ConversationMessage
+	rest := sent
+	if rest[0].Role == "system" {
+		head = append(head, rest[0])
+		rest = rest[1:]
+	}
+
+	lastUser, lastCall := -1, -1
+	for i := len(rest) - 1; i >= 0 && lastUser < 0; i-- {
+		switch {
+		case rest[i].Role == "user":
+			lastUser = i
+		case rest[i].Role == "assistant" && len(rest[i].ToolCalls) > 0 && lastCall < 0:
+			lastCall = i
+		}
+	}
+
+	room := max - len(head)
+	start := len(rest) - room
+	if lastUser >= 0 && lastUser < start {
+		// The last user message takes one of the slots
+		head = append(head, rest[lastUser])
+		start++
+	}
+	if start < 0 {
+		start = 0
+	}
+	if start > len(rest) {
+		start = len(rest)
+	}
+	if lastCall >= 0 && start > lastCall {
+		start = lastCall
+	}
+	for start < len(rest) && rest[start].Role == "tool" {
+		start++
+	}
+
+	trimmed := make([]ConversationMessage, 0, len(head)+len(rest)-start)
+	trimmed = append(trimmed, head...)
+	return append(trimmed, rest[start:]...)
+}
@@ -0,0 +1,172 @@
+package agent_test
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+	"unicode/utf8"
+
+	"github.com/trogui/go-agent-sdk/agent"
+	"github.com/trogui/go-agent-sdk/agent/agenttest"
+)
+
+func call(id, name, args string) agent.ToolCall {
+	return agent.ToolCall{ID: id, Type: "function", Function: agent.FunctionCall{Name: name, Arguments: args}}
+}
+
+func TestCompactToolCalls(t *testing.T) {
+	system := agent.ConversationMessage{Role: "system", Content: "prompt"}
+	user := agent.ConversationMessage{Role: "user", Content: "weather?"}
+	calls := agent.ConversationMessage{Role: "assistant", ToolCalls: []agent.ToolCall{
+		call("a", "get_weather", `{"city":"tokyo"}`),
+		call("b", "get_weather", `{"city":"london"}`),
+	}}
+	resultA := agent.ConversationMessage{Role: "tool", ToolCallID: "a", Content: `"22.3°C Sunny"`}
+	resultB := agent.ConversationMessage{Role: "tool", ToolCallID: "b", Content: `"12°C Rain"`}
+	answer := agent.ConversationMessage{Role: "assistant", Content: "Tokyo is warmer."}
+
+	tests := []struct {
+		name     string
+		messages []agent.ConversationMessage
+		want     []agent.ConversationMessage
+	}{
+		{
+			name:     "complete exchange becomes one note",
+			messages: []agent.ConversationMessage{system, user, calls, resultA, resultB, answer},
+			want: []agent.ConversationMessage{system, user, {
+				Role:    "assistant",
+				Content: "called get_weather({\"city\":\"tokyo\"}) → \"22.3°C Sunny\"\ncalled get_weather({\"city\":\"london\"}) → \"12°C Rain\"",
+			}, answer},
+		},
+		{
+			name:     "exchange missing a response is kept verbatim",
+			messages: []agent.ConversationMessage{system, user, calls, resultA},
+			want:     []agent.ConversationMessage{system, user, calls, resultA},
+		},
+		{
+			name:     "no tool calls",
+			messages: []agent.ConversationMessage{system, user, answer},
+			want:     []agent.ConversationMessage{system, user, answer},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := agent.CompactToolCalls(tt.messages, nil)
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("CompactToolCalls() =\n%+v\nwant\n%+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCompactToolCallsCustomNote(t *testing.T) {
+	messages := []agent.ConversationMessage{
+		{Role: "assistant", ToolCalls: []agent.ToolCall{call("a", "lookup", `{}`)}},
+		{Role: "tool", ToolCallID: "a", Content: "found"},
+	}
+	note := func(c agent.ToolCall, result string) string {
+		return fmt.Sprintf("%s: %s", c.Function.Name, result)
+	}
+
+	got := agent.CompactToolCalls(messages, note)
+	if len(got) != 1 || got[0].Content != "lookup: found" {
+		t.Errorf("CompactToolCalls() = %+v, want a single note \"lookup: found\"", got)
+	}
+}
+
+func TestDefaultToolNoteTruncatesLongResults(t *testing.T) {
+	long := make([]byte, 500)
+	for i := range long {
+		long[i] = 'x'
+	}
+	got := agent.DefaultToolNote(call("a", "dump", "{}"), string(long))
+	if want := "called dump({}) → " + string(long[:200]) + "..."; got != want {
+		t.Errorf("DefaultToolNote() = %q, want %q", got, want)
+	}
+}
+
+func TestCompactToolCallsKeepsAssistantText(t *testing.T) {
+	messages := []agent.ConversationMessage{
+		{Role: "assistant", Content: "Let me check.", ToolCalls: []agent.ToolCall{call("a", "lookup", `{}`)}},
+		{Role: "tool", ToolCallID: "a", Content: "found"},
+	}
+	note := func(c agent.ToolCall, result string) string {
+		return fmt.Sprintf("%s: %s", c.Function.Name, result)
+	}
+
+	got := agent.CompactToolCalls(messages, note)
+	if len(got) != 1 || got[0].Content != "Let me check.\nlookup: found" {
+		t.Errorf("CompactToolCalls() = %+v, want the assistant text followed by the note", got)
+	}
+}
+
+func TestDefaultToolNoteCutsAtRuneBoundary(t *testing.T) {
+	result := strings.Repeat("a", 199) + strings.Repeat("é", 10)
+	got := agent.DefaultToolNote(call("a", "dump", "{}"), result)
+	if !utf8.ValidString(got) {
+		t.Fatalf("DefaultToolNote() = %q, not valid UTF-8", got)
+	}
+	if want := "called dump({}) → " + strings.Repeat("a", 199) + "..."; got != want {
+		t.Errorf("DefaultToolNote() = %q, want %q", got, want)
+	}
+}
+
+func TestCompactHistoryReportsCompaction(t *testing.T) {
+	var reported []agent.HistoryCompaction
+	e := agenttest.NewEval(t, agent.Config{
+		OnHistoryCompacted: func(c agent.HistoryCompaction) { reported = append(reported, c) },
+	},
+		agenttest.Response{ToolCalls: []agenttest.ToolCall{{Name: "echo", Arguments: `{"text":"hi"}`}}},
+		agenttest.Response{Content: "done"},
+	)
+	e.Agent.RegisterTool(echoTool("echo"))
+
+	session := e.Agent.NewSession(t.Context())
+	defer session.Close()
+	if err := session.Send("echo hi"); err != nil {
+		t.Fatal(err)
+	}
+	waitTurn(t, session)
+
+	if removed := session.CompactHistory(nil); removed != 1 {
+		t.Fatalf("CompactHistory() = %d, want 1", removed)
+	}
+	if removed := session.CompactHistory(nil); removed != 0 {
+		t.Fatalf("second CompactHistory() = %d, want 0", removed)
+	}
+
+	timeout := time.After(10 * time.Second)
+	var event agent.AgentEvent
+	for event.Type != agent.EventHistoryCompacted {
+		select {
+		case event = <-session.Events():
+		case <-timeout:
+			t.Fatal("timed out waiting for EventHistoryCompacted")
+		}
+	}
+	compaction, ok := event.Data.(agent.HistoryCompaction)
+	if !ok || compaction.Strategy != agent.CompactionToolCalls || compaction.MessagesRemoved != 1 || compaction.TokensRemoved <= 0 {
+		t.Errorf("event data = %+v, want a tool_calls compaction removing 1 message", event.Data)
+	}
+	if len(reported) != 1 || reported[0] != compaction {
+		t.Errorf("OnHistoryCompacted got %+v, want only %+v", reported, compaction)
+	}
+}
+
+func TestTrimReportsCompaction(t *testing.T) {
+	var reported []agent.HistoryCompaction
+	e := agenttest.NewEval(t, agent.Config{
+		MaxContextMessages: 3,
+		OnHistoryCompacted: func(c agent.HistoryCompaction) { reported = append(reported, c) },
+	},
+		agenttest.Response{Content: "one"},
+		agenttest.Response{Content: "two"},
+	)
+	e.Converse("first", "second").AssertNoError()
+
+	if len(reported) != 1 || reported[0].Strategy != agent.CompactionTrim || reported[0].MessagesRemoved != 1 {
+		t.Errorf("OnHistoryCompacted got %+v, want one trim of 1 message", reported)
+	}
+}
@@ -0,0 +1,91 @@
+package agent
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+)
+
+// post sends a request body to the API, gzipped when compress is set
+func (a *Agent) post(ctx context.Context, r apiRequest, body []byte, compress bool) (*http.Response, error) {
+	if compress {
+		var buf bytes.Buffer
+		zw := gzip.NewWriter(&buf)
+		if _, err := zw.Write(body); err != nil {
+			return nil, fmt.Errorf("error compressing request: %w", err)
+		}
+		if err := zw.Close(); err != nil {
+			return nil, fmt.Errorf("error compressing request: %w", err)
+		}
+		a.log().Debug().
+			Int("bytes", len(body)).
+			Int("compressed_bytes", buf.Len()).
+			Msg("[Agent] Request compressed")
+		body = buf.Bytes()
+	}
+
+	req, err := http.NewRequestWithContext(ctx, "POST", a.config.APIURL, bytes.NewReader(body))
+	if err != nil {
+		return nil, fmt.Errorf("error creating request: %w", err)
+	}
+
+	apiKey, keyIndex, err := a.apiKey(ctx)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Authorization", "Bearer "+apiKey)
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", userAgent())
+	if compress {
+		req.Header.Set("Content-Encoding", "gzip")
+	}
+	if a.config.CompressRequests {
+		// Set explicitly so that clients with compression disabled in their
+		// transport still get compressed responses; readBody decodes them
+		req.Header.Set("Accept-Encoding", "gzip")
+	}
+	if r.options.traceID != "" {
+		req.Header.Set(a.config.TraceHeader, r.options.traceID)
+	}
+
+	if a.config.Adapter != nil {
+		if h, ok := a.config.Adapter.(holdBacker); ok {
+			if wait, reason := h.holdBack(); wait > 0 {
+				a.log().Warn().Dur("wait", wait).Msg(reason)
+			}
+		}
+		if err := a.config.Adapter.BeforeRequest(ctx, req); err != nil {
+			a.reportKey(keyIndex, 0)
+			return nil, fmt.Errorf("error preparing request: %w", err)
+		}
+	}
+
+	resp, err := a.client.Do(req)
+	if err != nil {
+		a.reportKey(keyIndex, 0)
+		return nil, fmt.Errorf("error making request: %w", err)
+	}
+	a.reportKey(keyIndex, resp.StatusCode)
+	return resp, nil
+}
+
+// readBody reads a response body, decompressing it when it is still gzipped.
+// The default transport decompresses on its own and removes the
+// Content-Encoding header, unless Accept-Encoding was set by the caller or
+// compression is disabled.
+func readBody(resp *http.Response) ([]byte, error) {
+	var body io.Reader = resp.Body
+	if strings.EqualFold(resp.Header.Get("Content-Encoding"), "gzip") {
+		zr, err := gzip.NewReader(resp.Body)
+		if err != nil {
+			return nil, err
+		}
+		defer zr.Close()
+		body = zr
+	}
+	return io.ReadAll(body)
+}
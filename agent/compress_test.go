@@ -0,0 +1,69 @@
+package agent_test
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+
+	"github.com/trogui/go-agent-sdk/agent"
+	"github.com/trogui/go-agent-sdk/agent/agenttest"
+)
+
+func TestCompressRequests(t *testing.T) {
+	for _, compress := range []bool{false, true} {
+		e := agenttest.NewEval(t, agent.Config{CompressRequests: compress}, agenttest.Response{Content: "done"})
+		e.Run("hi").AssertNoError().AssertContent("done")
+
+		req := e.Provider.Requests()[0]
+		if got := req.Header.Get("Content-Encoding") == "gzip"; got != compress {
+			t.Errorf("CompressRequests %v: gzip Content-Encoding is %v", compress, got)
+		}
+		if n := len(req.Messages); n != 2 {
+			t.Errorf("CompressRequests %v: decoded %d messages, want 2", compress, n)
+		}
+	}
+}
+
+// BenchmarkCompressRequests measures runs with a large system prompt, with
+// and without request compression, reporting the bytes sent per request
+func BenchmarkCompressRequests(b *testing.B) {
+	var sent atomic.Int64
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		n, _ := io.Copy(io.Discard, r.Body)
+		sent.Add(n)
+		w.Header().Set("Content-Type", "application/json")
+		io.WriteString(w, `{"choices":[{"message":{"role":"assistant","content":"done"},"finish_reason":"stop"}]}`)
+	}))
+	defer server.Close()
+
+	prompt := strings.Repeat("You are a support agent for an online shop. Answer politely and cite the order number. ", 500)
+	for _, compress := range []bool{false, true} {
+		name := "plain"
+		if compress {
+			name = "gzip"
+		}
+		b.Run(name, func(b *testing.B) {
+			a, err := agent.New(agent.Config{
+				APIKey:           "test",
+				APIURL:           server.URL + "/v1/chat/completions",
+				Model:            "test-model",
+				SystemPrompt:     prompt,
+				CompressRequests: compress,
+			})
+			if err != nil {
+				b.Fatal(err)
+			}
+			sent.Store(0)
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				if _, err := a.Run("Where is my order?"); err != nil {
+					b.Fatal(err)
+				}
+			}
+			b.ReportMetric(float64(sent.Load())/float64(b.N), "sent-B/op")
+		})
+	}
+}
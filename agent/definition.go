@@ -0,0 +1,246 @@
+package agent
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sync"
+
+	"gopkg.in/yaml.v3"
+)
+
+// validToolName matches the tool names accepted by OpenAI-compatible APIs
+var validToolName = regexp.MustCompile(`^[a-zA-Z0-9_-]{1,64}$`)
+
+// definition is the document read by LoadDefinition
+type definition struct {
+	Model            string              `yaml:"model"`
+	APIURL           string              `yaml:"api_url"`
+	Provider         string              `yaml:"provider"`
+	SystemPrompt     string              `yaml:"system_prompt"`
+	SystemPromptFile string              `yaml:"system_prompt_file"`
+	Temperature      *float64            `yaml:"temperature"`
+	MaxTokens        int                 `yaml:"max_tokens"`
+	MaxLoops         int                 `yaml:"max_loops"`
+	MaxTotalTokens   int                 `yaml:"max_total_tokens"`
+	TitleModel       string              `yaml:"title_model"`
+	Builtins         []string            `yaml:"builtins"`
+	Tools            []toolRefDefinition `yaml:"tools"`
+}
+
+// toolRefDefinition is an entry of the tools list of a definition
+type toolRefDefinition struct {
+	Name    string `yaml:"name"`
+	Handler string `yaml:"handler"`
+}
+
+// ToolRef names a tool enabled by an agent definition. Bind it to Go code
+// with ToolRegistry.Bind.
+type ToolRef struct {
+	Name    string // Name sent to the model
+	Handler string // Registry name of the implementation; Name when empty
+	Builtin bool   // A built-in tool such as BuiltinCalculator
+
+	path string // Position in the definition, for errors
+}
+
+// LoadDefinition reads an agent definition in YAML or JSON, so that prompts,
+// models, sampling and enabled tools can change without a redeploy:
+//
+//	model: gpt-4o-mini
+//	api_url: https://api.openai.com/v1/chat/completions
+//	provider: groq               # Optional, see Config.Provider
+//	system_prompt: You are ...   # Or system_prompt_file: prompts/support.md
+//	temperature: 0.2
+//	max_tokens: 1024
+//	max_loops: 10
+//	max_total_tokens: 50000
+//	title_model: gpt-4o-mini
+//	builtins: [calculator, datetime]
+//	tools:
+//	  - name: search_orders
+//	    handler: orders.search   # Registry name, defaults to name
+//
+// A relative system_prompt_file is read from the working directory; use
+// LoadDefinitionFile to read it next to the definition instead. The API key
+// is never part of a definition; set Config.APIKey before calling New. Errors
+// cite the path of the offending field, e.g. "tools[1].name".
+func LoadDefinition(r io.Reader) (Config, []ToolRef, error) {
+	return loadDefinition(r, "")
+}
+
+// LoadDefinitionFile reads the agent definition at path, see LoadDefinition.
+// A relative system_prompt_file is read from the directory of path.
+func LoadDefinitionFile(path string) (Config, []ToolRef, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return Config{}, nil, fmt.Errorf("error opening agent definition: %w", err)
+	}
+	defer file.Close()
+
+	return loadDefinition(file, filepath.Dir(path))
+}
+
+// loadDefinition reads a definition, resolving a relative
+// system_prompt_file against dir
+func loadDefinition(r io.Reader, dir string) (Config, []ToolRef, error) {
+	var def definition
+	decoder := yaml.NewDecoder(r)
+	decoder.KnownFields(true)
+	// YAML is a superset of JSON, so one decoder reads both
+	if err := decoder.Decode(&def); err != nil {
+		if err == io.EOF {
+			return Config{}, nil, errors.New("agent definition is empty")
+		}
+		return Config{}, nil, fmt.Errorf("error parsing agent definition: %w", err)
+	}
+
+	config := Config{
+		APIURL:         def.APIURL,
+		Model:          def.Model,
+		Provider:       def.Provider,
+		SystemPrompt:   def.SystemPrompt,
+		MaxTokens:      def.MaxTokens,
+		MaxLoops:       def.MaxLoops,
+		MaxTotalTokens: def.MaxTotalTokens,
+		TitleModel:     def.TitleModel,
+	}
+
+	if def.Model == "" {
+		return Config{}, nil, definitionError("model", "is required")
+	}
+	switch {
+	case def.SystemPrompt != "" && def.SystemPromptFile != "":
+		return Config{}, nil, definitionError("system_prompt_file", "cannot be combined with system_prompt")
+	case def.SystemPromptFile != "":
+		path := def.SystemPromptFile
+		if !filepath.IsAbs(path) {
+			path = filepath.Join(dir, path)
+		}
+		prompt, err := os.ReadFile(path)
+		if err != nil {
+			return Config{}, nil, definitionError("system_prompt_file", err.Error())
+		}
+		config.SystemPrompt = string(prompt)
+	case def.SystemPrompt == "":
+		return Config{}, nil, definitionError("system_prompt", "is required, or system_prompt_file")
+	}
+	if def.Temperature != nil {
+		if *def.Temperature < 0 || *def.Temperature > 2 {
+			return Config{}, nil, definitionError("temperature", "must be between 0 and 2")
+		}
+		config.Temperature = *def.Temperature
+	}
+	for _, limit := range []struct {
+		path  string
+		value int
+	}{
+		{"max_tokens", def.MaxTokens},
+		{"max_loops", def.MaxLoops},
+		{"max_total_tokens", def.MaxTotalTokens},
+	} {
+		if limit.value < 0 {
+			return Config{}, nil, definitionError(limit.path, "cannot be negative")
+		}
+	}
+
+	var refs []ToolRef
+	seen := make(map[string]string)
+	for i, name := range def.Builtins {
+		path := fmt.Sprintf("builtins[%d]", i)
+		if builtinTools[name] == nil {
+			return Config{}, nil, definitionError(path, fmt.Sprintf("unknown builtin tool %q", name))
+		}
+		if other, ok := seen[name]; ok {
+			return Config{}, nil, definitionError(path, fmt.Sprintf("duplicates %s", other))
+		}
+		seen[name] = path
+		refs = append(refs, ToolRef{Name: name, Builtin: true, path: path})
+	}
+	for i, tool := range def.Tools {
+		path := fmt.Sprintf("tools[%d]", i)
+		if !validToolName.MatchString(tool.Name) {
+			return Config{}, nil, definitionError(path+".name", fmt.Sprintf("invalid tool name %q", tool.Name))
+		}
+		if other, ok := seen[tool.Name]; ok {
+			return Config{}, nil, definitionError(path+".name", fmt.Sprintf("duplicates %s", other))
+		}
+		seen[tool.Name] = path + ".name"
+		refs = append(refs, ToolRef{Name: tool.Name, Handler: tool.Handler, path: path + ".handler"})
+	}
+
+	return config, refs, nil
+}
+
+// where returns the path of the ref in its definition, or its position
+// among the refs of Bind for refs built in code
+func (ref ToolRef) where(i int) string {
+	if ref.path != "" {
+		return ref.path
+	}
+	return fmt.Sprintf("refs[%d]", i)
+}
+
+// definitionError reports an invalid field of an agent definition
+func definitionError(path, message string) error {
+	return fmt.Errorf("agent definition: %s: %s", path, message)
+}
+
+// ToolRegistry maps handler names to the Go implementations that agent
+// definitions can enable. It is safe for concurrent use.
+type ToolRegistry struct {
+	mu    sync.RWMutex
+	tools map[string]*Tool
+}
+
+// NewToolRegistry creates an empty registry
+func NewToolRegistry() *ToolRegistry {
+	return &ToolRegistry{tools: make(map[string]*Tool)}
+}
+
+// Register makes tool available to definitions under name, e.g.
+// "orders.search". Registering a name again replaces the tool.
+func (r *ToolRegistry) Register(name string, tool *Tool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.tools[name] = tool
+}
+
+// Bind registers the tools named by refs on ag, renamed as the definition
+// says. Nothing is registered when a ref has no implementation; the error
+// cites its path in the definition.
+func (r *ToolRegistry) Bind(ag *Agent, refs []ToolRef) error {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	tools := make([]*Tool, 0, len(refs))
+	for i, ref := range refs {
+		if ref.Builtin {
+			newTool := builtinTools[ref.Name]
+			if newTool == nil {
+				return definitionError(ref.where(i), fmt.Sprintf("unknown builtin tool %q", ref.Name))
+			}
+			tools = append(tools, newTool(ag.clock))
+			continue
+		}
+
+		handler := ref.Handler
+		if handler == "" {
+			handler = ref.Name
+		}
+		tool, ok := r.tools[handler]
+		if !ok {
+			return definitionError(ref.where(i), fmt.Sprintf("no implementation registered as %q", handler))
+		}
+		bound := *tool
+		bound.Name = ref.Name
+		tools = append(tools, &bound)
+	}
+
+	ag.RegisterTools(tools...)
+	return nil
+}
@@ -0,0 +1,195 @@
+package agent_test
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/trogui/go-agent-sdk/agent"
+	"github.com/trogui/go-agent-sdk/agent/agenttest"
+)
+
+func TestLoadDefinition(t *testing.T) {
+	config, refs, err := agent.LoadDefinition(strings.NewReader(`
+model: gpt-4o-mini
+api_url: https://api.example.com/v1/chat/completions
+system_prompt: You are a support assistant.
+temperature: 0.2
+max_loops: 5
+builtins: [calculator]
+tools:
+  - name: search_orders
+    handler: orders.search
+  - name: refund
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if config.Model != "gpt-4o-mini" || config.SystemPrompt != "You are a support assistant." ||
+		config.Temperature != 0.2 || config.MaxLoops != 5 {
+		t.Errorf("config = %+v", config)
+	}
+	want := []agent.ToolRef{
+		{Name: "calculator", Builtin: true},
+		{Name: "search_orders", Handler: "orders.search"},
+		{Name: "refund"},
+	}
+	if len(refs) != len(want) {
+		t.Fatalf("refs = %+v, want %+v", refs, want)
+	}
+	for i := range want {
+		if refs[i].Name != want[i].Name || refs[i].Handler != want[i].Handler || refs[i].Builtin != want[i].Builtin {
+			t.Errorf("refs[%d] = %+v, want %+v", i, refs[i], want[i])
+		}
+	}
+}
+
+func TestLoadDefinitionJSON(t *testing.T) {
+	config, refs, err := agent.LoadDefinition(strings.NewReader(`{
+		"model": "gpt-4o-mini",
+		"system_prompt": "You are a support assistant.",
+		"max_tokens": 512,
+		"tools": [{"name": "search_orders"}]
+	}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if config.Model != "gpt-4o-mini" || config.MaxTokens != 512 {
+		t.Errorf("config = %+v", config)
+	}
+	if len(refs) != 1 || refs[0].Name != "search_orders" {
+		t.Errorf("refs = %+v, want search_orders", refs)
+	}
+}
+
+func TestLoadDefinitionErrors(t *testing.T) {
+	tests := []struct {
+		name string
+		def  string
+		want string
+	}{
+		{"empty", ``, "agent definition is empty"},
+		{"unknown field", "model: m\nsystem_prompt: p\nsystem_promt: typo\n", "field system_promt not found"},
+		{"no model", "system_prompt: p\n", "model: is required"},
+		{"no prompt", "model: m\n", "system_prompt: is required"},
+		{"prompt and prompt file", "model: m\nsystem_prompt: p\nsystem_prompt_file: p.md\n", "system_prompt_file: cannot be combined with system_prompt"},
+		{"missing prompt file", "model: m\nsystem_prompt_file: missing.md\n", "system_prompt_file: "},
+		{"temperature", "model: m\nsystem_prompt: p\ntemperature: 2.5\n", "temperature: must be between 0 and 2"},
+		{"negative limit", "model: m\nsystem_prompt: p\nmax_loops: -1\n", "max_loops: cannot be negative"},
+		{"unknown builtin", "model: m\nsystem_prompt: p\nbuiltins: [clock]\n", `builtins[0]: unknown builtin tool "clock"`},
+		{"invalid tool name", "model: m\nsystem_prompt: p\ntools:\n  - name: ok\n  - name: not ok\n", `tools[1].name: invalid tool name "not ok"`},
+		{"duplicate tool", "model: m\nsystem_prompt: p\nbuiltins: [calculator]\ntools:\n  - name: calculator\n", "tools[0].name: duplicates builtins[0]"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, _, err := agent.LoadDefinition(strings.NewReader(tt.def))
+			if err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Errorf("LoadDefinition() error = %v, want it to contain %q", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestLoadDefinitionFile(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.Mkdir(filepath.Join(dir, "prompts"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "prompts", "support.md"), []byte("You are a support assistant."), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(dir, "agent.yaml")
+	if err := os.WriteFile(path, []byte("model: m\nsystem_prompt_file: prompts/support.md\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	// Resolved next to the definition, not in the working directory
+	config, _, err := agent.LoadDefinitionFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if config.SystemPrompt != "You are a support assistant." {
+		t.Errorf("SystemPrompt = %q, want the contents of prompts/support.md", config.SystemPrompt)
+	}
+
+	if _, _, err := agent.LoadDefinitionFile(filepath.Join(dir, "missing.yaml")); err == nil {
+		t.Error("LoadDefinitionFile succeeded on a missing file")
+	}
+}
+
+func TestToolRegistryBind(t *testing.T) {
+	e := agenttest.NewEval(t, agent.Config{},
+		agenttest.Response{ToolCalls: []agenttest.ToolCall{{Name: "search_orders", Arguments: `{}`}}},
+		agenttest.Response{Content: "done"},
+	)
+	registry := agent.NewToolRegistry()
+	search := &agent.Tool{
+		Name:        "orders.search",
+		Description: "Search orders",
+		Handler: func(json.RawMessage) (any, error) {
+			return "3 orders", nil
+		},
+	}
+	registry.Register("orders.search", search)
+
+	_, refs, err := agent.LoadDefinition(strings.NewReader(`
+model: m
+system_prompt: p
+builtins: [calculator]
+tools:
+  - name: search_orders
+    handler: orders.search
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := registry.Bind(e.Agent, refs); err != nil {
+		t.Fatal(err)
+	}
+
+	resp := e.Run("find my orders").AssertNoError().AssertToolCalled("search_orders", nil).Response
+	if got := resp.ToolCalls[0].Result; got != `"3 orders"` {
+		t.Errorf("result = %s, want the registered handler's", got)
+	}
+	if got := len(e.Provider.Requests()[0].Tools); got != 2 {
+		t.Errorf("request has %d tools, want calculator and search_orders", got)
+	}
+	if search.Name != "orders.search" {
+		t.Errorf("Bind renamed the registered tool to %s", search.Name)
+	}
+}
+
+func TestToolRegistryBindMissing(t *testing.T) {
+	e := agenttest.NewEval(t, agent.Config{}, agenttest.Response{Content: "done"})
+	registry := agent.NewToolRegistry()
+	registry.Register("orders.search", &agent.Tool{Name: "orders.search"})
+
+	_, refs, err := agent.LoadDefinition(strings.NewReader(`
+model: m
+system_prompt: p
+tools:
+  - name: search_orders
+    handler: orders.search
+  - name: refund
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = registry.Bind(e.Agent, refs)
+	if err == nil || !strings.Contains(err.Error(), `tools[1].handler: no implementation registered as "refund"`) {
+		t.Fatalf("Bind() error = %v, want the path of the unbound tool", err)
+	}
+
+	// Built in code, refs are cited by position
+	err = registry.Bind(e.Agent, []agent.ToolRef{{Name: "a", Handler: "missing"}})
+	if err == nil || !strings.Contains(err.Error(), "refs[0]") {
+		t.Errorf("Bind() error = %v, want refs[0]", err)
+	}
+
+	e.Run("hi").AssertNoError()
+	if got := len(e.Provider.Requests()[0].Tools); got != 0 {
+		t.Errorf("request has %d tools, want none after a failed Bind", got)
+	}
+}
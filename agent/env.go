@@ -0,0 +1,145 @@
+package agent
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// Environment variables read by ConfigFromEnv
+const (
+	EnvAPIKey       = "AGENT_API_KEY"
+	EnvAPIURL       = "AGENT_API_URL"
+	EnvModel        = "AGENT_MODEL"
+	EnvSystemPrompt = "AGENT_SYSTEM_PROMPT"
+	EnvMaxLoops     = "AGENT_MAX_LOOPS"
+	EnvMaxTokens    = "AGENT_MAX_TOKENS"
+	EnvTemperature  = "AGENT_TEMPERATURE"
+	EnvProvider     = "AGENT_PROVIDER"
+)
+
+// envProviders are the provider-specific key variables used when
+// AGENT_API_KEY is unset, in order, with the endpoint they imply
+var envProviders = []struct {
+	keyVar string
+	apiURL string
+}{
+	{"OPENROUTER_API_KEY", "https://openrouter.ai/api/v1/chat/completions"},
+	{"OPENAI_API_KEY", "https://api.openai.com/v1/chat/completions"},
+	{"GROQ_API_KEY", "https://api.groq.com/openai/v1/chat/completions"},
+	{"XAI_API_KEY", XAIAPIURL},
+}
+
+// ConfigFromEnv builds a Config from environment variables:
+//
+//	AGENT_API_KEY        API key; falls back to OPENROUTER_API_KEY,
+//	                     OPENAI_API_KEY, GROQ_API_KEY, then XAI_API_KEY
+//	AGENT_API_URL        Endpoint; defaults to the endpoint of the provider
+//	                     whose key was used, or OPENAI_BASE_URL
+//	                     + "/chat/completions" when that is set
+//	AGENT_MODEL          Model
+//	AGENT_SYSTEM_PROMPT  System prompt
+//	AGENT_MAX_LOOPS      MaxLoops
+//	AGENT_MAX_TOKENS     MaxTokens
+//	AGENT_TEMPERATURE    Temperature
+//	AGENT_PROVIDER       Provider
+//
+// A missing required variable is reported by name, wrapping the error New
+// returns for the field, e.g. ErrMissingModel for AGENT_MODEL. Defaults are
+// applied and the result is validated like New does, returning the same
+// errors for invalid values. Fields can still be adjusted before calling
+// New.
+func ConfigFromEnv() (Config, error) {
+	config := Config{
+		APIKey:       os.Getenv(EnvAPIKey),
+		APIURL:       os.Getenv(EnvAPIURL),
+		Model:        os.Getenv(EnvModel),
+		SystemPrompt: os.Getenv(EnvSystemPrompt),
+		Provider:     os.Getenv(EnvProvider),
+	}
+
+	defaultURL := ""
+	if config.APIKey == "" {
+		for _, provider := range envProviders {
+			if key := os.Getenv(provider.keyVar); key != "" {
+				config.APIKey = key
+				defaultURL = provider.apiURL
+				break
+			}
+		}
+	}
+	if config.APIURL == "" {
+		if base := os.Getenv("OPENAI_BASE_URL"); base != "" {
+			config.APIURL = strings.TrimSuffix(base, "/") + "/chat/completions"
+		} else {
+			config.APIURL = defaultURL
+		}
+	}
+
+	var err error
+	if config.MaxLoops, err = envInt(EnvMaxLoops); err != nil {
+		return Config{}, err
+	}
+	if config.MaxTokens, err = envInt(EnvMaxTokens); err != nil {
+		return Config{}, err
+	}
+	if value := os.Getenv(EnvTemperature); value != "" {
+		if config.Temperature, err = strconv.ParseFloat(value, 64); err != nil {
+			return Config{}, fmt.Errorf("invalid %s: %q", EnvTemperature, value)
+		}
+	}
+
+	if err := checkEnv(config); err != nil {
+		return Config{}, err
+	}
+	if err := config.applyDefaults(); err != nil {
+		return Config{}, err
+	}
+	return config, nil
+}
+
+// NewFromEnv creates an agent configured by the environment variables read
+// by ConfigFromEnv
+func NewFromEnv() (*Agent, error) {
+	config, err := ConfigFromEnv()
+	if err != nil {
+		return nil, err
+	}
+	return New(config)
+}
+
+// checkEnv names the variable to set for the first missing required field,
+// wrapping the error of New
+func checkEnv(config Config) error {
+	if config.APIKey == "" {
+		keyVars := make([]string, len(envProviders))
+		for i, provider := range envProviders {
+			keyVars[i] = provider.keyVar
+		}
+		return fmt.Errorf("%s is not set (nor %s): %w", EnvAPIKey, strings.Join(keyVars, ", "), ErrMissingAPIKey)
+	}
+	if config.APIURL == "" {
+		return fmt.Errorf("%s is not set: %w", EnvAPIURL, ErrMissingAPIURL)
+	}
+	if config.Model == "" {
+		return fmt.Errorf("%s is not set: %w", EnvModel, ErrMissingModel)
+	}
+	if config.SystemPrompt == "" {
+		return fmt.Errorf("%s is not set: %w", EnvSystemPrompt, ErrMissingSystemPrompt)
+	}
+	return nil
+}
+
+// envInt reads an integer variable, 0 when unset
+func envInt(name string) (int, error) {
+	value := os.Getenv(name)
+	if value == "" {
+		return 0, nil
+	}
+	n, err := strconv.Atoi(value)
+	if err != nil {
+		return 0, fmt.Errorf("invalid %s: %q", name, value)
+	}
+	return n, nil
+}
@@ -0,0 +1,61 @@
+package agent_test
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/trogui/go-agent-sdk/agent"
+)
+
+func TestConfigFromEnvErrors(t *testing.T) {
+	complete := map[string]string{
+		agent.EnvAPIKey:       "key",
+		agent.EnvAPIURL:       "http://localhost/v1/chat/completions",
+		agent.EnvModel:        "test-model",
+		agent.EnvSystemPrompt: "You are a test assistant.",
+	}
+	tests := []struct {
+		unset    string
+		override map[string]string
+		variable string
+		wantErr  error
+	}{
+		{unset: agent.EnvAPIKey, variable: agent.EnvAPIKey, wantErr: agent.ErrMissingAPIKey},
+		{unset: agent.EnvAPIURL, variable: agent.EnvAPIURL, wantErr: agent.ErrMissingAPIURL},
+		{unset: agent.EnvModel, variable: agent.EnvModel, wantErr: agent.ErrMissingModel},
+		{unset: agent.EnvSystemPrompt, variable: agent.EnvSystemPrompt, wantErr: agent.ErrMissingSystemPrompt},
+		{override: map[string]string{agent.EnvMaxLoops: "many"}, variable: agent.EnvMaxLoops},
+	}
+	for _, tt := range tests {
+		t.Run(tt.variable, func(t *testing.T) {
+			for _, name := range []string{"OPENROUTER_API_KEY", "OPENAI_API_KEY", "GROQ_API_KEY", "XAI_API_KEY", "OPENAI_BASE_URL"} {
+				t.Setenv(name, "")
+			}
+			for name, value := range complete {
+				if name == tt.unset {
+					value = ""
+				}
+				t.Setenv(name, value)
+			}
+			for name, value := range tt.override {
+				t.Setenv(name, value)
+			}
+
+			_, err := agent.ConfigFromEnv()
+			if err == nil || !strings.Contains(err.Error(), tt.variable) {
+				t.Fatalf("error = %v, want it to name %s", err, tt.variable)
+			}
+			if tt.wantErr != nil && !errors.Is(err, tt.wantErr) {
+				t.Errorf("error = %v, want it to match %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestNewMissingFieldErrors(t *testing.T) {
+	_, err := agent.New(agent.Config{APIKey: "key", APIURL: "http://localhost", SystemPrompt: "prompt"})
+	if !errors.Is(err, agent.ErrMissingModel) {
+		t.Errorf("error = %v, want ErrMissingModel", err)
+	}
+}
@@ -0,0 +1,160 @@
+package agent
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// Errors returned by New for a missing required field. ConfigFromEnv wraps
+// them with the name of the environment variable to set.
+var (
+	ErrMissingAPIURL       = errors.New("API URL is required")
+	ErrMissingAPIKey       = errors.New("API key is required")
+	ErrMissingModel        = errors.New("model is required")
+	ErrMissingSystemPrompt = errors.New("system prompt is required")
+)
+
+// ErrResponseParse is wrapped by errors caused by an unparseable API response
+var ErrResponseParse = errors.New("error parsing response")
+
+// ErrContextLengthExceeded is returned when a request is estimated not to
+// fit in the model's context window
+var ErrContextLengthExceeded = errors.New("context length exceeded")
+
+// ErrUnboundTool is returned by runs started while a registered tool has no
+// handler, e.g. a schema loaded with LoadToolSchemas and never bound
+var ErrUnboundTool = errors.New("tool has no bound handler")
+
+// ErrMaxLoopsExceeded is returned when a run or turn reaches
+// Config.MaxLoops iterations without a final answer
+var ErrMaxLoopsExceeded = errors.New("maximum loop iterations exceeded")
+
+// ErrEmptyAPIResponse is returned when an API response has no choices,
+// e.g. an error body or {"choices":[]}
+var ErrEmptyAPIResponse = errors.New("API response has no choices")
+
+// ErrEmptyToolCalls is returned when the model twice answers with
+// finish_reason "tool_calls" but neither tool calls nor text
+var ErrEmptyToolCalls = errors.New("finish_reason tool_calls without tool calls")
+
+// ErrEmptyCompletion matches the *EmptyCompletionError returned when
+// Config.RejectEmptyCompletion is set and the final answer is empty
+var ErrEmptyCompletion = errors.New("empty completion")
+
+// EmptyCompletionError carries the raw API response whose final answer was
+// empty, for diagnosing content filters and provider glitches
+type EmptyCompletionError struct {
+	Raw []byte // Body of the last API response
+}
+
+func (e *EmptyCompletionError) Error() string {
+	return "model returned an empty completion"
+}
+
+// Is makes errors.Is(err, ErrEmptyCompletion) match
+func (e *EmptyCompletionError) Is(target error) bool {
+	return target == ErrEmptyCompletion
+}
+
+// TurnError is the Data of the EventError of a failed session turn. The
+// history and iteration count are rolled back to the last completed
+// exchange, so a Recoverable session can take the next Send as if the
+// failed message had never been sent; tokens spent still count. Only a
+// closed or cancelled session is not recoverable.
+type TurnError struct {
+	Err error
+	// Recoverable means the session is still usable, not that sending the
+	// same message again will succeed: a message that exceeded
+	// MaxTotalTokens will likely exceed it again. Check Err to decide.
+	Recoverable bool
+}
+
+// TransformError is returned for a tool call whose arguments were rejected
+// by Tool.TransformArgs. The handler is not called and the error is sent to
+// the model as the tool result, so it can correct the arguments.
+type TransformError struct {
+	Tool string          // Name of the tool called by the model
+	Args json.RawMessage // Arguments as sent by the model
+	Err  error
+}
+
+func (e *TransformError) Error() string {
+	return fmt.Sprintf("invalid arguments for tool %s: %v", e.Tool, e.Err)
+}
+
+func (e *TransformError) Unwrap() error {
+	return e.Err
+}
+
+// ToolErrors collects the tool calls of an iteration that failed, so that
+// several failures can be handled at once. errors.Is and errors.As look at
+// each failure.
+type ToolErrors struct {
+	Iteration int
+	Failures  []ToolCallRecord // Failed calls, in order; Err is set on each
+}
+
+func (e *ToolErrors) Error() string {
+	messages := make([]string, len(e.Failures))
+	for i, call := range e.Failures {
+		messages[i] = call.Name + ": " + call.Error
+	}
+	return fmt.Sprintf("%d tool calls failed in iteration %d: %s", len(e.Failures), e.Iteration, strings.Join(messages, "; "))
+}
+
+func (e *ToolErrors) Unwrap() []error {
+	errs := make([]error, len(e.Failures))
+	for i, call := range e.Failures {
+		errs[i] = call.Err
+	}
+	return errs
+}
+
+// newToolErrors returns the failures among calls, or nil when none failed
+func newToolErrors(iteration int, calls []ToolCallRecord) *ToolErrors {
+	var failures []ToolCallRecord
+	for _, call := range calls {
+		if call.Err != nil {
+			failures = append(failures, call)
+		}
+	}
+	if len(failures) == 0 {
+		return nil
+	}
+	return &ToolErrors{Iteration: iteration, Failures: failures}
+}
+
+// Errors returned by SessionPool.Acquire
+var (
+	ErrPoolTimeout = errors.New("timed out waiting for a pooled session")
+	ErrPoolClosed  = errors.New("session pool is closed")
+)
+
+// ErrCircuitOpen is returned without calling the provider while the circuit
+// breaker of Config.CircuitBreakerThreshold is open
+var ErrCircuitOpen = errors.New("circuit breaker is open")
+
+// ErrStreamStalled is returned when a streamed response sends nothing for
+// Config.StreamStallTimeout
+var ErrStreamStalled = errors.New("stream stalled")
+
+// ErrTokenBudgetExceeded is returned when a run or turn has spent
+// Config.MaxTotalTokens
+var ErrTokenBudgetExceeded = errors.New("token budget exceeded")
+
+// Phases reported to Config.OnError
+const (
+	PhaseAPICall       = "api_call"
+	PhaseToolExecution = "tool_execution"
+	PhaseResponseParse = "response_parse"
+)
+
+// apiErrorPhase returns the OnError phase of an error returned by callAPI
+func apiErrorPhase(err error) string {
+	if errors.Is(err, ErrResponseParse) {
+		return PhaseResponseParse
+	}
+	return PhaseAPICall
+}
@@ -0,0 +1,187 @@
+package agent
+
+import (
+	"sync"
+)
+
+// OverflowPolicy decides what happens when a subscriber's buffer is full
+type OverflowPolicy int
+
+const (
+	// OverflowDrop drops the event for the slow subscriber so the turn never waits
+	OverflowDrop OverflowPolicy = iota
+	// OverflowBlock makes the turn wait until the subscriber has room
+	OverflowBlock
+)
+
+// defaultSubscriberBuffer is the channel capacity of a new subscription
+const defaultSubscriberBuffer = 64
+
+// SubscribeOption configures a subscription created by Session.Subscribe
+type SubscribeOption func(*subscriber)
+
+// WithSubscriberBuffer sets the channel capacity of the subscription
+func WithSubscriberBuffer(size int) SubscribeOption {
+	return func(sub *subscriber) {
+		sub.buffer = size
+	}
+}
+
+// WithOverflowPolicy sets what happens when the subscription's buffer is full
+func WithOverflowPolicy(policy OverflowPolicy) SubscribeOption {
+	return func(sub *subscriber) {
+		sub.policy = policy
+	}
+}
+
+// subscriber is a single consumer of session events
+type subscriber struct {
+	ch     chan AgentEvent
+	buffer int
+	policy OverflowPolicy
+
+	done chan struct{} // Closed by Unsubscribe to abort blocking sends
+	once sync.Once
+}
+
+// subscribers broadcasts events to every registered subscriber
+type subscribers struct {
+	mu     sync.Mutex
+	list   []*subscriber
+	closed bool
+
+	replaySize int          // Capacity of replay, set by WithEventReplay
+	replay     []AgentEvent // Last delivered events, oldest first
+
+	byChan sync.Map // Subscriber channel to *subscriber, for Unsubscribe
+}
+
+// Subscribe returns a new channel receiving every event emitted by the
+// session from now on. Each subscription has its own buffer, so several
+// consumers (UI, logger, metrics) can read events independently. By default a
+// subscriber that falls behind misses events instead of stalling the turn;
+// use WithOverflowPolicy(OverflowBlock) to change that. The channel is closed
+// when the session is closed.
+func (s *Session) Subscribe(opts ...SubscribeOption) <-chan AgentEvent {
+	return s.subs.subscribe(-1, opts)
+}
+
+// EventsFrom is like Subscribe but first replays the buffered events with a
+// Seq of at least seq, e.g. the Seq after the last event a reconnecting
+// client received. Only the last events kept by WithEventReplay can be
+// replayed. Replayed and live events form one ordered stream without gaps
+// or duplicates.
+func (s *Session) EventsFrom(seq int64, opts ...SubscribeOption) <-chan AgentEvent {
+	return s.subs.subscribe(seq, opts)
+}
+
+// subscribe registers a subscriber, replaying the buffered events from seq
+// unless seq is negative
+func (subs *subscribers) subscribe(seq int64, opts []SubscribeOption) <-chan AgentEvent {
+	sub := &subscriber{
+		buffer: defaultSubscriberBuffer,
+		policy: OverflowDrop,
+		done:   make(chan struct{}),
+	}
+	for _, opt := range opts {
+		opt(sub)
+	}
+
+	subs.mu.Lock()
+	defer subs.mu.Unlock()
+
+	var replay []AgentEvent
+	if seq >= 0 {
+		for _, event := range subs.replay {
+			if event.Seq >= seq {
+				replay = append(replay, event)
+			}
+		}
+	}
+
+	// Room for the replayed events on top of the live buffer
+	sub.ch = make(chan AgentEvent, sub.buffer+len(replay))
+	for _, event := range replay {
+		sub.ch <- event
+	}
+
+	if subs.closed {
+		close(sub.ch)
+		return sub.ch
+	}
+	subs.list = append(subs.list, sub)
+	subs.byChan.Store((<-chan AgentEvent)(sub.ch), sub)
+	return sub.ch
+}
+
+// Unsubscribe stops delivery to a channel returned by Subscribe, EventsFrom
+// or Events and closes it. A turn blocked on the subscription resumes.
+// Unknown or already unsubscribed channels are ignored.
+func (s *Session) Unsubscribe(ch <-chan AgentEvent) {
+	value, ok := s.subs.byChan.LoadAndDelete(ch)
+	if !ok {
+		return
+	}
+	sub := value.(*subscriber)
+
+	// Abort a blocked send first, it holds the lock
+	sub.once.Do(func() { close(sub.done) })
+
+	s.subs.mu.Lock()
+	defer s.subs.mu.Unlock()
+
+	for i, other := range s.subs.list {
+		if other == sub {
+			s.subs.list = append(s.subs.list[:i], s.subs.list[i+1:]...)
+			close(sub.ch)
+			return
+		}
+	}
+}
+
+// broadcast delivers an event to all subscribers. done aborts blocking sends.
+func (subs *subscribers) broadcast(event AgentEvent, done <-chan struct{}) {
+	subs.mu.Lock()
+	defer subs.mu.Unlock()
+
+	if subs.closed {
+		return
+	}
+	if subs.replaySize > 0 {
+		subs.replay = append(subs.replay, event)
+		if len(subs.replay) > subs.replaySize {
+			subs.replay = subs.replay[len(subs.replay)-subs.replaySize:]
+		}
+	}
+	for _, sub := range subs.list {
+		if sub.policy == OverflowBlock {
+			select {
+			case sub.ch <- event:
+			case <-sub.done:
+			case <-done:
+			}
+			continue
+		}
+
+		select {
+		case sub.ch <- event:
+		default:
+		}
+	}
+}
+
+// close closes every subscriber channel
+func (subs *subscribers) close() {
+	subs.mu.Lock()
+	defer subs.mu.Unlock()
+
+	if subs.closed {
+		return
+	}
+	subs.closed = true
+	for _, sub := range subs.list {
+		subs.byChan.Delete((<-chan AgentEvent)(sub.ch))
+		close(sub.ch)
+	}
+	subs.list = nil
+}
@@ -0,0 +1,94 @@
+package agent
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"sync"
+)
+
+// EventStore records session events so they can be replayed later, e.g. to
+// build deterministic tests from production session recordings
+type EventStore struct {
+	mu     sync.RWMutex
+	events []AgentEvent
+}
+
+// NewEventStore creates an empty event store
+func NewEventStore() *EventStore {
+	return &EventStore{}
+}
+
+// ReadEventStore loads a store previously written with ExportJSON. Event
+// Data comes back as generic JSON values (maps, slices, strings, float64).
+func ReadEventStore(r io.Reader) (*EventStore, error) {
+	var events []AgentEvent
+	if err := json.NewDecoder(r).Decode(&events); err != nil {
+		return nil, fmt.Errorf("error decoding events: %w", err)
+	}
+	return &EventStore{events: events}, nil
+}
+
+// Store appends an event to the store
+func (e *EventStore) Store(event AgentEvent) error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	e.events = append(e.events, event)
+	return nil
+}
+
+// Replay calls handler for every stored event in order. It stops early with
+// ctx's error when ctx is cancelled.
+func (e *EventStore) Replay(ctx context.Context, handler func(AgentEvent)) error {
+	e.mu.RLock()
+	events := make([]AgentEvent, len(e.events))
+	copy(events, e.events)
+	e.mu.RUnlock()
+
+	for _, event := range events {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		handler(event)
+	}
+	return nil
+}
+
+// ExportJSON writes the stored events as a JSON array
+func (e *EventStore) ExportJSON(w io.Writer) error {
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+
+	events := e.events
+	if events == nil {
+		events = []AgentEvent{}
+	}
+	if err := json.NewEncoder(w).Encode(events); err != nil {
+		return fmt.Errorf("error encoding events: %w", err)
+	}
+	return nil
+}
+
+// AttachEventStore makes the session record every event it emits from now
+// on into store
+func (s *Session) AttachEventStore(store *EventStore) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.stores = append(s.stores, store)
+}
+
+// recordEvent stores an event in all attached stores
+func (s *Session) recordEvent(event AgentEvent) {
+	s.mu.RLock()
+	stores := s.stores
+	s.mu.RUnlock()
+
+	for _, store := range stores {
+		if err := store.Store(event); err != nil {
+			s.agent.log().Error().Err(err).Msg("[Session] Error storing event")
+		}
+	}
+}
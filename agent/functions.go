@@ -0,0 +1,76 @@
+package agent
+
+import (
+	"fmt"
+)
+
+// Tool formats understood by Config.ToolFormat
+const (
+	ToolFormatTools     = "tools"     // "tools"/"tool_calls" (default)
+	ToolFormatFunctions = "functions" // Deprecated "functions"/"function_call"
+)
+
+// legacyMessage is a message in the deprecated functions format
+type legacyMessage struct {
+	Role         string        `json:"role"`
+	Content      string        `json:"content"`
+	Name         string        `json:"name,omitempty"`
+	FunctionCall *FunctionCall `json:"function_call,omitempty"`
+}
+
+// toLegacyMessages converts messages to the functions format. Assistant
+// messages with several tool calls are split, one function_call each, and
+// tool responses become "function" messages named after their call.
+func toLegacyMessages(messages []ConversationMessage) []legacyMessage {
+	names := make(map[string]string)
+	legacy := make([]legacyMessage, 0, len(messages))
+
+	for _, msg := range messages {
+		switch {
+		case msg.Role == "assistant" && len(msg.ToolCalls) > 0:
+			for _, call := range msg.ToolCalls {
+				names[call.ID] = call.Function.Name
+				fc := call.Function
+				legacy = append(legacy, legacyMessage{
+					Role:         "assistant",
+					Content:      msg.Content,
+					FunctionCall: &fc,
+				})
+			}
+		case msg.Role == "tool":
+			legacy = append(legacy, legacyMessage{
+				Role:    "function",
+				Name:    names[msg.ToolCallID],
+				Content: msg.Content,
+			})
+		default:
+			legacy = append(legacy, legacyMessage{
+				Role:    msg.Role,
+				Content: msg.Content,
+			})
+		}
+	}
+
+	return legacy
+}
+
+// normalizeFunctionCalls rewrites function_call responses into tool calls so
+// the loop handles both formats the same way
+func (a *Agent) normalizeFunctionCalls(resp *apiResponse) {
+	for i := range resp.Choices {
+		choice := &resp.Choices[i]
+		if choice.Message.FunctionCall == nil || len(choice.Message.ToolCalls) > 0 {
+			continue
+		}
+
+		choice.Message.ToolCalls = []ToolCall{{
+			ID:       fmt.Sprintf("call_%d", a.callSeq.Add(1)),
+			Type:     "function",
+			Function: *choice.Message.FunctionCall,
+		}}
+		choice.Message.FunctionCall = nil
+		if choice.FinishReason == "function_call" {
+			choice.FinishReason = "tool_calls"
+		}
+	}
+}
@@ -0,0 +1,90 @@
+package agent_test
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/trogui/go-agent-sdk/agent"
+	"github.com/trogui/go-agent-sdk/agent/agenttest"
+)
+
+// legacyRequest is the part of a functions format request checked by the
+// tests
+type legacyRequest struct {
+	Messages []struct {
+		Role         string              `json:"role"`
+		Name         string              `json:"name"`
+		Content      string              `json:"content"`
+		FunctionCall *agent.FunctionCall `json:"function_call"`
+	} `json:"messages"`
+	Functions []struct {
+		Name string `json:"name"`
+	} `json:"functions"`
+	Tools json.RawMessage `json:"tools"`
+}
+
+func TestToolFormatTools(t *testing.T) {
+	e := agenttest.NewEval(t, agent.Config{},
+		agenttest.Response{ToolCalls: []agenttest.ToolCall{{Name: "echo", Arguments: `{"text":"hi"}`}}},
+		agenttest.Response{Content: "done"},
+	)
+	e.Agent.RegisterTool(echoTool("echo"))
+	e.Run("echo hi").AssertNoError().AssertContent("done").AssertToolCalled("echo", map[string]any{"text": "hi"})
+
+	requests := e.Provider.Requests()
+	if len(requests[0].Tools) != 1 {
+		t.Errorf("tools = %d entries, want 1", len(requests[0].Tools))
+	}
+	if _, ok := requestField(t, requests[0], "functions"); ok {
+		t.Error("request has \"functions\" in the tools format")
+	}
+	last := requests[1].Messages[len(requests[1].Messages)-1]
+	if last.Role != "tool" || last.ToolCallID != "call_1" {
+		t.Errorf("last message = %+v, want the tool response to call_1", last)
+	}
+}
+
+func TestToolFormatFunctions(t *testing.T) {
+	a, rt := newRawAgent(t, agent.Config{ToolFormat: agent.ToolFormatFunctions},
+		`{"choices":[{"message":{"role":"assistant","content":"","function_call":{"name":"echo","arguments":"{\"text\":\"hi\"}"}},"finish_reason":"function_call"}]}`,
+		`{"choices":[{"message":{"role":"assistant","content":"done"},"finish_reason":"stop"}]}`,
+	)
+	a.RegisterTool(echoTool("echo"))
+
+	resp, err := a.Run("echo hi")
+	if err != nil {
+		t.Fatalf("Run() error = %v", err)
+	}
+	if resp.Content != "done" {
+		t.Errorf("Content = %q, want %q", resp.Content, "done")
+	}
+	if len(resp.ToolCalls) != 1 || resp.ToolCalls[0].Name != "echo" {
+		t.Errorf("ToolCalls = %+v, want one call to echo", resp.ToolCalls)
+	}
+
+	var first, second legacyRequest
+	if err := json.Unmarshal(rt.requests[0], &first); err != nil {
+		t.Fatal(err)
+	}
+	if err := json.Unmarshal(rt.requests[1], &second); err != nil {
+		t.Fatal(err)
+	}
+	if first.Tools != nil {
+		t.Errorf("request has \"tools\" in the functions format: %s", first.Tools)
+	}
+	if len(first.Functions) != 1 || first.Functions[0].Name != "echo" {
+		t.Errorf("functions = %+v, want echo", first.Functions)
+	}
+
+	n := len(second.Messages)
+	if n < 2 {
+		t.Fatalf("second request has %d messages", n)
+	}
+	call, result := second.Messages[n-2], second.Messages[n-1]
+	if call.Role != "assistant" || call.FunctionCall == nil || call.FunctionCall.Name != "echo" {
+		t.Errorf("call message = %+v, want an assistant function_call to echo", call)
+	}
+	if result.Role != "function" || result.Name != "echo" || result.Content != `{"text":"hi"}` {
+		t.Errorf("result message = %+v, want a function message named echo", result)
+	}
+}
@@ -0,0 +1,97 @@
+package agent
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// defaultGroqWarnThreshold is the remaining request count below which
+// GroqAdapter reports the rate limit as approaching
+const defaultGroqWarnThreshold = 5
+
+// GroqAdapter reads Groq's x-ratelimit-remaining-requests and
+// x-ratelimit-reset-requests headers and backs off before the request limit
+// is hit instead of waiting for a 429
+type GroqAdapter struct {
+	// WarnThreshold is the remaining request count below which
+	// EventRateLimitApproaching is emitted, 5 when zero. A negative value
+	// disables the warning.
+	WarnThreshold int
+
+	mu        sync.Mutex
+	remaining int
+	resetAt   time.Time
+	known     bool
+	clock     Clock
+}
+
+// NewGroqAdapter creates a GroqAdapter with the default threshold
+func NewGroqAdapter() *GroqAdapter {
+	return &GroqAdapter{WarnThreshold: defaultGroqWarnThreshold}
+}
+
+// setClock implements clockUser
+func (g *GroqAdapter) setClock(c Clock) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	g.clock = c
+}
+
+// clockLocked returns the adapter's clock. g.mu must be held.
+func (g *GroqAdapter) clockLocked() Clock {
+	if g.clock == nil {
+		return realClock{}
+	}
+	return g.clock
+}
+
+// BeforeRequest waits for the window to reset when no requests are left
+func (g *GroqAdapter) BeforeRequest(ctx context.Context, req *http.Request) error {
+	wait, _ := g.holdBack()
+	g.mu.Lock()
+	c := g.clockLocked()
+	g.mu.Unlock()
+
+	return sleep(ctx, c, wait)
+}
+
+// holdBack implements holdBacker
+func (g *GroqAdapter) holdBack() (time.Duration, string) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	if !g.known || g.remaining > 0 {
+		return 0, ""
+	}
+	return g.resetAt.Sub(g.clockLocked().Now()), "[Groq] Request limit reached, waiting for reset"
+}
+
+// AfterResponse records the rate limit headers of the response
+func (g *GroqAdapter) AfterResponse(resp *http.Response) *RateLimitStatus {
+	remaining, err := strconv.Atoi(resp.Header.Get("x-ratelimit-remaining-requests"))
+	if err != nil {
+		return nil
+	}
+	// Groq sends the reset as a duration such as "2m59.56s"
+	resetIn, _ := time.ParseDuration(resp.Header.Get("x-ratelimit-reset-requests"))
+
+	g.mu.Lock()
+	g.remaining = remaining
+	g.resetAt = g.clockLocked().Now().Add(resetIn)
+	g.known = true
+	threshold := g.WarnThreshold
+	g.mu.Unlock()
+
+	if threshold == 0 {
+		threshold = defaultGroqWarnThreshold
+	}
+
+	if remaining >= threshold {
+		return nil
+	}
+	return &RateLimitStatus{Remaining: remaining, ResetIn: resetIn}
+}
@@ -0,0 +1,40 @@
+package agent_test
+
+import (
+	"net/http"
+	"strconv"
+	"testing"
+
+	"github.com/trogui/go-agent-sdk/agent"
+)
+
+func TestGroqAdapterWarnThreshold(t *testing.T) {
+	tests := []struct {
+		name      string
+		adapter   *agent.GroqAdapter
+		remaining int
+		warn      bool
+	}{
+		{"constructor, above default", agent.NewGroqAdapter(), 5, false},
+		{"constructor, below default", agent.NewGroqAdapter(), 4, true},
+		{"zero value, above default", &agent.GroqAdapter{}, 5, false},
+		{"zero value, below default", &agent.GroqAdapter{}, 4, true},
+		{"custom", &agent.GroqAdapter{WarnThreshold: 20}, 19, true},
+		{"disabled", &agent.GroqAdapter{WarnThreshold: -1}, 0, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resp := &http.Response{Header: http.Header{}}
+			resp.Header.Set("x-ratelimit-remaining-requests", strconv.Itoa(tt.remaining))
+			resp.Header.Set("x-ratelimit-reset-requests", "2m59.56s")
+
+			status := tt.adapter.AfterResponse(resp)
+			if (status != nil) != tt.warn {
+				t.Fatalf("AfterResponse() = %+v, want a warning: %v", status, tt.warn)
+			}
+			if status != nil && status.Remaining != tt.remaining {
+				t.Errorf("Remaining = %d, want %d", status.Remaining, tt.remaining)
+			}
+		})
+	}
+}
@@ -0,0 +1,115 @@
+package agent
+
+import (
+	"context"
+	"fmt"
+)
+
+// InputRequest is the Data of EventNeedInput. Answer it with
+// Session.SendInputTo(ID, input), or with SendInput when only one request
+// is pending.
+type InputRequest struct {
+	ID     string
+	Prompt string
+}
+
+// pendingInput is an input request waiting for its answer. The channel has
+// room for the answer and is never closed, so answering never blocks or
+// panics, whatever the state of the session.
+type pendingInput struct {
+	id     string
+	answer chan string
+}
+
+// sessionKey and turnKey are the context keys under which a session turn
+// exposes its session and turn ID to tools
+type (
+	sessionKey struct{}
+	turnKey    struct{}
+)
+
+// RequestInput asks the user of the session running the tool for input: it
+// emits EventNeedInput with an InputRequest and blocks until the request is
+// answered with SendInputTo or SendInput. It returns an error when ctx is
+// done, when the session closes, or when the tool does not run in a session.
+// Tool executors call it with the context they receive.
+func RequestInput(ctx context.Context, prompt string) (string, error) {
+	s, ok := ctx.Value(sessionKey{}).(*Session)
+	if !ok {
+		return "", fmt.Errorf("input can only be requested by tools running in a session")
+	}
+
+	s.mu.Lock()
+	if s.closed {
+		s.mu.Unlock()
+		return "", fmt.Errorf("session is closed")
+	}
+	s.inputSeq++
+	request := pendingInput{id: fmt.Sprintf("input-%d", s.inputSeq), answer: make(chan string, 1)}
+	s.pending = append(s.pending, request)
+	s.mu.Unlock()
+
+	turnID, _ := ctx.Value(turnKey{}).(string)
+	s.sendEvent(AgentEvent{
+		Type:    EventNeedInput,
+		Content: prompt,
+		Data:    InputRequest{ID: request.id, Prompt: prompt},
+		TurnID:  turnID,
+	})
+
+	select {
+	case input := <-request.answer:
+		return input, nil
+	case <-ctx.Done():
+		s.dropInput(request.id)
+		return "", fmt.Errorf("input request cancelled: %w", ctx.Err())
+	case <-s.closing:
+		return "", fmt.Errorf("session is closed")
+	}
+}
+
+// SendInput answers the oldest pending input request
+func (s *Session) SendInput(input string) error {
+	return s.answerInput("", input)
+}
+
+// SendInputTo answers the input request with the given ID, as carried by
+// the InputRequest of EventNeedInput
+func (s *Session) SendInputTo(id, input string) error {
+	return s.answerInput(id, input)
+}
+
+// answerInput delivers input to the request with the given ID, or to the
+// oldest one when id is empty
+func (s *Session) answerInput(id, input string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.closed {
+		return fmt.Errorf("session is closed")
+	}
+	for i, request := range s.pending {
+		if id == "" || request.id == id {
+			s.pending = append(s.pending[:i:i], s.pending[i+1:]...)
+			request.answer <- input
+			return nil
+		}
+	}
+	if id == "" {
+		return fmt.Errorf("no input requested")
+	}
+	return fmt.Errorf("no pending input request: %s", id)
+}
+
+// dropInput forgets a request that stopped waiting
+func (s *Session) dropInput(id string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for i, request := range s.pending {
+		if request.id == id {
+			s.pending = append(s.pending[:i:i], s.pending[i+1:]...)
+			return
+		}
+	}
+}
@@ -0,0 +1,248 @@
+package agent
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"math/rand/v2"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// Key selection strategies of Config.KeySelection
+const (
+	KeySelectionWeighted   = "weighted"    // Weighted random (default)
+	KeySelectionRoundRobin = "round_robin" // Smooth weighted round-robin
+)
+
+// Key states reported by KeyHealth
+const (
+	KeyHealthy  = "healthy"   // In rotation
+	KeyEjected  = "ejected"   // Out of rotation after a 401 or 429
+	KeyHalfOpen = "half_open" // Cooldown over, waiting for a probe request
+)
+
+// defaultKeyCooldown is how long a failing key is ejected by default
+const defaultKeyCooldown = 30 * time.Second
+
+// WeightedKey is one entry of Config.APIKeys
+type WeightedKey struct {
+	Key    string
+	Weight int // Share of the traffic relative to the other keys; 1 when zero
+}
+
+// KeyHealth describes a key of Config.APIKeys without its material
+type KeyHealth struct {
+	Index        int    // Position in Config.APIKeys
+	Fingerprint  string // First 8 hex digits of the key's SHA-256
+	Weight       int
+	State        string // KeyHealthy, KeyEjected or KeyHalfOpen
+	Failures     int    // Consecutive 401 and 429 responses
+	EjectedUntil time.Time
+}
+
+// poolKey is the rotation state of a key
+type poolKey struct {
+	key         string
+	fingerprint string
+	weight      int
+	current     int // Smooth round-robin counter
+	failures    int
+	until       time.Time // End of the ejection
+	probing     bool      // A half-open probe is in flight
+}
+
+// keyPool rotates requests across Config.APIKeys, ejecting keys that return
+// 401 or 429 until their cooldown ends. A key whose cooldown has ended gets
+// a single probe request: success puts it back in rotation, failure ejects
+// it again.
+type keyPool struct {
+	mu       sync.Mutex
+	keys     []*poolKey
+	strategy string
+	cooldown time.Duration
+	clock    Clock
+}
+
+func newKeyPool(config Config, c Clock) *keyPool {
+	if len(config.APIKeys) == 0 {
+		return nil
+	}
+	p := &keyPool{strategy: config.KeySelection, cooldown: config.KeyCooldown, clock: c}
+	if p.cooldown == 0 {
+		p.cooldown = defaultKeyCooldown
+	}
+	for _, wk := range config.APIKeys {
+		weight := wk.Weight
+		if weight <= 0 {
+			weight = 1
+		}
+		p.keys = append(p.keys, &poolKey{key: wk.Key, fingerprint: fingerprint(wk.Key), weight: weight})
+	}
+	return p
+}
+
+// setClock implements clockUser
+func (p *keyPool) setClock(c Clock) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.clock = c
+}
+
+// fingerprint identifies a key in logs without revealing it
+func fingerprint(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(sum[:4])
+}
+
+// pick returns the key for the next request and its index. Half-open keys
+// are preferred so that they get probed; when every key is ejected, the one
+// whose cooldown ends first is used.
+func (p *keyPool) pick() (string, int) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	now := p.clock.Now()
+	var available []int
+	for i, k := range p.keys {
+		switch {
+		case k.failures == 0:
+			available = append(available, i)
+		case !now.Before(k.until) && !k.probing:
+			k.probing = true
+			return k.key, i
+		}
+	}
+
+	if len(available) == 0 {
+		soonest := 0
+		for i, k := range p.keys {
+			if k.until.Before(p.keys[soonest].until) {
+				soonest = i
+			}
+		}
+		return p.keys[soonest].key, soonest
+	}
+
+	i := p.choose(available)
+	return p.keys[i].key, i
+}
+
+// choose picks among the healthy keys according to the strategy. It must be
+// called with mu held.
+func (p *keyPool) choose(available []int) int {
+	total := 0
+	for _, i := range available {
+		total += p.keys[i].weight
+	}
+
+	if p.strategy == KeySelectionRoundRobin {
+		best := available[0]
+		for _, i := range available {
+			p.keys[i].current += p.keys[i].weight
+			if p.keys[i].current > p.keys[best].current {
+				best = i
+			}
+		}
+		p.keys[best].current -= total
+		return best
+	}
+
+	n := rand.IntN(total)
+	for _, i := range available {
+		if n < p.keys[i].weight {
+			return i
+		}
+		n -= p.keys[i].weight
+	}
+	return available[len(available)-1]
+}
+
+// report records the status of a response sent with key i, 0 when the
+// request failed without one. It returns true when the key was ejected.
+func (p *keyPool) report(i, status int) bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	k := p.keys[i]
+	k.probing = false
+	switch {
+	case status == 0:
+	case status == http.StatusUnauthorized || status == http.StatusTooManyRequests:
+		k.failures++
+		k.until = p.clock.Now().Add(p.cooldown)
+		return true
+	case status < 400:
+		k.failures = 0
+		k.until = time.Time{}
+	}
+	return false
+}
+
+// health returns the state of every key
+func (p *keyPool) health() []KeyHealth {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	now := p.clock.Now()
+	health := make([]KeyHealth, len(p.keys))
+	for i, k := range p.keys {
+		state := KeyHealthy
+		if k.failures > 0 {
+			state = KeyEjected
+			if !now.Before(k.until) {
+				state = KeyHalfOpen
+			}
+		}
+		health[i] = KeyHealth{
+			Index:        i,
+			Fingerprint:  k.fingerprint,
+			Weight:       k.weight,
+			State:        state,
+			Failures:     k.failures,
+			EjectedUntil: k.until,
+		}
+	}
+	return health
+}
+
+// KeyHealth returns the state of each key of Config.APIKeys, in order, or
+// nil when APIKeys is not set
+func (a *Agent) KeyHealth() []KeyHealth {
+	if a.keys == nil {
+		return nil
+	}
+	return a.keys.health()
+}
+
+// apiKey returns the key for the next request and, when it comes from
+// Config.APIKeys, its index there, -1 otherwise
+func (a *Agent) apiKey(ctx context.Context) (string, int, error) {
+	if a.config.APIKeyFunc != nil {
+		key, err := a.config.APIKeyFunc(ctx)
+		if err != nil {
+			return "", -1, fmt.Errorf("error getting API key: %w", err)
+		}
+		return key, -1, nil
+	}
+	if a.keys != nil {
+		key, index := a.keys.pick()
+		return key, index, nil
+	}
+	return a.config.APIKey, -1, nil
+}
+
+// reportKey records the outcome of a request made with key index of
+// Config.APIKeys, if any
+func (a *Agent) reportKey(index, status int) {
+	if index >= 0 && a.keys.report(index, status) {
+		a.log().Warn().
+			Int("key_index", index).
+			Str("key_fingerprint", a.keys.keys[index].fingerprint).
+			Int("status", status).
+			Msg("[Agent] API key ejected")
+	}
+}
@@ -0,0 +1,34 @@
+package agent_test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/trogui/go-agent-sdk/agent"
+	"github.com/trogui/go-agent-sdk/agent/agenttest"
+)
+
+func TestAPIKeyPrecedence(t *testing.T) {
+	keyFunc := func(ctx context.Context) (string, error) { return "from-func", nil }
+	keys := []agent.WeightedKey{{Key: "from-pool"}}
+
+	tests := []struct {
+		name   string
+		config agent.Config
+		want   string
+	}{
+		{"key", agent.Config{APIKey: "from-key"}, "from-key"},
+		{"keys over key", agent.Config{APIKey: "from-key", APIKeys: keys}, "from-pool"},
+		{"func over keys and key", agent.Config{APIKey: "from-key", APIKeys: keys, APIKeyFunc: keyFunc}, "from-func"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			e := agenttest.NewEval(t, tt.config, agenttest.Response{Content: "ok"})
+			e.Run("hi").AssertContent("ok")
+
+			if got := e.Provider.Requests()[0].Header.Get("Authorization"); got != "Bearer "+tt.want {
+				t.Errorf("Authorization = %q, want %q", got, "Bearer "+tt.want)
+			}
+		})
+	}
+}
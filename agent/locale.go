@@ -0,0 +1,65 @@
+package agent
+
+import (
+	"context"
+	"fmt"
+
+	"golang.org/x/text/language"
+	"golang.org/x/text/language/display"
+)
+
+// localeKey is the context key of the conversation locale
+type localeKey struct{}
+
+// WithLocale sets the language of the conversation. An instruction to reply
+// in that language and format dates, numbers and currencies for the locale
+// is appended to the system prompt, and tools can read the locale with
+// LocaleFromContext. Use Session.SetLocale to change it mid-session.
+func WithLocale(tag language.Tag) RunOption {
+	return func(o *runOptions) {
+		o.locale = tag
+	}
+}
+
+// LocaleFromContext returns the locale set by WithLocale or
+// Session.SetLocale, so tools can localize their own output. The boolean is
+// false when no locale is set.
+func LocaleFromContext(ctx context.Context) (language.Tag, bool) {
+	tag, ok := ctx.Value(localeKey{}).(language.Tag)
+	return tag, ok
+}
+
+// withLocale returns ctx carrying tag for tools, or ctx when tag is unset
+func withLocale(ctx context.Context, tag language.Tag) context.Context {
+	if tag == language.Und {
+		return ctx
+	}
+	return context.WithValue(ctx, localeKey{}, tag)
+}
+
+// localizedPrompt appends the locale instruction to a system prompt
+func localizedPrompt(prompt string, tag language.Tag) string {
+	if tag == language.Und {
+		return prompt
+	}
+	return fmt.Sprintf("%s\n\nReply in %s unless the user asks otherwise, and format dates, numbers and currencies for the %s locale.",
+		prompt, display.English.Tags().Name(tag), tag)
+}
+
+// SetLocale changes the locale of the session, see WithLocale. It takes
+// effect on the next turn; a running turn keeps the previous locale.
+func (s *Session) SetLocale(tag language.Tag) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.options.locale = tag
+	s.messages[0].Content = localizedPrompt(s.prompt, tag)
+}
+
+// Locale returns the locale of the session, language.Und when none is set
+func (s *Session) Locale() language.Tag {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	return s.options.locale
+}
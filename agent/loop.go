@@ -0,0 +1,753 @@
+package agent
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog"
+)
+
+// loop holds the state of a single run or session turn while the agent
+// iterates between the API and the tools
+type loop struct {
+	agent     *Agent
+	ctx       context.Context
+	logPrefix string
+	emit      func(AgentEvent)
+	options   runOptions
+	async     *sync.WaitGroup
+	session   *Session // Nil for Run
+
+	messages   []ConversationMessage
+	usage      Usage
+	loopCount  int
+	maxLoops   int
+	toolCalls  []ToolCallRecord
+	iterations []IterationRecord
+	// priorCalls are the tool calls of the session's previous turns
+	priorCalls []ToolCallRecord
+	last       *apiResponse
+
+	parseRetries      int
+	apiCalls          int
+	usageReports      int
+	reprompted        bool
+	emptyCallsRetried bool   // An empty tool_calls response was retried
+	trimmed           int    // Messages left out by MaxContextMessages, last reported
+	routed            string // Model chosen by Config.ModelRouter for iteration routedAt
+	routedAt          int
+	firstToken        time.Duration // Of the first streamed API call
+	injected          int
+	extra             []ConversationMessage // Sent with the next API call only
+	blocks            []ContextBlock        // Sent with every API call
+
+	// needContinue is asked whether to keep going when maxLoops is reached
+	needContinue func(iteration int) bool
+}
+
+// newLoop prepares a loop over the given messages
+func (a *Agent) newLoop(ctx context.Context, logPrefix string, messages []ConversationMessage, opts runOptions) *loop {
+	return &loop{
+		agent:     a,
+		ctx:       ctx,
+		logPrefix: logPrefix,
+		emit:      func(AgentEvent) {},
+		options:   opts,
+		async:     &a.async,
+		messages:  messages,
+		maxLoops:  a.config.MaxLoops,
+	}
+}
+
+// run iterates until the API returns finish_reason "stop" or an error occurs
+// and logs a summary of the run
+func (l *loop) run() error {
+	start := l.loopCount
+	err := l.iterate()
+	l.logSummary(l.loopCount-start, err)
+	return err
+}
+
+// iterate runs the iterations of run
+func (l *loop) iterate() error {
+	if err := l.agent.checkHandlers(); err != nil {
+		return err
+	}
+
+	reason := ""
+
+	for reason != "stop" {
+		if err := l.ctx.Err(); err != nil {
+			return fmt.Errorf("run cancelled: %w", err)
+		}
+
+		l.loopCount++
+
+		if l.loopCount > l.maxLoops {
+			finalized, err := l.maxLoopsReached()
+			if err != nil {
+				// The iteration never ran, so the Response reports MaxLoops
+				l.loopCount--
+				return err
+			}
+			if finalized {
+				break
+			}
+		}
+
+		l.emit(AgentEvent{
+			Type:      EventIterationStart,
+			Content:   fmt.Sprintf("Starting iteration %d", l.loopCount),
+			Iteration: l.loopCount,
+		})
+
+		l.logIteration().Int("iteration", l.loopCount).Msg(l.logPrefix + " Starting iteration")
+
+		// Messages queued by the previous iteration, kept for the retry when
+		// the call fails; those added below are rebuilt for each request
+		queued := len(l.extra)
+		l.contextBlocks()
+		if err := l.checkContext(); err != nil {
+			return err
+		}
+		l.budgetNote()
+		l.reminder()
+
+		resp, err := l.agent.callAPI(l.ctx, apiRequest{
+			messages:  l.contextMessages(),
+			options:   l.options,
+			model:     l.model(),
+			stream:    l.agent.config.Stream,
+			maxTokens: l.agent.config.MaxTokens,
+			extra:     l.extra,
+		})
+		if err != nil {
+			l.extra = l.extra[:queued]
+			l.circuitOpen(err)
+			err = fmt.Errorf("API call error: %w", err)
+			if hookErr := l.onError(err, apiErrorPhase(err)); hookErr != nil {
+				return hookErr
+			}
+			continue
+		}
+
+		l.extra = nil
+		l.last = resp
+		if l.firstToken == 0 {
+			l.firstToken = resp.firstToken
+		}
+
+		if resp.rateLimit != nil {
+			l.emit(AgentEvent{
+				Type:      EventRateLimitApproaching,
+				Content:   fmt.Sprintf("%d requests remaining, reset in %s", resp.rateLimit.Remaining, resp.rateLimit.ResetIn),
+				Data:      *resp.rateLimit,
+				Iteration: l.loopCount,
+			})
+		}
+		if resp.headers != nil {
+			l.emit(AgentEvent{
+				Type:      EventResponseHeaders,
+				Content:   resp.headers["x-request-id"],
+				Data:      resp.headers,
+				Iteration: l.loopCount,
+			})
+		}
+		reason = resp.Choices[0].FinishReason
+
+		l.addUsage(resp)
+		if reason != "stop" {
+			if err := l.checkTokenBudget(); err != nil {
+				return err
+			}
+		}
+
+		l.logIteration().
+			Int("iteration", l.loopCount).
+			Str("finish_reason", reason).
+			Int("num_tool_calls", len(resp.Choices[0].Message.ToolCalls)).
+			Msg(l.logPrefix + " Received response")
+
+		if reason == "tool_calls" && len(resp.Choices[0].Message.ToolCalls) == 0 {
+			var err error
+			if reason, err = l.emptyToolCalls(); err != nil {
+				return err
+			}
+		}
+
+		toolCallsBefore := len(l.toolCalls)
+		if reason == "tool_calls" {
+			// Add assistant message with tool_calls
+			l.messages = append(l.messages, ConversationMessage{
+				Role:      "assistant",
+				ToolCalls: resp.Choices[0].Message.ToolCalls,
+				CreatedAt: l.agent.clock.Now(),
+			})
+
+			if l.options.skipToolExecution {
+				// A session must not keep unanswered tool calls, which the
+				// API would reject with the next turn
+				if l.session != nil {
+					l.messages = l.messages[:len(l.messages)-1]
+				}
+				l.recordSkippedCalls(resp.Choices[0].Message.ToolCalls)
+				l.recordIterationCalls(toolCallsBefore)
+				return nil
+			}
+
+			// Execute each tool call
+			for _, toolCall := range resp.Choices[0].Message.ToolCalls {
+				if err := l.handleToolCall(toolCall); err != nil {
+					l.recordIterationCalls(toolCallsBefore)
+					return err
+				}
+			}
+			l.recordIterationCalls(toolCallsBefore)
+			l.toolFailures(toolCallsBefore)
+		}
+
+		if reason != "stop" {
+			l.iterationEnd(resp, toolCallsBefore)
+		}
+
+		if reason == "stop" && l.shouldReprompt() {
+			reason = ""
+		}
+	}
+
+	if l.last == nil || len(l.last.Choices) == 0 {
+		return fmt.Errorf("no response from API")
+	}
+	if l.agent.config.RejectEmptyCompletion && l.emptyContent() {
+		return &EmptyCompletionError{Raw: l.last.raw}
+	}
+
+	// Add final assistant message
+	l.messages = append(l.messages, ConversationMessage{
+		Role:      "assistant",
+		Content:   l.content(),
+		CreatedAt: l.agent.clock.Now(),
+	})
+
+	return nil
+}
+
+// handleToolCall executes a tool call and appends its response to the messages
+func (l *loop) handleToolCall(toolCall ToolCall) error {
+	l.logIteration().
+		Str("tool_name", toolCall.Function.Name).
+		Str("arguments", l.agent.redact(toolCall.Function.Arguments)).
+		Msg(l.logPrefix + " Executing tool")
+
+	l.emit(AgentEvent{
+		Type:       EventToolCall,
+		Content:    toolCall.Function.Name,
+		Data:       l.agent.redact(toolCall.Function.Arguments),
+		Iteration:  l.loopCount,
+		ToolCallID: toolCall.ID,
+	})
+
+	record := ToolCallRecord{
+		ID:        toolCall.ID,
+		Name:      toolCall.Function.Name,
+		Arguments: toolCall.Function.Arguments,
+		Iteration: l.loopCount,
+	}
+
+	var result any
+	var err error
+	if l.shouldRetryParse(toolCall.Function.Arguments) {
+		l.parseRetries++
+		l.agent.log().Warn().
+			Str("tool", toolCall.Function.Name).
+			Int("retry", l.parseRetries).
+			Msg(l.logPrefix + " Malformed tool arguments, asking the model to retry")
+		err = fmt.Errorf("the arguments are not valid JSON, call %s again with valid JSON arguments", toolCall.Function.Name)
+	} else if err = l.checkPrecondition(toolCall.Function.Name); err != nil {
+		l.agent.log().Info().
+			Err(err).
+			Str("tool", toolCall.Function.Name).
+			Msg(l.logPrefix + " Tool precondition not met")
+	} else if ok, retryAfter := l.agent.allowTool(l.agent.lookupTool(toolCall.Function.Name)); !ok {
+		result = l.rateLimited(toolCall, retryAfter)
+	} else if tool := l.agent.lookupTool(toolCall.Function.Name); tool != nil && tool.Async {
+		result = l.agent.dispatchAsync(l.ctx, l.async, toolCall.Function.Name, json.RawMessage(toolCall.Function.Arguments))
+	} else {
+		ctx := withToolChunks(l.ctx, func(chunk string) {
+			l.emit(AgentEvent{
+				Type:       EventToolStreamChunk,
+				Content:    chunk,
+				Data:       ToolStreamChunk{Content: chunk},
+				Iteration:  l.loopCount,
+				ToolCallID: toolCall.ID,
+			})
+		})
+		tool := l.agent.lookupTool(toolCall.Function.Name)
+		before, snapshotted := l.snapshotState(ctx, tool)
+		result, err = l.agent.executeTool(ctx, toolCall.Function.Name, json.RawMessage(toolCall.Function.Arguments))
+		if snapshotted {
+			l.stateChange(ctx, tool, toolCall, before)
+		}
+	}
+
+	if paged, ok := result.(*PagedResult); ok && err == nil {
+		result = l.agent.paginate(l.ctx, paged)
+	}
+
+	var resultJSON []byte
+	if err == nil {
+		if resultJSON, err = json.Marshal(result); err != nil {
+			err = l.encodeError(toolCall, err)
+		} else if limit := l.agent.config.MaxToolResultLength; limit > 0 && len(resultJSON) > limit {
+			resultJSON = l.truncateResult(toolCall, resultJSON, limit)
+		}
+	}
+
+	var content string
+	if err != nil {
+		l.agent.log().Error().Err(err).Str("tool", toolCall.Function.Name).Msg(l.logPrefix + " Tool execution error")
+		if l.agent.config.OnError != nil {
+			if hookErr := l.agent.config.OnError(l.ctx, err, PhaseToolExecution); hookErr != nil {
+				return hookErr
+			}
+		}
+		content = fmt.Sprintf(`{"error": "%s"}`, err.Error())
+		record.Error = err.Error()
+		record.Err = err
+	} else {
+		content = string(resultJSON)
+	}
+
+	record.Result = content
+	l.toolCalls = append(l.toolCalls, record)
+
+	l.emit(AgentEvent{
+		Type:       EventToolResult,
+		Content:    l.agent.redact(content),
+		Data:       toolCall.Function.Name,
+		Iteration:  l.loopCount,
+		ToolCallID: toolCall.ID,
+	})
+
+	// Add tool response
+	l.messages = append(l.messages, ConversationMessage{
+		Role:       "tool",
+		Content:    content,
+		ToolCallID: toolCall.ID,
+		CreatedAt:  l.agent.clock.Now(),
+	})
+
+	return nil
+}
+
+// checkPrecondition runs the tool's Precondition against the calls made so
+// far in the run or session
+func (l *loop) checkPrecondition(name string) error {
+	tool := l.agent.lookupTool(name)
+	if tool == nil || tool.Precondition == nil {
+		return nil
+	}
+
+	state := SessionState{last: make(map[string]ToolCallRecord)}
+	for _, calls := range [][]ToolCallRecord{l.priorCalls, l.toolCalls} {
+		for _, call := range calls {
+			state.last[call.Name] = call
+		}
+	}
+	return tool.Precondition(l.ctx, state)
+}
+
+// iterationEnd calls Config.OnIterationEnd and queues the messages it
+// returns for the next request, within Config.MaxInjectedMessages
+func (l *loop) iterationEnd(resp *apiResponse, firstCall int) {
+	hook := l.agent.config.OnIterationEnd
+	if hook == nil {
+		return
+	}
+
+	messages := make([]ConversationMessage, len(l.messages))
+	copy(messages, l.messages)
+	injected := hook(l.ctx, IterationResult{
+		Iteration:    l.loopCount,
+		Content:      resp.Choices[0].Message.Content,
+		FinishReason: resp.Choices[0].FinishReason,
+		ToolCalls:    l.toolCalls[firstCall:],
+		ToolErrors:   newToolErrors(l.loopCount, l.toolCalls[firstCall:]),
+		Messages:     messages,
+	})
+
+	if room := l.agent.config.MaxInjectedMessages - l.injected; len(injected) > room {
+		l.agent.log().Warn().
+			Int("dropped", len(injected)-room).
+			Int("limit", l.agent.config.MaxInjectedMessages).
+			Msg(l.logPrefix + " Too many injected messages, dropping the rest")
+		injected = injected[:room]
+	}
+	l.injected += len(injected)
+	l.extra = append(l.extra, injected...)
+}
+
+// reminder queues Config.PerTurnReminder as the last message of the next
+// request. Like every queued message it is never added to the history.
+func (l *loop) reminder() {
+	hook := l.agent.config.PerTurnReminder
+	if hook == nil {
+		return
+	}
+	if text := hook(l.ctx, l.session); text != "" {
+		l.extra = append(l.extra, ConversationMessage{Role: "system", Content: text})
+	}
+}
+
+// encodeError reports a tool result that cannot be encoded as JSON, a bug
+// in the handler rather than a failure the model caused, and returns the
+// tool error sent to the model instead
+func (l *loop) encodeError(toolCall ToolCall, err error) error {
+	l.agent.log().Error().
+		Err(err).
+		Str("tool", toolCall.Function.Name).
+		Msg(l.logPrefix + " Tool returned a result that cannot be encoded as JSON")
+
+	l.emit(AgentEvent{
+		Type:       EventToolResultInvalid,
+		Content:    err.Error(),
+		Data:       toolCall.Function.Name,
+		Iteration:  l.loopCount,
+		ToolCallID: toolCall.ID,
+	})
+
+	return fmt.Errorf("the tool returned a result that could not be encoded")
+}
+
+// recordSkippedCalls records tool calls that WithoutToolExecution left
+// unexecuted
+func (l *loop) recordSkippedCalls(calls []ToolCall) {
+	for _, call := range calls {
+		l.emit(AgentEvent{
+			Type:       EventToolCall,
+			Content:    call.Function.Name,
+			Data:       l.agent.redact(call.Function.Arguments),
+			Iteration:  l.loopCount,
+			ToolCallID: call.ID,
+		})
+		l.toolCalls = append(l.toolCalls, ToolCallRecord{
+			ID:        call.ID,
+			Name:      call.Function.Name,
+			Arguments: call.Function.Arguments,
+			Iteration: l.loopCount,
+		})
+	}
+	l.agent.log().Info().Int("num_tool_calls", len(calls)).Msg(l.logPrefix + " Tool execution disabled, returning tool calls")
+}
+
+// model returns the model of the current iteration: the choice of
+// Config.ModelRouter, or Config.Model
+func (l *loop) model() string {
+	if l.agent.config.ModelRouter == nil {
+		return l.agent.config.Model
+	}
+	if l.routedAt != l.loopCount {
+		l.routed = l.agent.routeModel(l.messages, l.loopCount)
+		l.routedAt = l.loopCount
+		if l.routed != "" {
+			l.logIteration().Str("model", l.routed).Msg(l.logPrefix + " Model routed")
+		}
+	}
+	if l.routed == "" {
+		return l.agent.config.Model
+	}
+	return l.routed
+}
+
+// routeModel asks Config.ModelRouter for the model of an iteration over a
+// copy of messages, "" when there is no router or it keeps Config.Model
+func (a *Agent) routeModel(messages []ConversationMessage, iteration int) string {
+	if a.config.ModelRouter == nil {
+		return ""
+	}
+	copied := make([]ConversationMessage, len(messages))
+	copy(copied, messages)
+	return a.config.ModelRouter(copied, iteration)
+}
+
+// contextMessages returns the messages to send, limited to
+// Config.MaxContextMessages. The history itself is not changed. A trim that
+// leaves out more messages than before is reported as a compaction.
+func (l *loop) contextMessages() []ConversationMessage {
+	max := l.agent.config.MaxContextMessages
+	if max <= 0 {
+		return l.messages
+	}
+
+	sent := trimMessages(l.messages, 0)
+	trimmed := trimMessages(l.messages, max)
+	if dropped := len(sent) - len(trimmed); dropped > l.trimmed {
+		l.trimmed = dropped
+		l.agent.historyCompacted(l.emit, CompactionTrim, sent, trimmed, l.loopCount)
+	}
+	return trimmed
+}
+
+// checkContext estimates the size of the next request and fails fast with
+// ErrContextLengthExceeded when it would not fit in the context window,
+// compacting tool exchanges first when configured
+func (l *loop) checkContext() error {
+	config := l.agent.config
+	window := l.agent.contextWindow(l.model())
+	if window == 0 {
+		return nil
+	}
+
+	limit := window * (100 - config.ContextSafetyMargin) / 100
+	estimate := l.estimateTokens() + config.MaxTokens
+
+	if estimate > limit && config.CompactOnOverflow {
+		before := l.messages
+		l.messages = CompactToolCalls(l.messages, config.CompactToolNote)
+		l.agent.historyCompacted(l.emit, CompactionToolCalls, before, l.messages, l.loopCount)
+		estimate = l.estimateTokens() + config.MaxTokens
+	}
+
+	l.emit(AgentEvent{
+		Type:      EventContextEstimate,
+		Content:   fmt.Sprintf("estimated %d of %d tokens", estimate, limit),
+		Data:      ContextEstimate{EstimatedTokens: estimate, LimitTokens: limit},
+		Iteration: l.loopCount,
+	})
+
+	if estimate > limit {
+		return fmt.Errorf("%w: estimated %d tokens, limit %d", ErrContextLengthExceeded, estimate, limit)
+	}
+	return nil
+}
+
+// estimateTokens estimates the prompt tokens of the next request: the
+// history and the messages queued so far, such as context blocks
+func (l *loop) estimateTokens() int {
+	messages := append(l.messages[:len(l.messages):len(l.messages)], l.extra...)
+	return l.agent.estimateTokens(messages)
+}
+
+// logIteration starts a per-iteration log line, at debug level when
+// Config.QuietIterations is set
+func (l *loop) logIteration() *zerolog.Event {
+	if l.agent.config.QuietIterations {
+		return l.agent.log().Debug()
+	}
+	return l.agent.log().Info()
+}
+
+// logSummary logs one line describing a finished run or turn
+func (l *loop) logSummary(iterations int, err error) {
+	tools := make([]string, len(l.toolCalls))
+	for i, call := range l.toolCalls {
+		tools[i] = call.Name
+	}
+
+	finishReason := ""
+	if l.last != nil && len(l.last.Choices) > 0 {
+		finishReason = l.last.Choices[0].FinishReason
+	}
+
+	event := l.agent.log().Info()
+	if err != nil {
+		event = l.agent.log().Warn().Err(err)
+	}
+	event.
+		Str("model", l.agent.config.Model).
+		Int("prompt_tokens", l.usage.PromptTokens).
+		Int("completion_tokens", l.usage.CompletionTokens).
+		Int("total_tokens", l.usage.TotalTokens).
+		Int("loop_count", iterations).
+		Str("finish_reason", finishReason).
+		Strs("tools", tools).
+		Msg(l.logPrefix + " Run finished")
+}
+
+// onError passes an error to Config.OnError. Without a hook the error is
+// returned as is, i.e. it is fatal.
+func (l *loop) onError(err error, phase string) error {
+	if l.agent.config.OnError == nil {
+		return err
+	}
+	if hookErr := l.agent.config.OnError(l.ctx, err, phase); hookErr != nil {
+		return hookErr
+	}
+
+	l.agent.log().Warn().Err(err).Str("phase", phase).Msg(l.logPrefix + " Error ignored by OnError hook")
+	return nil
+}
+
+// addUsage accumulates the token usage of an API response. Gateways may
+// omit usage, which is tracked so callers know the totals are incomplete.
+func (l *loop) addUsage(resp *apiResponse) {
+	l.apiCalls++
+	l.iterations = append(l.iterations, IterationRecord{LoopNumber: l.loopCount})
+	if resp.Usage == nil {
+		l.agent.log().Debug().Int("iteration", l.loopCount).Msg(l.logPrefix + " Response carried no usage")
+		return
+	}
+
+	l.usageReports++
+	l.usage.PromptTokens += resp.Usage.PromptTokens
+	l.usage.CompletionTokens += resp.Usage.CompletionTokens
+	l.usage.TotalTokens += resp.Usage.TotalTokens
+	record := &l.iterations[len(l.iterations)-1]
+	record.PromptTokens = resp.Usage.PromptTokens
+	record.CompletionTokens = resp.Usage.CompletionTokens
+}
+
+// recordIterationCalls attaches the tool calls from firstCall on to the
+// record of the current iteration
+func (l *loop) recordIterationCalls(firstCall int) {
+	if len(l.iterations) == 0 || len(l.toolCalls) == firstCall {
+		return
+	}
+	calls := l.toolCalls[firstCall:]
+	record := &l.iterations[len(l.iterations)-1]
+	record.ToolCalls = calls[:len(calls):len(calls)]
+	record.ToolErrors = newToolErrors(l.loopCount, calls)
+}
+
+// toolFailures emits EventToolFailures when several tool calls of the
+// iteration failed. A single failure is only reported by its
+// EventToolResult.
+func (l *loop) toolFailures(firstCall int) {
+	failures := newToolErrors(l.loopCount, l.toolCalls[firstCall:])
+	if failures == nil || len(failures.Failures) < 2 {
+		return
+	}
+	l.agent.log().Warn().
+		Int("iteration", l.loopCount).
+		Int("failed", len(failures.Failures)).
+		Msg(l.logPrefix + " Several tool calls failed")
+	l.emit(AgentEvent{
+		Type:      EventToolFailures,
+		Content:   failures.Error(),
+		Data:      failures,
+		Iteration: l.loopCount,
+	})
+}
+
+// shouldRetryParse reports whether the arguments are malformed and the model
+// should be asked to send them again
+func (l *loop) shouldRetryParse(arguments string) bool {
+	config := l.agent.config
+	if !config.FunctionCallRetryOnParse || l.parseRetries >= config.FunctionCallRetryMax {
+		return false
+	}
+	return arguments != "" && !json.Valid([]byte(arguments))
+}
+
+// emptyFinalPrompt asks for a final answer after an empty completion
+const emptyFinalPrompt = "Your last reply was empty. Reply to the user with your final answer, summarizing the results of the tools you used."
+
+// shouldReprompt reports whether the model stopped without any text and
+// should be asked once more for a final answer. The request goes with the
+// next call only and is not added to the messages.
+func (l *loop) shouldReprompt() bool {
+	if !l.emptyContent() {
+		return false
+	}
+
+	l.agent.log().Warn().Int("iteration", l.loopCount).Msg(l.logPrefix + " Model stopped without any content")
+	if !(l.agent.config.RepromptOnEmpty || l.agent.config.RejectEmptyCompletion) || l.reprompted {
+		return false
+	}
+
+	l.reprompted = true
+	l.extra = []ConversationMessage{{Role: "user", Content: emptyFinalPrompt}}
+	return true
+}
+
+// emptyToolCalls handles a response with finish_reason "tool_calls" but no
+// tool calls, sent by some gateways, which would otherwise spin until
+// MaxLoops. Text in the response is taken as the final answer; without
+// any, the call is retried once before failing with ErrEmptyToolCalls. It
+// returns the finish reason to continue with.
+func (l *loop) emptyToolCalls() (string, error) {
+	if !l.emptyContent() {
+		l.agent.log().Warn().Int("iteration", l.loopCount).Msg(l.logPrefix + " No tool calls despite finish_reason tool_calls, treating the content as the answer")
+		return "stop", nil
+	}
+	if l.emptyCallsRetried {
+		return "", fmt.Errorf("%w (iteration %d)", ErrEmptyToolCalls, l.loopCount)
+	}
+
+	l.agent.log().Warn().Int("iteration", l.loopCount).Msg(l.logPrefix + " No tool calls despite finish_reason tool_calls, retrying")
+	l.emptyCallsRetried = true
+	return "", nil
+}
+
+// emptyContent reports whether the last response has no text other than
+// whitespace
+func (l *loop) emptyContent() bool {
+	return strings.TrimSpace(l.content()) == ""
+}
+
+// audio decodes the audio of the last response, or returns nil
+func (l *loop) audio() *Audio {
+	if l.last == nil || len(l.last.Choices) == 0 || l.last.Choices[0].Message.Audio == nil {
+		return nil
+	}
+	raw := l.last.Choices[0].Message.Audio
+	data, err := base64.StdEncoding.DecodeString(raw.Data)
+	if err != nil {
+		l.agent.log().Warn().Err(err).Msg(l.logPrefix + " Invalid audio in response")
+		return nil
+	}
+	audio := &Audio{
+		ID:         raw.ID,
+		Data:       data,
+		Transcript: raw.Transcript,
+	}
+	if l.agent.config.Audio != nil {
+		audio.Format = l.agent.config.Audio.Format
+	}
+	if raw.ExpiresAt > 0 {
+		audio.ExpiresAt = time.Unix(raw.ExpiresAt, 0)
+	}
+	return audio
+}
+
+// content returns the text of the last API response, or the transcript of
+// its audio when it has no text
+func (l *loop) content() string {
+	if l.last == nil || len(l.last.Choices) == 0 {
+		return ""
+	}
+	msg := l.last.Choices[0].Message
+	if msg.Content == "" && msg.Audio != nil {
+		return msg.Audio.Transcript
+	}
+	return msg.Content
+}
+
+// response builds a Response from the current loop state
+func (l *loop) response() *Response {
+	resp := &Response{
+		Content:        l.content(),
+		Usage:          l.usage,
+		LoopCount:      l.loopCount,
+		UsageAvailable: l.apiCalls > 0 && l.usageReports == l.apiCalls,
+		Messages:       l.messages,
+		ToolCalls:      l.toolCalls,
+		Iterations:     l.iterations,
+
+		TimeToFirstToken: l.firstToken,
+	}
+	resp.EmptyContent = l.last != nil && l.emptyContent()
+	if l.last != nil && len(l.last.Choices) > 0 {
+		resp.FinishReason = l.last.Choices[0].FinishReason
+	}
+	if l.last != nil {
+		resp.Headers = l.last.headers
+		resp.Audio = l.audio()
+	}
+	return resp
+}
@@ -0,0 +1,93 @@
+package agent
+
+import (
+	"bytes"
+	"encoding/json"
+	"strings"
+)
+
+// ExportMarkdown renders the session history as a Markdown document, e.g.
+// for audit reports or transcripts. Messages become "## User",
+// "## Assistant" and "## System" sections; each tool call is a
+// "### Tool: <name>" section under the assistant message that made it, with
+// its arguments and result in fenced code blocks.
+func (s *Session) ExportMarkdown() string {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	results := make(map[string]string)
+	for _, msg := range s.messages {
+		if msg.Role == "tool" {
+			results[msg.ToolCallID] = msg.Content
+		}
+	}
+	answered := make(map[string]bool)
+
+	var b strings.Builder
+	for _, msg := range s.messages {
+		switch msg.Role {
+		case "tool":
+			// Rendered with its call, unless the call is not in the history
+			if answered[msg.ToolCallID] {
+				continue
+			}
+			markdownSection(&b, "### Tool result")
+			markdownCode(&b, msg.Content)
+			continue
+		case "user":
+			markdownSection(&b, "## User")
+		case "assistant":
+			markdownSection(&b, "## Assistant")
+		case "system":
+			markdownSection(&b, "## System")
+		default:
+			markdownSection(&b, "## "+msg.Role)
+		}
+
+		if msg.Content != "" {
+			b.WriteString(msg.Content)
+			b.WriteString("\n")
+		}
+		for _, call := range msg.ToolCalls {
+			markdownSection(&b, "### Tool: "+call.Function.Name)
+			b.WriteString("Arguments:\n\n")
+			markdownCode(&b, call.Function.Arguments)
+			if result, ok := results[call.ID]; ok {
+				answered[call.ID] = true
+				b.WriteString("\nResult:\n\n")
+				markdownCode(&b, result)
+			}
+		}
+	}
+	return b.String()
+}
+
+// markdownSection starts a Markdown section, separated from the previous
+// one by a blank line
+func markdownSection(b *strings.Builder, heading string) {
+	if b.Len() > 0 && !strings.HasSuffix(b.String(), "\n\n") {
+		b.WriteString("\n")
+	}
+	b.WriteString(heading)
+	b.WriteString("\n\n")
+}
+
+// markdownCode writes content as a fenced code block, indented and tagged as
+// JSON when it is valid JSON. The fence is longer than any run of backticks
+// in content.
+func markdownCode(b *strings.Builder, content string) {
+	lang := ""
+	var indented bytes.Buffer
+	if json.Indent(&indented, []byte(content), "", "  ") == nil {
+		content = indented.String()
+		lang = "json"
+	}
+
+	fence := "```"
+	for strings.Contains(content, fence) {
+		fence += "`"
+	}
+	b.WriteString(fence + lang + "\n")
+	b.WriteString(strings.TrimRight(content, "\n"))
+	b.WriteString("\n" + fence + "\n")
+}
@@ -0,0 +1,72 @@
+package agent
+
+import (
+	"fmt"
+)
+
+// Policies of Config.OnMaxLoops and results of Config.MaxLoopsHandler
+const (
+	MaxLoopsError    = "error"    // Fail with ErrMaxLoopsExceeded (default)
+	MaxLoopsFinalize = "finalize" // Make one last call without tools
+	MaxLoopsContinue = "continue" // Grant another MaxLoops iterations; MaxLoopsHandler only
+)
+
+// finalizePrompt asks the model for an answer when MaxLoops is reached
+const finalizePrompt = "You have reached the limit of tool calls. Do not call any more tools; give your best final answer based on what you have so far."
+
+// maxLoopsPolicy returns what to do when the loop reaches MaxLoops
+func (l *loop) maxLoopsPolicy() string {
+	if handler := l.agent.config.MaxLoopsHandler; handler != nil {
+		return handler(l.ctx, l.response())
+	}
+	if l.agent.config.OnMaxLoops == "" {
+		return MaxLoopsError
+	}
+	return l.agent.config.OnMaxLoops
+}
+
+// maxLoopsReached applies the MaxLoops policy. It reports whether the loop
+// should stop with the finalized response as its answer; otherwise the
+// loop continues, or an error ends it.
+func (l *loop) maxLoopsReached() (bool, error) {
+	if l.needContinue != nil && l.needContinue(l.loopCount) {
+		l.maxLoops += l.agent.config.MaxLoops
+		return false, nil
+	}
+
+	switch policy := l.maxLoopsPolicy(); policy {
+	case MaxLoopsError:
+		return false, fmt.Errorf("%w (%d)", ErrMaxLoopsExceeded, l.maxLoops)
+	case MaxLoopsContinue:
+		l.maxLoops += l.agent.config.MaxLoops
+		return false, nil
+	case MaxLoopsFinalize:
+		return true, l.finalize()
+	default:
+		return false, fmt.Errorf("unknown MaxLoops policy: %s", policy)
+	}
+}
+
+// finalize makes one last call with tool_choice "none" so the model answers
+// with what it has instead of calling more tools
+func (l *loop) finalize() error {
+	l.agent.log().Info().
+		Int("max_loops", l.maxLoops).
+		Msg(l.logPrefix + " Maximum loop iterations reached, asking for a final answer")
+
+	resp, err := l.agent.callAPI(l.ctx, apiRequest{
+		messages:   l.contextMessages(),
+		options:    l.options,
+		model:      l.model(),
+		maxTokens:  l.agent.config.MaxTokens,
+		toolChoice: "none",
+		extra:      append(l.extra, ConversationMessage{Role: "system", Content: finalizePrompt}),
+	})
+	l.extra = nil
+	if err != nil {
+		return fmt.Errorf("API call error: %w", err)
+	}
+	l.last = resp
+	l.addUsage(resp)
+	return nil
+}
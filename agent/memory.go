@@ -0,0 +1,251 @@
+package agent
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Names of the memory tools registered when Config.Memory is set
+const (
+	MemoryRemember = "remember"
+	MemoryRecall   = "recall"
+)
+
+// memoryFormatVersion is the version of the FileMemory file format
+const memoryFormatVersion = 1
+
+// Fact is a piece of durable knowledge kept across sessions
+type Fact struct {
+	Key       string    `json:"key"`
+	Value     string    `json:"value"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// Memory stores facts across sessions. Implementations must be safe for
+// concurrent use.
+type Memory interface {
+	// Remember stores value under key, replacing any previous value
+	Remember(ctx context.Context, key, value string) error
+	// Recall returns the facts relevant to query, most relevant first. An
+	// empty query returns the most recent facts.
+	Recall(ctx context.Context, query string) []Fact
+}
+
+// FileMemory is a Memory persisted as a JSON file
+type FileMemory struct {
+	path  string
+	mu    sync.RWMutex
+	facts map[string]Fact
+	clock Clock
+}
+
+// memoryFile is the on-disk format of FileMemory. Version is bumped on
+// incompatible changes so newer files are never silently rewritten.
+type memoryFile struct {
+	Version int    `json:"version"`
+	Facts   []Fact `json:"facts"`
+}
+
+// NewFileMemory opens the memory stored at path, which is created on the
+// first Remember if it doesn't exist
+func NewFileMemory(path string) (*FileMemory, error) {
+	m := &FileMemory{
+		path:  path,
+		facts: make(map[string]Fact),
+		clock: realClock{},
+	}
+
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return m, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error reading memory: %w", err)
+	}
+
+	var file memoryFile
+	if err := json.Unmarshal(data, &file); err != nil {
+		return nil, fmt.Errorf("error parsing memory: %w", err)
+	}
+	if file.Version > memoryFormatVersion {
+		return nil, fmt.Errorf("memory file version %d is newer than supported version %d", file.Version, memoryFormatVersion)
+	}
+	for _, fact := range file.Facts {
+		m.facts[fact.Key] = fact
+	}
+	return m, nil
+}
+
+// Remember stores value under key and writes the file
+func (m *FileMemory) Remember(ctx context.Context, key, value string) error {
+	if key == "" {
+		return fmt.Errorf("fact key is required")
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.facts[key] = Fact{Key: key, Value: value, UpdatedAt: m.clock.Now()}
+	return m.save()
+}
+
+// Recall ranks facts by how many words of query they contain, then by
+// recency
+func (m *FileMemory) Recall(ctx context.Context, query string) []Fact {
+	words := strings.Fields(strings.ToLower(query))
+
+	m.mu.RLock()
+	type scored struct {
+		fact  Fact
+		score int
+	}
+	matches := make([]scored, 0, len(m.facts))
+	for _, fact := range m.facts {
+		text := strings.ToLower(fact.Key + " " + fact.Value)
+		score := 0
+		for _, word := range words {
+			if strings.Contains(text, word) {
+				score++
+			}
+		}
+		if score > 0 || len(words) == 0 {
+			matches = append(matches, scored{fact, score})
+		}
+	}
+	m.mu.RUnlock()
+
+	sort.Slice(matches, func(i, j int) bool {
+		if matches[i].score != matches[j].score {
+			return matches[i].score > matches[j].score
+		}
+		return matches[i].fact.UpdatedAt.After(matches[j].fact.UpdatedAt)
+	})
+
+	facts := make([]Fact, len(matches))
+	for i, match := range matches {
+		facts[i] = match.fact
+	}
+	return facts
+}
+
+// save writes the facts atomically. It must be called with mu held.
+func (m *FileMemory) save() error {
+	file := memoryFile{Version: memoryFormatVersion, Facts: make([]Fact, 0, len(m.facts))}
+	for _, fact := range m.facts {
+		file.Facts = append(file.Facts, fact)
+	}
+	sort.Slice(file.Facts, func(i, j int) bool { return file.Facts[i].Key < file.Facts[j].Key })
+
+	data, err := json.MarshalIndent(file, "", "  ")
+	if err != nil {
+		return fmt.Errorf("error encoding memory: %w", err)
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(m.path), filepath.Base(m.path)+".*")
+	if err != nil {
+		return fmt.Errorf("error writing memory: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return fmt.Errorf("error writing memory: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("error writing memory: %w", err)
+	}
+	if err := os.Rename(tmp.Name(), m.path); err != nil {
+		return fmt.Errorf("error writing memory: %w", err)
+	}
+	return nil
+}
+
+func (m *FileMemory) setClock(c Clock) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.clock = c
+}
+
+// memoryTools returns the remember and recall tools backed by m
+func memoryTools(m Memory, limit int) []*Tool {
+	return []*Tool{
+		{
+			Name:        MemoryRemember,
+			Description: "Store a durable fact about the user or task for future conversations, e.g. preferences. Reusing a key replaces the fact.",
+			Parameters: map[string]Parameter{
+				"key":   {Type: "string", Description: "Short identifier of the fact, e.g. \"units\""},
+				"value": {Type: "string", Description: "The fact, e.g. \"The user prefers metric units\""},
+			},
+			Required: []string{"key", "value"},
+			Version:  "1",
+			Executor: ToolExecutorFunc(func(ctx context.Context, args json.RawMessage) (any, error) {
+				var payload struct {
+					Key   string `json:"key"`
+					Value string `json:"value"`
+				}
+				if err := json.Unmarshal(args, &payload); err != nil {
+					return nil, err
+				}
+				if err := m.Remember(ctx, payload.Key, payload.Value); err != nil {
+					return nil, err
+				}
+				return map[string]any{"status": "remembered", "key": payload.Key}, nil
+			}),
+		},
+		{
+			Name:        MemoryRecall,
+			Description: "Search the facts stored in previous conversations.",
+			Parameters: map[string]Parameter{
+				"query": {Type: "string", Description: "Words to look for in the facts"},
+			},
+			Required: []string{"query"},
+			Version:  "1",
+			Executor: ToolExecutorFunc(func(ctx context.Context, args json.RawMessage) (any, error) {
+				var payload struct {
+					Query string `json:"query"`
+				}
+				if err := json.Unmarshal(args, &payload); err != nil {
+					return nil, err
+				}
+				facts := m.Recall(ctx, payload.Query)
+				if len(facts) > limit {
+					facts = facts[:limit]
+				}
+				return map[string]any{"facts": facts}, nil
+			}),
+		},
+	}
+}
+
+// systemPrompt returns Config.SystemPrompt followed by the top facts
+// recalled for query when Config.Memory is set
+func (a *Agent) systemPrompt(ctx context.Context, query string) string {
+	if a.config.Memory == nil {
+		return a.config.SystemPrompt
+	}
+
+	facts := a.config.Memory.Recall(ctx, query)
+	if len(facts) > a.config.MemoryRecallK {
+		facts = facts[:a.config.MemoryRecallK]
+	}
+	if len(facts) == 0 {
+		return a.config.SystemPrompt
+	}
+
+	var prompt strings.Builder
+	prompt.WriteString(a.config.SystemPrompt)
+	prompt.WriteString("\n\nFacts remembered from previous conversations:\n")
+	for _, fact := range facts {
+		fmt.Fprintf(&prompt, "- %s: %s\n", fact.Key, fact.Value)
+	}
+	return prompt.String()
+}
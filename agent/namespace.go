@@ -0,0 +1,72 @@
+package agent
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// NamespaceSeparator joins a namespace and a tool name, e.g.
+// "github__create_issue". Dots are not allowed in tool names by
+// OpenAI-compatible APIs.
+const NamespaceSeparator = "__"
+
+// maxToolName is the longest tool name accepted by OpenAI-compatible APIs
+const maxToolName = 64
+
+// validNamespace matches namespaces that cannot contain the separator or
+// end with half of it
+var validNamespace = regexp.MustCompile(`^[a-zA-Z0-9](?:[a-zA-Z0-9-]|_[a-zA-Z0-9-])*$`)
+
+// namespaceKey is the context key of the namespace of the executing tool
+type namespaceKey struct{}
+
+// RegisterNamespace registers tools under prefix, so that tools from
+// several sources, e.g. two MCP servers, cannot collide. The model sees
+// "prefix__name"; handlers, executors and Config.ToolExecutor are called
+// with the name stripped and can read the namespace with ToolNamespace.
+// The tools are copied, so the same *Tool can be registered under several
+// namespaces. Prefixes may contain letters, digits, hyphens and single
+// underscores.
+func (a *Agent) RegisterNamespace(prefix string, tools ...*Tool) error {
+	if !validNamespace.MatchString(prefix) {
+		return fmt.Errorf("invalid tool namespace: %q", prefix)
+	}
+
+	namespaced := make([]*Tool, len(tools))
+	for i, tool := range tools {
+		if tool.Name == "" {
+			return fmt.Errorf("tool %d of namespace %s has no name", i, prefix)
+		}
+		name := prefix + NamespaceSeparator + tool.Name
+		if len(name) > maxToolName {
+			return fmt.Errorf("tool name %s is longer than %d characters", name, maxToolName)
+		}
+		copied := *tool
+		copied.Name = name
+		copied.namespace = prefix
+		namespaced[i] = &copied
+	}
+
+	a.RegisterTools(namespaced...)
+	return nil
+}
+
+// ToolNamespace returns the namespace of the tool being executed, "" for
+// tools registered without one. Tool executors call it on the context they
+// receive.
+func ToolNamespace(ctx context.Context) string {
+	namespace, _ := ctx.Value(namespaceKey{}).(string)
+	return namespace
+}
+
+// stripNamespace returns the name of a namespaced tool without its prefix
+// and a context carrying the namespace
+func stripNamespace(ctx context.Context, tool *Tool) (context.Context, string) {
+	if tool == nil || tool.namespace == "" {
+		return ctx, ""
+	}
+	return context.WithValue(ctx, namespaceKey{}, tool.namespace),
+		strings.TrimPrefix(tool.Name, tool.namespace+NamespaceSeparator)
+}
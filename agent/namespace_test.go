@@ -0,0 +1,126 @@
+package agent_test
+
+import (
+	"context"
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/trogui/go-agent-sdk/agent"
+	"github.com/trogui/go-agent-sdk/agent/agenttest"
+)
+
+func TestRegisterNamespace(t *testing.T) {
+	type call struct{ name, namespace string }
+	var calls []call
+	e := agenttest.NewEval(t, agent.Config{
+		ToolExecutor: func(ctx context.Context, name string, args json.RawMessage) (any, error) {
+			calls = append(calls, call{name, agent.ToolNamespace(ctx)})
+			return agent.ToolNamespace(ctx) + ":" + name, nil
+		},
+	},
+		agenttest.Response{ToolCalls: []agenttest.ToolCall{
+			{Name: "github__search", Arguments: `{}`},
+			{Name: "jira__search", Arguments: `{}`},
+			{Name: "search", Arguments: `{}`},
+		}},
+		agenttest.Response{Content: "done"},
+	)
+	// The same tool under two namespaces and without one
+	search := &agent.Tool{Name: "search", Description: "Search"}
+	if err := e.Agent.RegisterNamespace("github", search); err != nil {
+		t.Fatal(err)
+	}
+	if err := e.Agent.RegisterNamespace("jira", search); err != nil {
+		t.Fatal(err)
+	}
+	e.Agent.RegisterTool(search)
+
+	resp := e.Run("search everywhere").AssertNoError().Response
+
+	var sent []string
+	for _, raw := range e.Provider.Requests()[0].Tools {
+		var tool struct {
+			Function struct{ Name string } `json:"function"`
+		}
+		if err := json.Unmarshal(raw, &tool); err != nil {
+			t.Fatal(err)
+		}
+		sent = append(sent, tool.Function.Name)
+	}
+	if strings.Join(sent, ",") != "github__search,jira__search,search" {
+		t.Errorf("tools sent = %v, want the prefixed names and the plain one", sent)
+	}
+
+	want := []call{{"search", "github"}, {"search", "jira"}, {"search", ""}}
+	if len(calls) != len(want) {
+		t.Fatalf("executor calls = %+v, want %+v", calls, want)
+	}
+	for i := range want {
+		if calls[i] != want[i] {
+			t.Errorf("executor call %d = %+v, want %+v", i, calls[i], want[i])
+		}
+	}
+	// Records keep the name the model used
+	for i, name := range []string{"github__search", "jira__search", "search"} {
+		if got := resp.ToolCalls[i].Name; got != name {
+			t.Errorf("ToolCalls[%d].Name = %s, want %s", i, got, name)
+		}
+	}
+	if search.Name != "search" {
+		t.Errorf("RegisterNamespace renamed the tool it was given to %s", search.Name)
+	}
+}
+
+func TestRegisterNamespaceHandler(t *testing.T) {
+	var namespace string
+	e := agenttest.NewEval(t, agent.Config{},
+		agenttest.Response{ToolCalls: []agenttest.ToolCall{{Name: "fs__read", Arguments: `{}`}}},
+		agenttest.Response{Content: "done"},
+	)
+	err := e.Agent.RegisterNamespace("fs", &agent.Tool{
+		Name: "read",
+		Executor: agent.ToolExecutorFunc(func(ctx context.Context, args json.RawMessage) (any, error) {
+			namespace = agent.ToolNamespace(ctx)
+			return "contents", nil
+		}),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	e.Run("read it").AssertNoError().AssertToolCalled("fs__read", nil)
+	if namespace != "fs" {
+		t.Errorf("ToolNamespace() = %q, want fs", namespace)
+	}
+}
+
+func TestRegisterNamespaceInvalid(t *testing.T) {
+	a, err := agent.New(agent.Config{
+		APIKey:       "test",
+		APIURL:       "http://example.invalid/v1/chat/completions",
+		Model:        "test-model",
+		SystemPrompt: "You are a helpful assistant.",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	tool := &agent.Tool{Name: "read"}
+
+	for _, prefix := range []string{"", "a__b", "fs_", "_fs", "f.s", "f s"} {
+		if err := a.RegisterNamespace(prefix, tool); err == nil {
+			t.Errorf("RegisterNamespace(%q) succeeded, want an error", prefix)
+		}
+	}
+	if err := a.RegisterNamespace("fs", &agent.Tool{}); err == nil {
+		t.Error("RegisterNamespace accepted a tool without a name")
+	}
+	if err := a.RegisterNamespace("fs", &agent.Tool{Name: strings.Repeat("x", 61)}); err == nil {
+		t.Error("RegisterNamespace accepted a name longer than 64 characters")
+	}
+	for _, prefix := range []string{"fs", "my-fs", "my_fs", "fs2"} {
+		if err := a.RegisterNamespace(prefix, tool); err != nil {
+			t.Errorf("RegisterNamespace(%q) = %v", prefix, err)
+		}
+	}
+}
@@ -0,0 +1,69 @@
+package agent
+
+import "golang.org/x/text/language"
+
+// RunOption customizes a single Run or, when passed to NewSession, every turn
+// of the session
+type RunOption func(*runOptions)
+
+// runOptions holds the values set by RunOptions
+type runOptions struct {
+	metadata map[string]string
+	traceID  string
+
+	skipToolExecution bool
+	eventReplaySize   int
+	locale            language.Tag
+}
+
+// WithRequestMetadata attaches metadata to every API request, sent in the
+// "metadata" request field. A "user" key also sets the "user" field,
+// overriding Config.User.
+func WithRequestMetadata(metadata map[string]string) RunOption {
+	return func(o *runOptions) {
+		if o.metadata == nil {
+			o.metadata = make(map[string]string, len(metadata))
+		}
+		for k, v := range metadata {
+			o.metadata[k] = v
+		}
+	}
+}
+
+// WithTraceID sends a correlation ID with every API request in the header
+// named by Config.TraceHeader
+func WithTraceID(id string) RunOption {
+	return func(o *runOptions) {
+		o.traceID = id
+	}
+}
+
+// WithoutToolExecution sends the tools but returns on the first tool_calls
+// response instead of executing them. The Response has FinishReason
+// "tool_calls" and lists the requested calls in ToolCalls, without results.
+// Meant for evaluations with Run, whose Response.Messages end with the
+// tool_calls message. A session turn ended this way keeps only the user
+// message in the history, so the next turn is still a valid request.
+func WithoutToolExecution() RunOption {
+	return func(o *runOptions) {
+		o.skipToolExecution = true
+	}
+}
+
+// WithEventReplay keeps the last size events of a session so that
+// Session.EventsFrom can replay them to late subscribers. It only applies
+// to NewSession.
+func WithEventReplay(size int) RunOption {
+	return func(o *runOptions) {
+		o.eventReplaySize = size
+	}
+}
+
+// newRunOptions applies opts to an empty runOptions
+func newRunOptions(opts []RunOption) runOptions {
+	var o runOptions
+	for _, opt := range opts {
+		opt(&o)
+	}
+	return o
+}
@@ -0,0 +1,182 @@
+package agent
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// NextPage is the name of the tool registered once a tool returns a
+// Paginated result
+const NextPage = "next_page"
+
+// PagedResult is a collection returned by a tool one page at a time. Create
+// it with Paginated.
+type PagedResult struct {
+	items    []any
+	pageSize int
+}
+
+// Paginated wraps a large tool result so that only its first page is sent
+// to the model, with the page count and a next_page_token. The full result
+// is kept for the run or session, and the next_page tool, registered on
+// first use, returns the following pages. Pages are dropped at the end of
+// the turn, or after Config.PageTTL when it is set.
+func Paginated(items []any, pageSize int) *PagedResult {
+	if pageSize <= 0 {
+		pageSize = len(items)
+	}
+	return &PagedResult{items: items, pageSize: pageSize}
+}
+
+// totalPages returns the number of pages, at least one
+func (p *PagedResult) totalPages() int {
+	if p.pageSize == 0 {
+		return 1
+	}
+	return max((len(p.items)+p.pageSize-1)/p.pageSize, 1)
+}
+
+// page returns page n, counted from 1, with its metadata. token is the ID
+// under which the result is stored, or empty when it is not.
+func (p *PagedResult) page(n int, token string) map[string]any {
+	start := min((n-1)*p.pageSize, len(p.items))
+	end := min(start+p.pageSize, len(p.items))
+	page := map[string]any{
+		"items":       p.items[start:end],
+		"page":        n,
+		"total_pages": p.totalPages(),
+		"total_items": len(p.items),
+	}
+	if token != "" && n < p.totalPages() {
+		page["next_page_token"] = token + ":" + strconv.Itoa(n+1)
+	}
+	return page
+}
+
+// MarshalJSON encodes the first page without a next_page_token, for
+// results that do not go through the loop
+func (p *PagedResult) MarshalJSON() ([]byte, error) {
+	return json.Marshal(p.page(1, ""))
+}
+
+// pageStore keeps the paginated results of a run or session
+type pageStore struct {
+	mu      sync.Mutex
+	seq     int
+	results map[string]storedPages
+}
+
+// storedPages is a paginated result and when it was stored
+type storedPages struct {
+	result *PagedResult
+	stored time.Time
+}
+
+// pagesKey is the context key of the page store
+type pagesKey struct{}
+
+// withPages returns ctx carrying store for paginated tool results
+func withPages(ctx context.Context, store *pageStore) context.Context {
+	return context.WithValue(ctx, pagesKey{}, store)
+}
+
+// add stores a result and returns its token, dropping results older than ttl
+func (s *pageStore) add(result *PagedResult, now time.Time, ttl time.Duration) string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.results == nil {
+		s.results = make(map[string]storedPages)
+	}
+	s.expire(now, ttl)
+	s.seq++
+	token := fmt.Sprintf("pages-%d", s.seq)
+	s.results[token] = storedPages{result: result, stored: now}
+	return token
+}
+
+// get returns a stored result that has not expired
+func (s *pageStore) get(token string, now time.Time, ttl time.Duration) *PagedResult {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.expire(now, ttl)
+	return s.results[token].result
+}
+
+// expire drops results older than ttl. Without a TTL results live until
+// clear is called at the end of the turn.
+func (s *pageStore) expire(now time.Time, ttl time.Duration) {
+	if ttl <= 0 {
+		return
+	}
+	for token, stored := range s.results {
+		if now.Sub(stored.stored) > ttl {
+			delete(s.results, token)
+		}
+	}
+}
+
+// clear drops every stored result
+func (s *pageStore) clear() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.results = nil
+}
+
+// paginate stores a paginated tool result and returns its first page. The
+// next_page tool is registered the first time.
+func (a *Agent) paginate(ctx context.Context, result *PagedResult) any {
+	store, ok := ctx.Value(pagesKey{}).(*pageStore)
+	if !ok || result.totalPages() == 1 {
+		return result.page(1, "")
+	}
+
+	a.pagingOnce.Do(func() {
+		if a.lookupTool(NextPage) == nil {
+			a.RegisterTool(a.nextPageTool())
+		}
+	})
+
+	token := store.add(result, a.clock.Now(), a.config.PageTTL)
+	return result.page(1, token)
+}
+
+// nextPageTool returns the next_page tool
+func (a *Agent) nextPageTool() *Tool {
+	return &Tool{
+		Name:        NextPage,
+		Description: "Get the next page of a paginated tool result.",
+		Parameters: map[string]Parameter{
+			"next_page_token": {Type: "string", Description: "The next_page_token of the previous page"},
+		},
+		Required: []string{"next_page_token"},
+		Version:  "1",
+		Executor: ToolExecutorFunc(func(ctx context.Context, args json.RawMessage) (any, error) {
+			var payload struct {
+				Token string `json:"next_page_token"`
+			}
+			if err := json.Unmarshal(args, &payload); err != nil {
+				return nil, err
+			}
+
+			token, number, _ := strings.Cut(payload.Token, ":")
+			n, err := strconv.Atoi(number)
+			store, ok := ctx.Value(pagesKey{}).(*pageStore)
+			if err != nil || n < 1 || !ok {
+				return nil, fmt.Errorf("invalid next_page_token: %s", payload.Token)
+			}
+			result := store.get(token, a.clock.Now(), a.config.PageTTL)
+			if result == nil || n > result.totalPages() {
+				return nil, fmt.Errorf("the result has expired, call the original tool again")
+			}
+			return result.page(n, token), nil
+		}),
+	}
+}
@@ -0,0 +1,136 @@
+package agent
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// SessionPool hands out reusable sessions, creating them lazily and never
+// holding more than its size at once
+type SessionPool struct {
+	agent *Agent
+	ctx   context.Context
+	slots chan struct{} // One token per session that may be handed out
+	idle  chan *Session // Released sessions ready for reuse
+
+	mu       sync.Mutex
+	closed   bool
+	acquired map[*Session]bool // Sessions handed out and not yet released
+}
+
+// NewSessionPool creates a pool of at most size sessions on ag, all derived
+// from ctx
+func NewSessionPool(ag *Agent, ctx context.Context, size int) *SessionPool {
+	if size < 1 {
+		size = 1
+	}
+	return &SessionPool{
+		agent:    ag,
+		ctx:      ctx,
+		slots:    make(chan struct{}, size),
+		idle:     make(chan *Session, size),
+		acquired: make(map[*Session]bool),
+	}
+}
+
+// Acquire returns an idle session, or a new one while the pool is below its
+// size. It blocks up to timeout for a session to be released and then
+// returns ErrPoolTimeout.
+func (p *SessionPool) Acquire(timeout time.Duration) (*Session, error) {
+	p.mu.Lock()
+	closed := p.closed
+	p.mu.Unlock()
+	if closed {
+		return nil, ErrPoolClosed
+	}
+
+	select {
+	case p.slots <- struct{}{}:
+	default:
+		select {
+		case p.slots <- struct{}{}:
+		case <-p.agent.clock.After(timeout):
+			return nil, ErrPoolTimeout
+		case <-p.ctx.Done():
+			return nil, p.ctx.Err()
+		}
+	}
+
+	var s *Session
+	select {
+	case s = <-p.idle:
+	default:
+		s = p.agent.NewSession(p.ctx)
+	}
+
+	p.mu.Lock()
+	p.acquired[s] = true
+	p.mu.Unlock()
+	return s, nil
+}
+
+// Release returns a session to the pool with its history cleared. Release it
+// only once its turns have completed. Closed sessions are dropped and
+// replaced by a new one on a later Acquire. Releasing a session that was not
+// acquired from the pool, or releasing it twice, does nothing.
+func (p *SessionPool) Release(s *Session) {
+	p.mu.Lock()
+	if !p.acquired[s] {
+		p.mu.Unlock()
+		p.agent.log().Warn().Msg("[SessionPool] Released a session that was not acquired from the pool")
+		return
+	}
+	delete(p.acquired, s)
+	closed := p.closed
+	p.mu.Unlock()
+
+	defer func() { <-p.slots }()
+
+	if closed || !s.reset() {
+		s.Close()
+		return
+	}
+	p.idle <- s
+}
+
+// Close closes the idle sessions. Sessions still acquired are closed when
+// released.
+func (p *SessionPool) Close() {
+	p.mu.Lock()
+	p.closed = true
+	p.mu.Unlock()
+
+	for {
+		select {
+		case s := <-p.idle:
+			s.Close()
+		default:
+			return
+		}
+	}
+}
+
+// reset clears the history and per-conversation state, keeping only the
+// system prompt. It reports false if the session is closed.
+func (s *Session) reset() bool {
+	prompt := s.agent.systemPrompt(s.ctx, "")
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.closed {
+		return false
+	}
+	s.prompt = prompt
+	s.messages = []ConversationMessage{{Role: "system", Content: localizedPrompt(prompt, s.options.locale), CreatedAt: s.agent.clock.Now()}}
+	s.turns = nil
+	s.attachments.clear()
+	s.pages.clear()
+	s.blocks = nil
+	s.loopCount = 0
+	s.maxLoops = s.agent.config.MaxLoops
+	s.title = ""
+	s.titleLen = 0
+	return true
+}
@@ -0,0 +1,115 @@
+package agent_test
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/trogui/go-agent-sdk/agent"
+	"github.com/trogui/go-agent-sdk/agent/agenttest"
+)
+
+func acquire(t *testing.T, pool *agent.SessionPool) *agent.Session {
+	t.Helper()
+
+	s, err := pool.Acquire(time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return s
+}
+
+func TestSessionPoolReuse(t *testing.T) {
+	e := agenttest.NewEval(t, agent.Config{}, agenttest.Response{Content: "hello"})
+	pool := agent.NewSessionPool(e.Agent, t.Context(), 2)
+	defer pool.Close()
+
+	// Sessions are created on demand while the pool is below its size
+	first := acquire(t, pool)
+	second := acquire(t, pool)
+	if first == second {
+		t.Fatal("two acquired sessions are the same")
+	}
+
+	if err := first.Send("hi"); err != nil {
+		t.Fatal(err)
+	}
+	waitTurn(t, first)
+	pool.Release(first)
+
+	// The released session is reused, with its history cleared
+	if again := acquire(t, pool); again != first {
+		t.Error("Acquire created a session while one was idle")
+	}
+	if history := first.GetHistory(); len(history) != 1 || history[0].(agent.ConversationMessage).Role != "system" {
+		t.Errorf("history after Release = %+v, want the system prompt only", history)
+	}
+	if turns := first.Conversations(); len(turns) != 0 {
+		t.Errorf("released session kept %d turns", len(turns))
+	}
+}
+
+func TestSessionPoolSize(t *testing.T) {
+	e := agenttest.NewEval(t, agent.Config{})
+	pool := agent.NewSessionPool(e.Agent, t.Context(), 1)
+	defer pool.Close()
+
+	s := acquire(t, pool)
+	if _, err := pool.Acquire(20 * time.Millisecond); !errors.Is(err, agent.ErrPoolTimeout) {
+		t.Fatalf("Acquire() on a full pool = %v, want ErrPoolTimeout", err)
+	}
+
+	// A session released while waiting is handed over
+	go func() {
+		time.Sleep(20 * time.Millisecond)
+		pool.Release(s)
+	}()
+	if got := acquire(t, pool); got != s {
+		t.Error("Acquire did not get the released session")
+	}
+}
+
+func TestSessionPoolReleaseTwice(t *testing.T) {
+	e := agenttest.NewEval(t, agent.Config{})
+	pool := agent.NewSessionPool(e.Agent, t.Context(), 1)
+	defer pool.Close()
+
+	s := acquire(t, pool)
+	pool.Release(s)
+	pool.Release(s)
+	foreign := e.Agent.NewSession(t.Context())
+	defer foreign.Close()
+	pool.Release(foreign)
+
+	// Neither release freed a slot it did not hold
+	acquire(t, pool)
+	if _, err := pool.Acquire(20 * time.Millisecond); !errors.Is(err, agent.ErrPoolTimeout) {
+		t.Errorf("Acquire() = %v, want ErrPoolTimeout: the pool went over its size", err)
+	}
+}
+
+func TestSessionPoolClose(t *testing.T) {
+	e := agenttest.NewEval(t, agent.Config{})
+	pool := agent.NewSessionPool(e.Agent, t.Context(), 2)
+
+	idle := acquire(t, pool)
+	busy := acquire(t, pool)
+	pool.Release(idle)
+	pool.Close()
+
+	if _, ok := <-idle.Events(); ok {
+		t.Error("Close left an idle session open")
+	}
+	if _, err := pool.Acquire(time.Second); !errors.Is(err, agent.ErrPoolClosed) {
+		t.Errorf("Acquire() after Close = %v, want ErrPoolClosed", err)
+	}
+	select {
+	case <-busy.Events():
+		t.Error("Close closed a session still acquired")
+	default:
+	}
+	pool.Release(busy)
+	if err := busy.Send("hi"); err == nil {
+		t.Error("a session released after Close is still open")
+	}
+}
@@ -0,0 +1,51 @@
+package agent
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"time"
+)
+
+// ProviderAdapter hooks provider-specific behavior into every API call
+type ProviderAdapter interface {
+	// BeforeRequest runs before each request is sent. It may block, e.g. to
+	// back off until a rate limit window resets.
+	BeforeRequest(ctx context.Context, req *http.Request) error
+	// AfterResponse inspects each response before its body is read. It
+	// returns a non-nil status when the rate limit is close to exhaustion.
+	AfterResponse(resp *http.Response) *RateLimitStatus
+}
+
+// holdBacker is implemented by the built-in adapters, whose BeforeRequest
+// may wait: holdBack returns that wait and why, so that the agent logs it
+// through Config.Logger
+type holdBacker interface {
+	holdBack() (time.Duration, string)
+}
+
+// RateLimitStatus is the Data of EventRateLimitApproaching
+type RateLimitStatus struct {
+	Remaining int           // Requests left in the current window
+	ResetIn   time.Duration // Time until the window resets
+}
+
+// Providers understood by Config.Provider
+const (
+	ProviderGroq = "groq"
+	ProviderXAI  = "xai"
+)
+
+// newProviderAdapter returns the built-in adapter for a provider name
+func newProviderAdapter(provider string) (ProviderAdapter, error) {
+	switch provider {
+	case "":
+		return nil, nil
+	case ProviderGroq:
+		return NewGroqAdapter(), nil
+	case ProviderXAI:
+		return NewXAIAdapter(), nil
+	default:
+		return nil, fmt.Errorf("unknown provider: %s", provider)
+	}
+}
@@ -0,0 +1,121 @@
+package agent
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"strings"
+)
+
+// normalizeToolCalls works around provider quirks in tool calls so the loop
+// and the echoed-back history stay valid:
+//
+//   - Groq sometimes double-encodes arguments, sending a JSON string that
+//     contains the JSON object; the string is unwrapped.
+//   - DeepSeek can send empty call IDs; a stable ID is synthesized and
+//     reused by the tool response, as the history keeps the call.
+//   - A missing call type defaults to "function".
+func (a *Agent) normalizeToolCalls(resp *apiResponse) {
+	for i := range resp.Choices {
+		calls := resp.Choices[i].Message.ToolCalls
+		for j := range calls {
+			call := &calls[j]
+			if call.ID == "" {
+				call.ID = fmt.Sprintf("call_%d", a.callSeq.Add(1))
+			}
+			if call.Type == "" {
+				call.Type = "function"
+			}
+			call.Function.Arguments = unwrapArguments(call.Function.Arguments)
+		}
+	}
+}
+
+// unwrapArguments decodes arguments that were encoded as a JSON string
+// holding a JSON object or array. Other arguments are returned unchanged.
+func unwrapArguments(arguments string) string {
+	// Bounded: a provider has not been seen to encode more than twice
+	for range 2 {
+		trimmed := bytes.TrimSpace([]byte(arguments))
+		if len(trimmed) == 0 || trimmed[0] != '"' {
+			return arguments
+		}
+
+		var inner string
+		if err := json.Unmarshal(trimmed, &inner); err != nil {
+			return arguments
+		}
+		innerTrimmed := bytes.TrimSpace([]byte(inner))
+		if len(innerTrimmed) == 0 || (innerTrimmed[0] != '{' && innerTrimmed[0] != '[') || !json.Valid(innerTrimmed) {
+			return arguments
+		}
+		arguments = inner
+	}
+	return arguments
+}
+
+// FinishReasonMapper may be implemented by a ProviderAdapter whose provider
+// reports finish reasons the built-in normalization does not know. The
+// result is normalized again, so it may use any value the SDK understands.
+type FinishReasonMapper interface {
+	MapFinishReason(reason string) string
+}
+
+// finishReasons maps the finish reasons of non-OpenAI providers and
+// gateways to the canonical "stop", "tool_calls", "length" and
+// "content_filter". Keys are lower-case.
+var finishReasons = map[string]string{
+	"end_turn":           "stop", // Anthropic
+	"stop_sequence":      "stop",
+	"complete":           "stop", // Cohere
+	"eos":                "stop",
+	"eos_token":          "stop",
+	"tool_use":           "tool_calls",
+	"function_call":      "tool_calls",
+	"max_tokens":         "length", // Anthropic, Gemini MAX_TOKENS
+	"content_filtered":   "content_filter",
+	"safety":             "content_filter", // Gemini
+	"recitation":         "content_filter",
+	"prohibited_content": "content_filter",
+	"refusal":            "content_filter",
+}
+
+// stopReason returns the stop_reason of a choice when it is a string, ""
+// otherwise, e.g. for the token IDs and nulls sent by vLLM
+func stopReason(raw json.RawMessage) string {
+	var reason string
+	if json.Unmarshal(raw, &reason) != nil {
+		return ""
+	}
+	return reason
+}
+
+// normalizeFinishReasons rewrites the finish reason of each choice to the
+// canonical set the loop compares against. Providers that send stop_reason
+// instead of finish_reason are covered too; without this the loop would not
+// recognize the final answer and run until MaxLoops.
+func (a *Agent) normalizeFinishReasons(resp *apiResponse) {
+	mapper, _ := a.config.Adapter.(FinishReasonMapper)
+	for i := range resp.Choices {
+		choice := &resp.Choices[i]
+		reason := choice.FinishReason
+		if reason == "" {
+			reason = stopReason(choice.StopReason)
+		}
+		if mapper != nil {
+			reason = mapper.MapFinishReason(reason)
+		}
+		reason = strings.ToLower(strings.TrimSpace(reason))
+		if canonical, ok := finishReasons[reason]; ok {
+			reason = canonical
+		}
+		if reason != choice.FinishReason {
+			a.log().Debug().
+				Str("finish_reason", choice.FinishReason).
+				Str("stop_reason", string(choice.StopReason)).
+				Str("normalized", reason).
+				Msg("[Agent] Finish reason normalized")
+		}
+		choice.FinishReason = reason
+	}
+}
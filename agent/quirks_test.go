@@ -0,0 +1,158 @@
+package agent
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+)
+
+// testAgent returns an agent with a placeholder configuration, for tests
+// that never reach the API
+func testAgent(t *testing.T, config Config) *Agent {
+	t.Helper()
+
+	config.APIKey = "test"
+	config.APIURL = "http://localhost/v1/chat/completions"
+	config.Model = "test-model"
+	config.SystemPrompt = "You are a test assistant."
+	a, err := New(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return a
+}
+
+// decodeResponse decodes a response fixture
+func decodeResponse(t *testing.T, body string) apiResponse {
+	t.Helper()
+
+	var resp apiResponse
+	if err := json.Unmarshal([]byte(body), &resp); err != nil {
+		t.Fatalf("decoding fixture: %v", err)
+	}
+	return resp
+}
+
+func TestNormalizeToolCalls(t *testing.T) {
+	tests := []struct {
+		name string
+		body string
+		want ToolCall
+	}{
+		{
+			name: "groq double-encoded arguments",
+			body: `{"id":"chatcmpl-1","choices":[{"index":0,"message":{"role":"assistant","tool_calls":[{"id":"call_abc","type":"function","function":{"name":"get_weather","arguments":"\"{\\\"city\\\":\\\"Tokyo\\\"}\""}}]},"finish_reason":"tool_calls"}]}`,
+			want: ToolCall{ID: "call_abc", Type: "function", Function: FunctionCall{Name: "get_weather", Arguments: `{"city":"Tokyo"}`}},
+		},
+		{
+			name: "deepseek empty call id and type",
+			body: `{"id":"chatcmpl-2","choices":[{"index":0,"message":{"role":"assistant","content":"","tool_calls":[{"id":"","function":{"name":"get_weather","arguments":"{\"city\":\"Paris\"}"}}]},"finish_reason":"tool_calls"}]}`,
+			want: ToolCall{ID: "call_1", Type: "function", Function: FunctionCall{Name: "get_weather", Arguments: `{"city":"Paris"}`}},
+		},
+		{
+			name: "string argument left alone",
+			body: `{"id":"chatcmpl-3","choices":[{"index":0,"message":{"role":"assistant","tool_calls":[{"id":"call_x","type":"function","function":{"name":"say","arguments":"\"hello\""}}]},"finish_reason":"tool_calls"}]}`,
+			want: ToolCall{ID: "call_x", Type: "function", Function: FunctionCall{Name: "say", Arguments: `"hello"`}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			a := testAgent(t, Config{})
+			resp := decodeResponse(t, tt.body)
+			a.normalizeToolCalls(&resp)
+
+			if got := resp.Choices[0].Message.ToolCalls[0]; got != tt.want {
+				t.Errorf("tool call = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestNormalizeToolCallsSynthesizesUniqueIDs(t *testing.T) {
+	a := testAgent(t, Config{})
+	body := `{"choices":[{"message":{"role":"assistant","tool_calls":[{"function":{"name":"a","arguments":"{}"}},{"function":{"name":"b","arguments":"{}"}}]},"finish_reason":"tool_calls"}]}`
+	first, second := decodeResponse(t, body), decodeResponse(t, body)
+	a.normalizeToolCalls(&first)
+	a.normalizeToolCalls(&second)
+
+	seen := make(map[string]bool)
+	for _, resp := range []apiResponse{first, second} {
+		for _, call := range resp.Choices[0].Message.ToolCalls {
+			if call.ID == "" || seen[call.ID] {
+				t.Errorf("call ID %q is empty or reused", call.ID)
+			}
+			seen[call.ID] = true
+		}
+	}
+}
+
+// reasonMapper is a ProviderAdapter mapping a custom finish reason
+type reasonMapper struct{}
+
+func (reasonMapper) BeforeRequest(ctx context.Context, req *http.Request) error { return nil }
+func (reasonMapper) AfterResponse(resp *http.Response) *RateLimitStatus         { return nil }
+func (reasonMapper) MapFinishReason(reason string) string {
+	if reason == "DONE_TALKING" {
+		return "end_turn"
+	}
+	return reason
+}
+
+func TestNormalizeFinishReasons(t *testing.T) {
+	tests := []struct {
+		name   string
+		choice string
+		mapper bool
+		want   string
+	}{
+		{"canonical", `{"finish_reason":"tool_calls"}`, false, "tool_calls"},
+		{"anthropic", `{"finish_reason":"end_turn"}`, false, "stop"},
+		{"upper case", `{"finish_reason":" MAX_TOKENS "}`, false, "length"},
+		{"unknown kept", `{"finish_reason":"paused"}`, false, "paused"},
+		{"stop_reason string", `{"stop_reason":"end_turn"}`, false, "stop"},
+		{"finish_reason wins", `{"finish_reason":"length","stop_reason":"end_turn"}`, false, "length"},
+		{"stop_reason token ID", `{"finish_reason":"stop","stop_reason":128009}`, false, "stop"},
+		{"stop_reason token ID alone", `{"stop_reason":128009}`, false, ""},
+		{"stop_reason null", `{"finish_reason":"tool_calls","stop_reason":null}`, false, "tool_calls"},
+		{"stop_reason object", `{"stop_reason":{"type":"end_turn"}}`, false, ""},
+		{"mapper", `{"finish_reason":"DONE_TALKING"}`, true, "stop"},
+		{"mapper on stop_reason", `{"stop_reason":"DONE_TALKING"}`, true, "stop"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var config Config
+			if tt.mapper {
+				config.Adapter = reasonMapper{}
+			}
+			a := testAgent(t, config)
+			resp := decodeResponse(t, `{"choices":[`+tt.choice+`]}`)
+
+			a.normalizeFinishReasons(&resp)
+			if got := resp.Choices[0].FinishReason; got != tt.want {
+				t.Errorf("finish reason = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestStreamStopReason(t *testing.T) {
+	a := testAgent(t, Config{})
+	body := "data: {\"choices\":[{\"index\":0,\"delta\":{\"content\":\"hi\"},\"stop_reason\":null}]}\n\n" +
+		"data: {\"choices\":[{\"index\":0,\"delta\":{},\"stop_reason\":128009}]}\n\n" +
+		"data: {\"choices\":[{\"index\":0,\"delta\":{},\"stop_reason\":\"end_turn\"}]}\n\n" +
+		"data: [DONE]\n\n"
+	resp := &http.Response{Body: io.NopCloser(strings.NewReader(body))}
+
+	apiResp, err := a.readStream(context.Background(), resp, time.Now())
+	if err != nil {
+		t.Fatal(err)
+	}
+	a.normalizeFinishReasons(apiResp)
+	if got := apiResp.Choices[0].FinishReason; got != "stop" {
+		t.Errorf("finish reason = %q, want stop", got)
+	}
+}
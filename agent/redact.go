@@ -0,0 +1,66 @@
+package agent
+
+import (
+	"encoding/json"
+	"strings"
+)
+
+// Redacted replaces the values matched by Config.RedactFields
+const Redacted = "[redacted]"
+
+// redact returns data, a JSON document, with the values at
+// Config.RedactFields replaced by Redacted. It is applied to what is logged
+// and emitted only; handlers and the model always see the real values.
+func (a *Agent) redact(data string) string {
+	if len(a.config.RedactFields) == 0 {
+		return data
+	}
+
+	var doc any
+	if err := json.Unmarshal([]byte(data), &doc); err != nil {
+		return data
+	}
+
+	redacted := false
+	for _, path := range a.config.RedactFields {
+		if redactPath(doc, strings.Split(path, ".")) {
+			redacted = true
+		}
+	}
+	if !redacted {
+		return data
+	}
+
+	out, err := json.Marshal(doc)
+	if err != nil {
+		return data
+	}
+	return string(out)
+}
+
+// redactPath replaces the value at path in doc and reports whether it was
+// found. Arrays are traversed, so a path applies to every element.
+func redactPath(doc any, path []string) bool {
+	switch v := doc.(type) {
+	case map[string]any:
+		value, ok := v[path[0]]
+		if !ok {
+			return false
+		}
+		if len(path) == 1 {
+			v[path[0]] = Redacted
+			return true
+		}
+		return redactPath(value, path[1:])
+	case []any:
+		found := false
+		for _, elem := range v {
+			if redactPath(elem, path) {
+				found = true
+			}
+		}
+		return found
+	default:
+		return false
+	}
+}
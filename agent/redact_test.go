@@ -0,0 +1,74 @@
+package agent_test
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/trogui/go-agent-sdk/agent"
+	"github.com/trogui/go-agent-sdk/agent/agenttest"
+)
+
+func TestRedactionIsDisplayOnly(t *testing.T) {
+	args := `{"user":"ana","password":"hunter2"}`
+	e := agenttest.NewEval(t, agent.Config{RedactFields: []string{"password", "accounts.ssn"}},
+		agenttest.Response{ToolCalls: []agenttest.ToolCall{{Name: "login", Arguments: args}}},
+		agenttest.Response{Content: "done"},
+	)
+	var received string
+	e.Agent.RegisterTool(&agent.Tool{
+		Name:        "login",
+		Description: "Logs in",
+		Handler: func(raw json.RawMessage) (any, error) {
+			received = string(raw)
+			return map[string]any{"accounts": []map[string]string{{"id": "1", "ssn": "123-45-6789"}}}, nil
+		},
+	})
+
+	session := e.Agent.NewSession(t.Context())
+	defer session.Close()
+	if err := session.Send("log in"); err != nil {
+		t.Fatal(err)
+	}
+
+	var callData, resultContent string
+	timeout := time.After(10 * time.Second)
+	for done := false; !done; {
+		select {
+		case event := <-session.Events():
+			switch event.Type {
+			case agent.EventToolCall:
+				callData, _ = event.Data.(string)
+			case agent.EventToolResult:
+				resultContent = event.Content
+			case agent.EventTurnComplete:
+				done = true
+			case agent.EventError:
+				t.Fatalf("turn failed: %s", event.Content)
+			}
+		case <-timeout:
+			t.Fatal("timed out waiting for the turn")
+		}
+	}
+
+	// Events are redacted
+	if strings.Contains(callData, "hunter2") || !strings.Contains(callData, agent.Redacted) {
+		t.Errorf("EventToolCall data = %s, want the password redacted", callData)
+	}
+	if strings.Contains(resultContent, "123-45-6789") || !strings.Contains(resultContent, `"id":"1"`) {
+		t.Errorf("EventToolResult content = %s, want only the ssn redacted", resultContent)
+	}
+
+	// The handler, the model and the history see the real values
+	if received != args {
+		t.Errorf("handler received %s, want %s", received, args)
+	}
+	messages := e.Provider.Requests()[1].Messages
+	if result := messages[len(messages)-1].Content; !strings.Contains(result, "123-45-6789") {
+		t.Errorf("model got %s, want the real result", result)
+	}
+	if call := session.Conversations()[0].ToolCalls[0]; call.Arguments != args {
+		t.Errorf("recorded arguments = %s, want %s", call.Arguments, args)
+	}
+}
@@ -0,0 +1,67 @@
+package agent
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+)
+
+// canonicalJSON encodes v with object keys sorted and no insignificant
+// whitespace, so that equal values always produce the same bytes. Numbers
+// keep their original text.
+func canonicalJSON(v any) ([]byte, error) {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+	var doc any
+	if err := decoder.Decode(&doc); err != nil {
+		return nil, err
+	}
+	// Maps are encoded with sorted keys
+	return json.Marshal(doc)
+}
+
+// RequestKey returns a stable hash of the body of the first request that a
+// run or turn over messages would send, e.g. for response caching,
+// deduplication or log correlation. Identical requests share a key. The
+// body is built as the loop builds it: messages trimmed to
+// Config.MaxContextMessages, the model chosen by Config.ModelRouter for
+// iteration 1, the tools and parameters, and the request metadata of opts.
+// Messages the loop adds for a single request are not covered: active
+// context blocks, per-turn reminders, budget notes and messages injected by
+// OnIterationEnd. Every element of messages must be a ConversationMessage,
+// as returned by Session.GetHistory. CreatedAt and transient messages are
+// not sent and do not affect the key.
+func (a *Agent) RequestKey(messages []any, opts ...RunOption) (string, error) {
+	history := make([]ConversationMessage, len(messages))
+	for i, msg := range messages {
+		switch m := msg.(type) {
+		case ConversationMessage:
+			history[i] = m
+		case *ConversationMessage:
+			history[i] = *m
+		default:
+			return "", fmt.Errorf("message %d is a %T, not a ConversationMessage", i, msg)
+		}
+	}
+
+	body, err := canonicalJSON(a.buildRequestBody(apiRequest{
+		messages:  trimMessages(history, a.config.MaxContextMessages),
+		options:   newRunOptions(opts),
+		model:     a.routeModel(history, 1),
+		stream:    a.config.Stream,
+		maxTokens: a.config.MaxTokens,
+	}))
+	if err != nil {
+		return "", fmt.Errorf("error encoding request: %w", err)
+	}
+
+	sum := sha256.Sum256(body)
+	return hex.EncodeToString(sum[:]), nil
+}
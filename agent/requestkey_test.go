@@ -0,0 +1,123 @@
+package agent_test
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/trogui/go-agent-sdk/agent"
+	"github.com/trogui/go-agent-sdk/agent/agenttest"
+)
+
+// canonicalKey hashes a request body the way RequestKey does
+func canonicalKey(t *testing.T, body []byte) string {
+	t.Helper()
+
+	decoder := json.NewDecoder(bytes.NewReader(body))
+	decoder.UseNumber()
+	var doc any
+	if err := decoder.Decode(&doc); err != nil {
+		t.Fatal(err)
+	}
+	data, err := json.Marshal(doc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+func requestKey(t *testing.T, a *agent.Agent, messages []any, opts ...agent.RunOption) string {
+	t.Helper()
+
+	key, err := a.RequestKey(messages, opts...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return key
+}
+
+func TestRequestKey(t *testing.T) {
+	e := agenttest.NewEval(t, agent.Config{})
+	e.Agent.RegisterTools(echoTool("b"), echoTool("a"))
+	other := agenttest.NewEval(t, agent.Config{})
+	other.Agent.RegisterTools(echoTool("a"), echoTool("b"))
+
+	messages := []any{
+		agent.ConversationMessage{Role: "system", Content: "You are a helpful assistant."},
+		agent.ConversationMessage{Role: "user", Content: "hi"},
+	}
+	key := requestKey(t, e.Agent, messages)
+
+	same := []any{
+		&agent.ConversationMessage{Role: "system", Content: "You are a helpful assistant.", CreatedAt: time.Now()},
+		agent.ConversationMessage{Role: "assistant", Content: "A UI notice", Transient: true},
+		agent.ConversationMessage{Role: "user", Content: "hi", CreatedAt: time.Now()},
+	}
+	if got := requestKey(t, e.Agent, same); got != key {
+		t.Error("CreatedAt or a transient message changed the key")
+	}
+	if got := requestKey(t, other.Agent, messages); got != key {
+		t.Error("tool registration order changed the key")
+	}
+
+	different := map[string][]any{
+		"content": {messages[0], agent.ConversationMessage{Role: "user", Content: "hello"}},
+		"role":    {messages[0], agent.ConversationMessage{Role: "assistant", Content: "hi"}},
+		"length":  {messages[1]},
+	}
+	for name, msgs := range different {
+		if got := requestKey(t, e.Agent, msgs); got == key {
+			t.Errorf("messages differing in %s share a key", name)
+		}
+	}
+	if got := requestKey(t, e.Agent, messages, agent.WithRequestMetadata(map[string]string{"user": "u1"})); got == key {
+		t.Error("request metadata does not change the key")
+	}
+	if got := requestKey(t, agenttest.NewEval(t, agent.Config{Model: "other-model"}).Agent, messages); got == key {
+		t.Error("the model does not change the key")
+	}
+
+	if _, err := e.Agent.RequestKey([]any{"hi"}); err == nil {
+		t.Error("RequestKey accepted a message that is not a ConversationMessage")
+	}
+}
+
+// TestRequestKeyMatchesRequest checks the key against the body actually
+// sent, with trimming and model routing in play
+func TestRequestKeyMatchesRequest(t *testing.T) {
+	e := agenttest.NewEval(t, agent.Config{
+		MaxContextMessages: 3,
+		ModelRouter: func(messages []agent.ConversationMessage, iteration int) string {
+			if len(messages) > 4 {
+				return "large-model"
+			}
+			return ""
+		},
+	}, agenttest.Response{Content: "one"}, agenttest.Response{Content: "two"}, agenttest.Response{Content: "three"})
+	e.Agent.RegisterTool(echoTool("echo"))
+
+	metadata := agent.WithRequestMetadata(map[string]string{"tenant": "acme"})
+	session := e.Agent.NewSession(t.Context(), metadata)
+	defer session.Close()
+	for i, prompt := range []string{"first", "second", "third"} {
+		history := append(session.GetHistory(), agent.ConversationMessage{Role: "user", Content: prompt})
+		key := requestKey(t, e.Agent, history, metadata)
+
+		if err := session.Send(prompt); err != nil {
+			t.Fatal(err)
+		}
+		waitTurn(t, session)
+
+		req := e.Provider.Requests()[i]
+		if got := canonicalKey(t, req.Body); got != key {
+			t.Errorf("turn %d: RequestKey does not match the request sent:\n%s", i+1, req.Body)
+		}
+	}
+	if got := e.Provider.Requests()[2].Model; got != "large-model" {
+		t.Errorf("third request model = %s, want the routed one", got)
+	}
+}
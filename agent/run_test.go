@@ -0,0 +1,248 @@
+package agent_test
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/trogui/go-agent-sdk/agent"
+	"github.com/trogui/go-agent-sdk/agent/agenttest"
+)
+
+func TestUsageAvailable(t *testing.T) {
+	usage := &agent.Usage{PromptTokens: 10, CompletionTokens: 5, TotalTokens: 15}
+	calls := []agenttest.ToolCall{{Name: "echo"}}
+
+	tests := []struct {
+		name      string
+		responses []agenttest.Response
+		want      bool
+		wantTotal int
+	}{
+		{
+			name:      "every response reports usage",
+			responses: []agenttest.Response{{ToolCalls: calls, Usage: usage}, {Content: "done", Usage: usage}},
+			want:      true,
+			wantTotal: 30,
+		},
+		{
+			name:      "one response omits usage",
+			responses: []agenttest.Response{{ToolCalls: calls, Usage: usage}, {Content: "done"}},
+			want:      false,
+			wantTotal: 15,
+		},
+		{
+			name:      "no response reports usage",
+			responses: []agenttest.Response{{Content: "done"}},
+			want:      false,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			e := agenttest.NewEval(t, agent.Config{}, tt.responses...)
+			e.Agent.RegisterTool(echoTool("echo"))
+			r := e.Run("hi").AssertNoError()
+
+			if r.Response.UsageAvailable != tt.want {
+				t.Errorf("UsageAvailable = %v, want %v", r.Response.UsageAvailable, tt.want)
+			}
+			if r.Response.Usage.TotalTokens != tt.wantTotal {
+				t.Errorf("Usage.TotalTokens = %d, want %d", r.Response.Usage.TotalTokens, tt.wantTotal)
+			}
+		})
+	}
+}
+
+func TestEmptyFinalContent(t *testing.T) {
+	empty := agenttest.Response{Content: "  \n"}
+	answer := agenttest.Response{Content: "The answer."}
+
+	tests := []struct {
+		name      string
+		config    agent.Config
+		responses []agenttest.Response
+		content   string
+		empty     bool
+		wantErr   bool
+	}{
+		{"flagged", agent.Config{}, []agenttest.Response{empty}, "", true, false},
+		{"reprompted", agent.Config{RepromptOnEmpty: true}, []agenttest.Response{empty, answer}, "The answer.", false, false},
+		{"still empty after reprompt", agent.Config{RepromptOnEmpty: true}, []agenttest.Response{empty, empty}, "", true, false},
+		{"rejected", agent.Config{RejectEmptyCompletion: true}, []agenttest.Response{empty, empty}, "", true, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			e := agenttest.NewEval(t, tt.config, tt.responses...)
+			r := e.Run("hi")
+			if tt.wantErr {
+				var emptyErr *agent.EmptyCompletionError
+				if !errors.As(r.Err, &emptyErr) || !errors.Is(r.Err, agent.ErrEmptyCompletion) {
+					t.Fatalf("error = %v, want an *EmptyCompletionError", r.Err)
+				}
+			} else {
+				r.AssertNoError()
+			}
+			if e.Provider.Remaining() != 0 {
+				t.Errorf("%d responses unused", e.Provider.Remaining())
+			}
+			if r.Response == nil {
+				t.Fatal("no Response")
+			}
+			if strings.TrimSpace(r.Response.Content) != tt.content || r.Response.EmptyContent != tt.empty {
+				t.Errorf("Content = %q, EmptyContent = %v, want %q, %v", r.Response.Content, r.Response.EmptyContent, tt.content, tt.empty)
+			}
+
+			// The reprompt goes with the retry only
+			for _, msg := range r.Response.Messages {
+				if msg.Role == "user" && msg.Content != "hi" {
+					t.Errorf("history has the reprompt %q", msg.Content)
+				}
+			}
+			if requests := e.Provider.Requests(); len(requests) == 2 {
+				retry := requests[1].Messages
+				if last := retry[len(retry)-1]; last.Role != "user" || last.Content == "hi" {
+					t.Errorf("retry ends with %+v, want the reprompt", last)
+				}
+			}
+		})
+	}
+}
+
+func TestInjectedMessagesSurviveIgnoredAPIError(t *testing.T) {
+	note := agent.ConversationMessage{Role: "system", Content: "Prefer the cached result."}
+	e := agenttest.NewEval(t, agent.Config{
+		OnIterationEnd: func(ctx context.Context, iteration agent.IterationResult) []agent.ConversationMessage {
+			return []agent.ConversationMessage{note}
+		},
+		OnError: func(ctx context.Context, err error, phase string) error {
+			return nil
+		},
+		PerTurnReminder: func(ctx context.Context, s *agent.Session) string {
+			return "reminder"
+		},
+	},
+		agenttest.Response{ToolCalls: []agenttest.ToolCall{{Name: "echo"}}},
+		agenttest.Response{Status: 400, Content: `{"error":{"message":"bad request"}}`},
+		agenttest.Response{Content: "done"},
+	)
+	e.Agent.RegisterTool(echoTool("echo"))
+	e.Run("hi").AssertNoError().AssertContent("done")
+
+	requests := e.Provider.Requests()
+	if len(requests) != 3 {
+		t.Fatalf("%d requests, want 3", len(requests))
+	}
+	for i, req := range requests[1:] {
+		notes, reminders := 0, 0
+		for _, msg := range req.Messages {
+			switch msg.Content {
+			case note.Content:
+				notes++
+			case "reminder":
+				reminders++
+			}
+		}
+		if notes != 1 || reminders != 1 {
+			t.Errorf("request %d carries the injected message %d times and the reminder %d times, want once each", i+2, notes, reminders)
+		}
+	}
+}
+
+func TestNegativeMaxInjectedMessages(t *testing.T) {
+	_, err := agent.New(agent.Config{
+		APIKey:              "test",
+		APIURL:              "http://localhost/v1/chat/completions",
+		Model:               "test-model",
+		SystemPrompt:        "You are a test assistant.",
+		MaxInjectedMessages: -1,
+	})
+	if err == nil {
+		t.Fatal("New() accepted a negative MaxInjectedMessages")
+	}
+}
+
+func TestEmptyAPIResponse(t *testing.T) {
+	tests := []struct {
+		name    string
+		body    string
+		message string
+	}{
+		{"no choices", `{"id":"chatcmpl-1","choices":[]}`, ""},
+		{"choices missing", `{"id":"chatcmpl-1"}`, ""},
+		{"error body", `{"error":{"message":"upstream overloaded"}}`, "upstream overloaded"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			a, _ := newRawAgent(t, agent.Config{}, tt.body)
+			_, err := a.Run("hi")
+			if !errors.Is(err, agent.ErrEmptyAPIResponse) {
+				t.Fatalf("error = %v, want ErrEmptyAPIResponse", err)
+			}
+			if !strings.Contains(err.Error(), tt.message) {
+				t.Errorf("error = %v, want it to contain %q", err, tt.message)
+			}
+		})
+	}
+}
+
+func TestMaxLoopsExceeded(t *testing.T) {
+	e := agenttest.NewEval(t, agent.Config{MaxLoops: 3})
+	e.Provider.Repeat(agenttest.Response{ToolCalls: []agenttest.ToolCall{{Name: "echo", Arguments: `{"text":"again"}`}}})
+	e.Agent.RegisterTool(echoTool("echo"))
+
+	r := e.Run("loop forever").AssertErrorIs(agent.ErrMaxLoopsExceeded)
+	if r.Response == nil {
+		t.Fatal("Response is nil, want the partial run")
+	}
+	if r.Response.LoopCount != 3 || len(r.Response.ToolCalls) != 3 {
+		t.Errorf("LoopCount = %d with %d tool calls, want 3 and 3", r.Response.LoopCount, len(r.Response.ToolCalls))
+	}
+	if n := len(e.Provider.Requests()); n != 3 {
+		t.Errorf("%d requests, want 3", n)
+	}
+	ids := make(map[string]bool)
+	for _, call := range r.Response.ToolCalls {
+		ids[call.ID] = true
+	}
+	if len(ids) != 3 {
+		t.Errorf("repeated tool calls share IDs: %v", ids)
+	}
+}
+
+func TestEmptyToolCalls(t *testing.T) {
+	empty := agenttest.Response{FinishReason: "tool_calls"}
+
+	t.Run("text is the answer", func(t *testing.T) {
+		e := agenttest.NewEval(t, agent.Config{},
+			agenttest.Response{Content: "The answer.", FinishReason: "tool_calls"},
+		)
+		e.Run("hi").AssertNoError().AssertContent("The answer.")
+		if got := len(e.Provider.Requests()); got != 1 {
+			t.Errorf("provider got %d requests, want 1", got)
+		}
+	})
+
+	t.Run("retried", func(t *testing.T) {
+		e := agenttest.NewEval(t, agent.Config{},
+			empty,
+			agenttest.Response{Content: "The answer."},
+		)
+		e.Run("hi").AssertNoError().AssertContent("The answer.")
+		requests := e.Provider.Requests()
+		if len(requests) != 2 {
+			t.Fatalf("provider got %d requests, want a retry", len(requests))
+		}
+		if got, want := len(requests[1].Messages), len(requests[0].Messages); got != want {
+			t.Errorf("retry sends %d messages, want the same %d as the first call", got, want)
+		}
+	})
+
+	t.Run("empty twice", func(t *testing.T) {
+		e := agenttest.NewEval(t, agent.Config{MaxLoops: 10}, empty, empty, agenttest.Response{Content: "unused"})
+		e.Run("hi").AssertErrorIs(agent.ErrEmptyToolCalls)
+		if got := len(e.Provider.Requests()); got != 2 {
+			t.Errorf("provider got %d requests, want 2", got)
+		}
+	})
+}
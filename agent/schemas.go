@@ -0,0 +1,116 @@
+package agent
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// toolDefinition is a tool declared in a schema file
+type toolDefinition struct {
+	Name        string                         `yaml:"name"`
+	Description string                         `yaml:"description"`
+	Parameters  map[string]parameterDefinition `yaml:"parameters"`
+	Required    []string                       `yaml:"required"`
+	Version     string                         `yaml:"version"`
+}
+
+// parameterDefinition is a tool parameter declared in a schema file
+type parameterDefinition struct {
+	Type        string `yaml:"type"`
+	Description string `yaml:"description"`
+	Items       *struct {
+		Type string `yaml:"type"`
+	} `yaml:"items"`
+}
+
+// LoadToolSchemas parses a JSON or YAML list of tool definitions with name,
+// description, parameters, required and version fields. The returned tools
+// have no handler; register them and attach behavior with BindHandler.
+func LoadToolSchemas(r io.Reader) ([]*Tool, error) {
+	var definitions []toolDefinition
+	// YAML is a superset of JSON, so one decoder reads both
+	if err := yaml.NewDecoder(r).Decode(&definitions); err != nil && err != io.EOF {
+		return nil, fmt.Errorf("error parsing tool schemas: %w", err)
+	}
+
+	tools := make([]*Tool, 0, len(definitions))
+	seen := make(map[string]bool, len(definitions))
+	for i, def := range definitions {
+		if def.Name == "" {
+			return nil, fmt.Errorf("tool schema %d has no name", i+1)
+		}
+		if seen[def.Name] {
+			return nil, fmt.Errorf("duplicate tool schema: %s", def.Name)
+		}
+		seen[def.Name] = true
+
+		tool := &Tool{
+			Name:        def.Name,
+			Description: def.Description,
+			Parameters:  make(map[string]Parameter, len(def.Parameters)),
+			Required:    def.Required,
+			Version:     def.Version,
+		}
+		for name, param := range def.Parameters {
+			p := Parameter{Type: param.Type, Description: param.Description}
+			if param.Items != nil {
+				p.Items = &Items{Type: param.Items.Type}
+			}
+			tool.Parameters[name] = p
+		}
+		for _, name := range def.Required {
+			if _, ok := def.Parameters[name]; !ok {
+				return nil, fmt.Errorf("tool %s requires undeclared parameter %s", def.Name, name)
+			}
+		}
+		tools = append(tools, tool)
+	}
+
+	return tools, nil
+}
+
+// BindHandler attaches a handler to a registered tool, typically one loaded
+// with LoadToolSchemas
+func (a *Agent) BindHandler(name string, handler ToolHandler) error {
+	a.toolsMu.Lock()
+	defer a.toolsMu.Unlock()
+
+	tool, ok := a.tools[name]
+	if !ok {
+		return fmt.Errorf("tool not registered: %s", name)
+	}
+
+	// Replace rather than mutate, so running calls keep a consistent tool
+	bound := *tool
+	bound.Handler = handler
+	a.tools[name] = &bound
+	return nil
+}
+
+// checkHandlers returns ErrUnboundTool if a registered tool has neither a
+// Handler nor an Executor, unless Config.ToolExecutor handles every call
+func (a *Agent) checkHandlers() error {
+	if a.config.ToolExecutor != nil {
+		return nil
+	}
+
+	a.toolsMu.RLock()
+	defer a.toolsMu.RUnlock()
+
+	var unbound []string
+	for name, tool := range a.tools {
+		if tool.Handler == nil && tool.Executor == nil && tool.StreamHandler == nil {
+			unbound = append(unbound, name)
+		}
+	}
+	if len(unbound) == 0 {
+		return nil
+	}
+
+	sort.Strings(unbound)
+	return fmt.Errorf("%w: %s", ErrUnboundTool, strings.Join(unbound, ", "))
+}
@@ -0,0 +1,457 @@
+package agent_test
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/trogui/go-agent-sdk/agent"
+	"github.com/trogui/go-agent-sdk/agent/agenttest"
+	"golang.org/x/text/language"
+)
+
+func TestSessionCloseWaitsForRunningTurn(t *testing.T) {
+	e := agenttest.NewEval(t, agent.Config{},
+		agenttest.Response{ToolCalls: []agenttest.ToolCall{{Name: "block"}, {Name: "notify"}}},
+		agenttest.Response{Content: "done"},
+	)
+	started := make(chan struct{})
+	var closed, late atomic.Bool
+	e.Agent.RegisterTool(&agent.Tool{
+		Name:        "block",
+		Description: "Blocks for a while",
+		Handler: func(json.RawMessage) (any, error) {
+			close(started)
+			time.Sleep(20 * time.Millisecond)
+			return "ok", nil
+		},
+	})
+	e.Agent.RegisterTool(&agent.Tool{
+		Name:        "notify",
+		Description: "Notifies in the background",
+		Async:       true,
+		Handler: func(json.RawMessage) (any, error) {
+			time.Sleep(20 * time.Millisecond)
+			late.Store(closed.Load())
+			return "ok", nil
+		},
+	})
+
+	session := e.Agent.NewSession(t.Context())
+	if err := session.Send("go"); err != nil {
+		t.Fatal(err)
+	}
+	<-started
+	session.Close()
+	closed.Store(true)
+
+	// The turn may or may not have dispatched the async tool before being
+	// cancelled, but if it did, the tool returned before Close
+	time.Sleep(50 * time.Millisecond)
+	if late.Load() {
+		t.Error("an async tool dispatched by the running turn returned after Close")
+	}
+	if err := session.Send("again"); err == nil {
+		t.Error("Send() after Close succeeded")
+	}
+}
+
+func TestSessionSendAfterWithoutToolExecution(t *testing.T) {
+	e := agenttest.NewEval(t, agent.Config{},
+		agenttest.Response{ToolCalls: []agenttest.ToolCall{{Name: "echo", Arguments: `{"text":"hi"}`}}},
+		agenttest.Response{Content: "done"},
+	)
+	e.Agent.RegisterTool(echoTool("echo"))
+
+	session := e.Agent.NewSession(t.Context(), agent.WithoutToolExecution())
+	defer session.Close()
+	for _, message := range []string{"echo hi", "thanks"} {
+		if err := session.Send(message); err != nil {
+			t.Fatal(err)
+		}
+		waitTurn(t, session)
+	}
+
+	for _, msg := range session.GetHistory() {
+		if msg := msg.(agent.ConversationMessage); len(msg.ToolCalls) > 0 {
+			t.Errorf("history keeps the unanswered tool calls: %+v", msg)
+		}
+	}
+	for _, msg := range e.Provider.Requests()[1].Messages {
+		if len(msg.ToolCalls) > 0 {
+			t.Errorf("second request has the unanswered tool calls: %+v", msg)
+		}
+	}
+	if turns := session.Conversations(); len(turns) != 2 || len(turns[0].ToolCalls) != 1 || turns[1].AssistantMessage != "done" {
+		t.Errorf("turns = %+v, want the skipped call in the first and the answer in the second", turns)
+	}
+}
+
+// waitTurn waits for the current turn of session to end and fails the test
+// if it failed
+func waitTurn(t *testing.T, session *agent.Session) {
+	t.Helper()
+
+	timeout := time.After(10 * time.Second)
+	for {
+		select {
+		case event := <-session.Events():
+			switch event.Type {
+			case agent.EventTurnComplete:
+				return
+			case agent.EventError:
+				t.Fatalf("turn failed: %s", event.Content)
+			}
+		case <-timeout:
+			t.Fatal("timed out waiting for the turn")
+		}
+	}
+}
+
+func TestPerTurnReminderIsNeverPersisted(t *testing.T) {
+	const reminder = "Today is Saturday."
+	var sessions []*agent.Session
+	e := agenttest.NewEval(t, agent.Config{
+		PerTurnReminder: func(ctx context.Context, s *agent.Session) string {
+			sessions = append(sessions, s)
+			return reminder
+		},
+	},
+		agenttest.Response{ToolCalls: []agenttest.ToolCall{{Name: "echo", Arguments: `{"text":"hi"}`}}},
+		agenttest.Response{Content: "done"},
+		agenttest.Response{Content: "bye"},
+	)
+	e.Agent.RegisterTool(echoTool("echo"))
+
+	session := e.Agent.NewSession(t.Context())
+	defer session.Close()
+	for _, message := range []string{"echo hi", "thanks"} {
+		if err := session.Send(message); err != nil {
+			t.Fatal(err)
+		}
+		waitTurn(t, session)
+	}
+
+	for i, req := range e.Provider.Requests() {
+		count := 0
+		for _, msg := range req.Messages {
+			if msg.Content == reminder {
+				count++
+			}
+		}
+		if last := req.Messages[len(req.Messages)-1]; count != 1 || last.Role != "system" || last.Content != reminder {
+			t.Errorf("request %d has the reminder %d times and ends with %+v, want it once, last", i+1, count, last)
+		}
+	}
+	if len(sessions) != 3 || sessions[0] != session {
+		t.Errorf("hook called %d times, want 3 with the session", len(sessions))
+	}
+	for _, msg := range session.GetHistory() {
+		if msg.(agent.ConversationMessage).Content == reminder {
+			t.Error("the reminder is in the history")
+		}
+	}
+	if strings.Contains(session.ExportMarkdown(), reminder) {
+		t.Error("the reminder is in the Markdown export")
+	}
+}
+
+// TestSessionConcurrentCloseStress races Send, Subscribe, SendInput and
+// Close on sessions whose turns wait for input. Run it with -race.
+func TestSessionConcurrentCloseStress(t *testing.T) {
+	for i := 0; i < 50; i++ {
+		e := agenttest.NewEval(t, agent.Config{MaxLoops: 3})
+		e.Provider.Repeat(agenttest.Response{ToolCalls: []agenttest.ToolCall{{Name: "ask"}}})
+		e.Agent.RegisterTool(&agent.Tool{
+			Name:        "ask",
+			Description: "Asks the user",
+			Executor: agent.ToolExecutorFunc(func(ctx context.Context, args json.RawMessage) (any, error) {
+				return agent.RequestInput(ctx, "Continue?")
+			}),
+		})
+		session := e.Agent.NewSession(t.Context())
+
+		var wg sync.WaitGroup
+		wg.Add(4)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 5; j++ {
+				session.Send("go")
+			}
+		}()
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 3; j++ {
+				for range session.Subscribe() {
+				}
+			}
+		}()
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 20; j++ {
+				session.SendInput("yes")
+				time.Sleep(50 * time.Microsecond)
+			}
+		}()
+		go func() {
+			defer wg.Done()
+			time.Sleep(time.Duration(i%5) * 200 * time.Microsecond)
+			session.Close()
+		}()
+
+		done := make(chan struct{})
+		go func() {
+			wg.Wait()
+			close(done)
+		}()
+		select {
+		case <-done:
+		case <-time.After(10 * time.Second):
+			t.Fatalf("iteration %d deadlocked", i)
+		}
+	}
+}
+
+// TestSessionSetLocaleRace changes the locale while turns and side calls
+// read it. Run it with -race.
+func TestSessionSetLocaleRace(t *testing.T) {
+	e := agenttest.NewEval(t, agent.Config{SummarizeTurns: true})
+	e.Provider.Repeat(agenttest.Response{Content: "ok"})
+	session := e.Agent.NewSession(t.Context())
+	defer session.Close()
+
+	stop := make(chan struct{})
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		tags := []language.Tag{language.French, language.German, language.Japanese, language.Und}
+		for i := 0; ; i++ {
+			select {
+			case <-stop:
+				return
+			default:
+				session.SetLocale(tags[i%len(tags)])
+			}
+		}
+	}()
+
+	ctx := context.Background()
+	session.PrimeCache(ctx)
+	session.Send("hi")
+	waitTurn(t, session)
+	session.Summary(ctx, 10)
+	session.GenerateTitle(ctx)
+	close(stop)
+	wg.Wait()
+}
+
+func TestMessageTimestamps(t *testing.T) {
+	now := time.Date(2025, 3, 1, 14, 0, 0, 0, time.UTC)
+	e := agenttest.NewEval(t, agent.Config{Clock: &fakeClock{now: now}},
+		agenttest.Response{ToolCalls: []agenttest.ToolCall{{Name: "echo", Arguments: `{"text":"hi"}`}}},
+		agenttest.Response{Content: "done"},
+	)
+	e.Agent.RegisterTool(echoTool("echo"))
+
+	session := e.Agent.NewSession(t.Context())
+	defer session.Close()
+	if err := session.Send("echo hi"); err != nil {
+		t.Fatal(err)
+	}
+	waitTurn(t, session)
+	notice := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	if err := session.AppendMessage(agent.ConversationMessage{Role: "assistant", Content: "noted", CreatedAt: notice}); err != nil {
+		t.Fatal(err)
+	}
+
+	history := session.GetHistory()
+	if len(history) != 6 {
+		t.Fatalf("history has %d messages, want 6", len(history))
+	}
+	for i, msg := range history[:5] {
+		if got := msg.(agent.ConversationMessage).CreatedAt; !got.Equal(now) {
+			t.Errorf("message %d CreatedAt = %v, want %v", i, got, now)
+		}
+	}
+	if got := history[5].(agent.ConversationMessage).CreatedAt; !got.Equal(notice) {
+		t.Errorf("appended message CreatedAt = %v, want the one it was given", got)
+	}
+
+	for i, req := range e.Provider.Requests() {
+		if strings.Contains(string(req.Body), "created_at") {
+			t.Errorf("request %d sends created_at: %s", i+1, req.Body)
+		}
+	}
+}
+
+// turnError waits for the turn to fail and returns its TurnError
+func turnError(t *testing.T, session *agent.Session) agent.TurnError {
+	t.Helper()
+
+	timeout := time.After(10 * time.Second)
+	for {
+		select {
+		case event := <-session.Events():
+			switch event.Type {
+			case agent.EventTurnComplete:
+				t.Fatal("turn completed, want it to fail")
+			case agent.EventError:
+				turnErr, ok := event.Data.(agent.TurnError)
+				if !ok {
+					t.Fatalf("EventError Data is %T, want TurnError", event.Data)
+				}
+				return turnErr
+			}
+		case <-timeout:
+			t.Fatal("timed out waiting for the turn")
+		}
+	}
+}
+
+// stallOnce is a transport whose first request hangs until it is cancelled
+type stallOnce struct {
+	next    http.RoundTripper
+	stalled atomic.Bool
+}
+
+func (s *stallOnce) RoundTrip(req *http.Request) (*http.Response, error) {
+	if s.stalled.CompareAndSwap(false, true) {
+		<-req.Context().Done()
+		return nil, req.Context().Err()
+	}
+	return s.next.RoundTrip(req)
+}
+
+func TestSessionSendAfterFailedTurn(t *testing.T) {
+	tests := []struct {
+		name    string
+		config  agent.Config
+		failure []agenttest.Response
+		client  func(p *agenttest.Provider) *http.Client
+		wantErr error
+	}{
+		{
+			name:    "API error",
+			failure: []agenttest.Response{{Status: http.StatusInternalServerError, Content: `{"error":{"message":"down"}}`}},
+		},
+		{
+			name:   "budget",
+			config: agent.Config{MaxTotalTokens: 100},
+			failure: []agenttest.Response{{
+				ToolCalls: []agenttest.ToolCall{{Name: "echo", Arguments: `{"text":"hi"}`}},
+				Usage:     &agent.Usage{PromptTokens: 150, CompletionTokens: 10, TotalTokens: 160},
+			}},
+			wantErr: agent.ErrTokenBudgetExceeded,
+		},
+		{
+			name: "timeout",
+			client: func(p *agenttest.Provider) *http.Client {
+				return &http.Client{Timeout: 50 * time.Millisecond, Transport: &stallOnce{next: p}}
+			},
+			wantErr: context.DeadlineExceeded,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			responses := append(tt.failure, agenttest.Response{
+				Content: "second answer",
+				Usage:   &agent.Usage{PromptTokens: 10, CompletionTokens: 5, TotalTokens: 15},
+			})
+			provider := agenttest.NewProvider(responses...)
+			config := tt.config
+			config.APIKey = "test"
+			config.APIURL = "http://example.invalid/v1/chat/completions"
+			config.Model = "test-model"
+			config.SystemPrompt = "You are a helpful assistant."
+			config.HTTPClient = provider.Client()
+			if tt.client != nil {
+				config.HTTPClient = tt.client(provider)
+			}
+			a, err := agent.New(config)
+			if err != nil {
+				t.Fatal(err)
+			}
+			a.RegisterTool(echoTool("echo"))
+
+			session := a.NewSession(t.Context())
+			defer session.Close()
+			if err := session.Send("first"); err != nil {
+				t.Fatal(err)
+			}
+			turnErr := turnError(t, session)
+			if !turnErr.Recoverable {
+				t.Fatalf("TurnError %v is not recoverable", turnErr.Err)
+			}
+			if tt.wantErr != nil && !errors.Is(turnErr.Err, tt.wantErr) {
+				t.Errorf("TurnError.Err = %v, want %v", turnErr.Err, tt.wantErr)
+			}
+
+			if err := session.Send("second"); err != nil {
+				t.Fatal(err)
+			}
+			waitTurn(t, session)
+
+			var contents []string
+			for _, msg := range session.GetHistory() {
+				contents = append(contents, msg.(agent.ConversationMessage).Content)
+			}
+			want := []string{"You are a helpful assistant.", "second", "second answer"}
+			if strings.Join(contents, "|") != strings.Join(want, "|") {
+				t.Errorf("history = %q, want %q", contents, want)
+			}
+			requests := provider.Requests()
+			last := requests[len(requests)-1].Messages
+			if got := last[len(last)-1].Content; got != "second" || len(last) != 2 {
+				t.Errorf("second request sends %d messages ending with %q, want the system prompt and \"second\"", len(last), got)
+			}
+		})
+	}
+}
+
+// TestFailedTurnRollbackAfterCompaction compacts the history while a turn
+// runs, so that the failed user message is no longer where it was added
+func TestFailedTurnRollbackAfterCompaction(t *testing.T) {
+	e := agenttest.NewEval(t, agent.Config{},
+		agenttest.Response{ToolCalls: []agenttest.ToolCall{{Name: "echo", Arguments: `{"text":"hi"}`}}},
+		agenttest.Response{Content: "first answer"},
+		agenttest.Response{ToolCalls: []agenttest.ToolCall{{Name: "compact"}}},
+		agenttest.Response{Status: http.StatusInternalServerError, Content: `{"error":{"message":"down"}}`},
+	)
+	var session *agent.Session
+	e.Agent.RegisterTools(echoTool("echo"), &agent.Tool{
+		Name:        "compact",
+		Description: "Compact the history",
+		Handler: func(json.RawMessage) (any, error) {
+			return session.CompactHistory(nil), nil
+		},
+	})
+	session = e.Agent.NewSession(t.Context())
+	defer session.Close()
+
+	if err := session.Send("first"); err != nil {
+		t.Fatal(err)
+	}
+	waitTurn(t, session)
+	if err := session.Send("second"); err != nil {
+		t.Fatal(err)
+	}
+	turnError(t, session)
+
+	history := session.GetHistory()
+	last := history[len(history)-1].(agent.ConversationMessage)
+	if last.Content != "first answer" {
+		t.Errorf("last message after the rollback = %q, want the answer of the first turn", last.Content)
+	}
+	for _, msg := range history {
+		if msg.(agent.ConversationMessage).Content == "second" {
+			t.Error("the failed user message is still in the history")
+		}
+	}
+}
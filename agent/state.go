@@ -0,0 +1,40 @@
+package agent
+
+import (
+	"sort"
+)
+
+// SessionState describes the tool calls made so far in a session, or in a
+// run for Run, as seen by Tool.Precondition
+type SessionState struct {
+	last map[string]ToolCallRecord // Latest call of each tool
+}
+
+// Tools returns the names of the tools called so far, sorted
+func (s SessionState) Tools() []string {
+	names := make([]string, 0, len(s.last))
+	for name := range s.last {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// LastCall returns the latest call of the named tool and whether there was
+// one. Its Result holds the JSON sent to the model.
+func (s SessionState) LastCall(name string) (ToolCallRecord, bool) {
+	call, ok := s.last[name]
+	return call, ok
+}
+
+// HasRun reports whether the named tool has been called, successfully or not
+func (s SessionState) HasRun(name string) bool {
+	_, ok := s.last[name]
+	return ok
+}
+
+// Succeeded reports whether the latest call of the named tool succeeded
+func (s SessionState) Succeeded(name string) bool {
+	call, ok := s.last[name]
+	return ok && call.Error == ""
+}
@@ -0,0 +1,186 @@
+package agent
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// maxStateChanges caps the changes reported by one EventStateChange
+const maxStateChanges = 100
+
+// Operations of a StateDiff
+const (
+	StateAdded    = "added"
+	StateRemoved  = "removed"
+	StateModified = "modified"
+)
+
+// StateChange is the Data of EventStateChange: what a call to a tool with a
+// StateSnapshot changed
+type StateChange struct {
+	Tool    string
+	Changes []StateDiff
+	// Truncated is true when there were more than 100 changes and only the
+	// first ones are listed
+	Truncated bool
+}
+
+// StateDiff is a change between two snapshots. Values are the snapshots
+// decoded from JSON: maps, slices, strings, bools, json.Number and nil.
+type StateDiff struct {
+	Op     string // StateAdded, StateRemoved or StateModified
+	Path   string // JSON Pointer, e.g. "/tasks/2/status"; "" for the whole state
+	Before any    // Nil when added
+	After  any    // Nil when removed
+}
+
+// snapshotState captures the state of tool before a call. ok is false when
+// the tool has no StateSnapshot or it failed, in which case no change is
+// reported.
+func (l *loop) snapshotState(ctx context.Context, tool *Tool) (state any, ok bool) {
+	if tool == nil || tool.StateSnapshot == nil {
+		return nil, false
+	}
+	state, err := takeSnapshot(ctx, tool)
+	if err != nil {
+		l.agent.log().Warn().Err(err).Str("tool", tool.Name).Msg(l.logPrefix + " State snapshot failed")
+		return nil, false
+	}
+	return state, true
+}
+
+// stateChange captures the state of tool after a call and emits
+// EventStateChange when it differs from before
+func (l *loop) stateChange(ctx context.Context, tool *Tool, toolCall ToolCall, before any) {
+	after, err := takeSnapshot(ctx, tool)
+	if err != nil {
+		l.agent.log().Warn().Err(err).Str("tool", tool.Name).Msg(l.logPrefix + " State snapshot failed")
+		return
+	}
+
+	var differ stateDiffer
+	differ.diff("", before, after)
+	if len(differ.changes) == 0 {
+		return
+	}
+
+	l.emit(AgentEvent{
+		Type:    EventStateChange,
+		Content: fmt.Sprintf("%d changes", len(differ.changes)),
+		Data: StateChange{
+			Tool:      toolCall.Function.Name,
+			Changes:   differ.changes,
+			Truncated: differ.truncated,
+		},
+		Iteration:  l.loopCount,
+		ToolCallID: toolCall.ID,
+	})
+}
+
+// takeSnapshot calls tool.StateSnapshot and round-trips the state through
+// JSON, so that structs and maps compare alike and later mutations of the
+// returned value do not alter it
+func takeSnapshot(ctx context.Context, tool *Tool) (any, error) {
+	state, err := tool.StateSnapshot(ctx)
+	if err != nil {
+		return nil, err
+	}
+	data, err := json.Marshal(state)
+	if err != nil {
+		return nil, fmt.Errorf("error encoding state snapshot: %w", err)
+	}
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+	var decoded any
+	if err := decoder.Decode(&decoded); err != nil {
+		return nil, fmt.Errorf("error decoding state snapshot: %w", err)
+	}
+	return decoded, nil
+}
+
+// stateDiffer collects the changes between two snapshots, up to
+// maxStateChanges
+type stateDiffer struct {
+	changes   []StateDiff
+	truncated bool
+}
+
+func (d *stateDiffer) add(change StateDiff) {
+	if len(d.changes) == maxStateChanges {
+		d.truncated = true
+		return
+	}
+	d.changes = append(d.changes, change)
+}
+
+// diff compares objects key by key and arrays index by index; any other
+// difference, including a change of type, is reported at path
+func (d *stateDiffer) diff(path string, before, after any) {
+	if d.truncated {
+		return
+	}
+
+	switch b := before.(type) {
+	case map[string]any:
+		if a, ok := after.(map[string]any); ok {
+			keys := make([]string, 0, len(b)+len(a))
+			for key := range b {
+				keys = append(keys, key)
+			}
+			for key := range a {
+				if _, ok := b[key]; !ok {
+					keys = append(keys, key)
+				}
+			}
+			sort.Strings(keys)
+			for _, key := range keys {
+				d.diffEntry(path+"/"+escapePointer(key), b, a, key)
+			}
+			return
+		}
+	case []any:
+		if a, ok := after.([]any); ok {
+			for i := 0; i < max(len(b), len(a)); i++ {
+				elemPath := path + "/" + strconv.Itoa(i)
+				switch {
+				case i >= len(a):
+					d.add(StateDiff{Op: StateRemoved, Path: elemPath, Before: b[i]})
+				case i >= len(b):
+					d.add(StateDiff{Op: StateAdded, Path: elemPath, After: a[i]})
+				default:
+					d.diff(elemPath, b[i], a[i])
+				}
+			}
+			return
+		}
+	}
+
+	if !reflect.DeepEqual(before, after) {
+		d.add(StateDiff{Op: StateModified, Path: path, Before: before, After: after})
+	}
+}
+
+// diffEntry compares the values of key in two objects
+func (d *stateDiffer) diffEntry(path string, before, after map[string]any, key string) {
+	b, inBefore := before[key]
+	a, inAfter := after[key]
+	switch {
+	case !inAfter:
+		d.add(StateDiff{Op: StateRemoved, Path: path, Before: b})
+	case !inBefore:
+		d.add(StateDiff{Op: StateAdded, Path: path, After: a})
+	default:
+		d.diff(path, b, a)
+	}
+}
+
+// escapePointer escapes a key for a JSON Pointer (RFC 6901)
+func escapePointer(key string) string {
+	return strings.NewReplacer("~", "~0", "/", "~1").Replace(key)
+}
@@ -0,0 +1,157 @@
+package agent
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// streamChunk is a server-sent event of a streamed chat completion
+type streamChunk struct {
+	ID      string `json:"id"`
+	Choices []struct {
+		Index int `json:"index"`
+		Delta struct {
+			Role      string `json:"role"`
+			Content   string `json:"content"`
+			ToolCalls []struct {
+				Index    int    `json:"index"`
+				ID       string `json:"id"`
+				Type     string `json:"type"`
+				Function struct {
+					Name      string `json:"name"`
+					Arguments string `json:"arguments"`
+				} `json:"function"`
+			} `json:"tool_calls"`
+		} `json:"delta"`
+		FinishReason string          `json:"finish_reason"`
+		StopReason   json.RawMessage `json:"stop_reason"`
+	} `json:"choices"`
+	Usage *Usage `json:"usage"`
+	Error *struct {
+		Message string `json:"message"`
+	} `json:"error"`
+}
+
+// isEventStream reports whether resp carries server-sent events. Errors
+// are answered with a plain JSON body even to streamed requests.
+func isEventStream(resp *http.Response) bool {
+	return strings.HasPrefix(resp.Header.Get("Content-Type"), "text/event-stream")
+}
+
+// readStream assembles a streamed chat completion into an apiResponse. It
+// fails with ErrStreamStalled when no line arrives for
+// Config.StreamStallTimeout. start is when the request was sent, for the
+// time to first token.
+func (a *Agent) readStream(ctx context.Context, resp *http.Response, start time.Time) (*apiResponse, error) {
+	lines := make(chan []byte)
+	readErr := make(chan error, 1)
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		defer close(lines)
+		scanner := bufio.NewScanner(resp.Body)
+		scanner.Buffer(make([]byte, 64*1024), 10*1024*1024)
+		for scanner.Scan() {
+			select {
+			case lines <- append([]byte(nil), scanner.Bytes()...):
+			case <-done:
+				return
+			}
+		}
+		readErr <- scanner.Err()
+	}()
+
+	apiResp := &apiResponse{Choices: []apiChoice{{Message: apiMessage{Role: "assistant"}}}}
+	message := &apiResp.Choices[0].Message
+	var raw bytes.Buffer
+	var content strings.Builder
+
+	for {
+		var stall <-chan time.Time
+		if a.config.StreamStallTimeout > 0 {
+			stall = a.clock.After(a.config.StreamStallTimeout)
+		}
+
+		var line []byte
+		var ok bool
+		select {
+		case line, ok = <-lines:
+		case <-stall:
+			resp.Body.Close() // Unblocks the reader
+			return nil, fmt.Errorf("%w: no data for %s", ErrStreamStalled, a.config.StreamStallTimeout)
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+		if !ok {
+			if err := <-readErr; err != nil {
+				return nil, fmt.Errorf("error reading response: %w", err)
+			}
+			break
+		}
+
+		raw.Write(line)
+		raw.WriteByte('\n')
+		data, found := bytes.CutPrefix(line, []byte("data:"))
+		if !found {
+			continue
+		}
+		data = bytes.TrimSpace(data)
+		if string(data) == "[DONE]" {
+			break
+		}
+
+		var chunk streamChunk
+		if err := json.Unmarshal(data, &chunk); err != nil {
+			return nil, fmt.Errorf("%w: %w", ErrResponseParse, err)
+		}
+		if chunk.Error != nil && chunk.Error.Message != "" {
+			return nil, fmt.Errorf("%w: %s", ErrEmptyAPIResponse, chunk.Error.Message)
+		}
+		if chunk.ID != "" {
+			apiResp.ID = chunk.ID
+		}
+		if chunk.Usage != nil {
+			apiResp.Usage = chunk.Usage
+		}
+		for _, choice := range chunk.Choices {
+			if choice.Index != 0 {
+				continue
+			}
+			delta := choice.Delta
+			if apiResp.firstToken == 0 && (delta.Content != "" || len(delta.ToolCalls) > 0) {
+				apiResp.firstToken = a.clock.Now().Sub(start)
+			}
+			content.WriteString(delta.Content)
+			for _, call := range delta.ToolCalls {
+				for len(message.ToolCalls) <= call.Index {
+					message.ToolCalls = append(message.ToolCalls, ToolCall{})
+				}
+				tc := &message.ToolCalls[call.Index]
+				if call.ID != "" {
+					tc.ID = call.ID
+				}
+				if call.Type != "" {
+					tc.Type = call.Type
+				}
+				tc.Function.Name += call.Function.Name
+				tc.Function.Arguments += call.Function.Arguments
+			}
+			if choice.FinishReason != "" {
+				apiResp.Choices[0].FinishReason = choice.FinishReason
+			}
+			if stopReason(choice.StopReason) != "" {
+				apiResp.Choices[0].StopReason = choice.StopReason
+			}
+		}
+	}
+
+	message.Content = content.String()
+	apiResp.raw = raw.Bytes()
+	return apiResp, nil
+}
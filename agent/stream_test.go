@@ -0,0 +1,125 @@
+package agent_test
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/trogui/go-agent-sdk/agent"
+)
+
+// sseServer answers streamed requests with two chunks, then stalls until
+// the client gives up, and plain requests with a JSON completion
+type sseServer struct {
+	*httptest.Server
+
+	mu       sync.Mutex
+	streamed []bool // Whether each request asked for a stream
+}
+
+func newSSEServer(t *testing.T, stall bool) *sseServer {
+	s := &sseServer{}
+	s.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		var req struct {
+			Stream bool `json:"stream"`
+		}
+		json.Unmarshal(body, &req)
+		s.mu.Lock()
+		s.streamed = append(s.streamed, req.Stream)
+		s.mu.Unlock()
+
+		if !req.Stream {
+			w.Header().Set("Content-Type", "application/json")
+			fmt.Fprint(w, `{"choices":[{"message":{"role":"assistant","content":"Hello world"},"finish_reason":"stop"}]}`)
+			return
+		}
+
+		w.Header().Set("Content-Type", "text/event-stream")
+		for _, content := range []string{"Hello", " world"} {
+			fmt.Fprintf(w, "data: {\"choices\":[{\"index\":0,\"delta\":{\"content\":%q}}]}\n\n", content)
+			w.(http.Flusher).Flush()
+		}
+		if stall {
+			<-r.Context().Done()
+			return
+		}
+		fmt.Fprint(w, "data: {\"choices\":[{\"index\":0,\"delta\":{},\"finish_reason\":\"stop\"}]}\n\ndata: [DONE]\n\n")
+	}))
+	t.Cleanup(s.Close)
+	return s
+}
+
+func (s *sseServer) requests() []bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return append([]bool(nil), s.streamed...)
+}
+
+func newStreamAgent(t *testing.T, s *sseServer, fallback bool) *agent.Agent {
+	a, err := agent.New(agent.Config{
+		APIKey:             "test",
+		APIURL:             s.URL,
+		Model:              "test-model",
+		SystemPrompt:       "You are a helpful assistant.",
+		Stream:             true,
+		StreamStallTimeout: 100 * time.Millisecond,
+		StreamFallback:     fallback,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return a
+}
+
+func TestStream(t *testing.T) {
+	s := newSSEServer(t, false)
+	a := newStreamAgent(t, s, false)
+
+	resp, err := a.Run("hi")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.Content != "Hello world" {
+		t.Errorf("Content = %q, want %q", resp.Content, "Hello world")
+	}
+	if resp.TimeToFirstToken <= 0 {
+		t.Errorf("TimeToFirstToken = %v, want it measured", resp.TimeToFirstToken)
+	}
+}
+
+func TestStreamStalled(t *testing.T) {
+	s := newSSEServer(t, true)
+	a := newStreamAgent(t, s, false)
+
+	_, err := a.Run("hi")
+	if !errors.Is(err, agent.ErrStreamStalled) {
+		t.Fatalf("Run() error = %v, want ErrStreamStalled", err)
+	}
+	if got := s.requests(); len(got) != 1 {
+		t.Errorf("server got %d requests, want 1 without StreamFallback", len(got))
+	}
+}
+
+func TestStreamFallback(t *testing.T) {
+	s := newSSEServer(t, true)
+	a := newStreamAgent(t, s, true)
+
+	resp, err := a.Run("hi")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.Content != "Hello world" {
+		t.Errorf("Content = %q, want the non-streamed answer", resp.Content)
+	}
+	if got, want := s.requests(), []bool{true, false}; fmt.Sprint(got) != fmt.Sprint(want) {
+		t.Errorf("streamed requests = %v, want %v", got, want)
+	}
+}
@@ -0,0 +1,64 @@
+package agent
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// StreamingToolHandler is a tool handler producing its output incrementally,
+// e.g. from a long-running process. The stream is read to the end and
+// closed; the assembled text is the tool result.
+type StreamingToolHandler func(ctx context.Context, args json.RawMessage) (io.ReadCloser, error)
+
+// ToolStreamChunk is the Data of EventToolStreamChunk
+type ToolStreamChunk struct {
+	Content string
+}
+
+// toolStreamChunkSize is the size of the reads from a tool stream, and so
+// the maximum size of a chunk event
+const toolStreamChunkSize = 4096
+
+// toolChunkKey is the context key of the callback receiving stream chunks
+type toolChunkKey struct{}
+
+// withToolChunks returns ctx carrying onChunk for streaming tools
+func withToolChunks(ctx context.Context, onChunk func(string)) context.Context {
+	return context.WithValue(ctx, toolChunkKey{}, onChunk)
+}
+
+// readToolStream runs a streaming tool and assembles its output, passing
+// each chunk to the callback in ctx, if any
+func readToolStream(ctx context.Context, handler StreamingToolHandler, args json.RawMessage) (any, error) {
+	stream, err := handler(ctx, args)
+	if err != nil {
+		return nil, err
+	}
+	defer stream.Close()
+
+	onChunk, _ := ctx.Value(toolChunkKey{}).(func(string))
+	var output strings.Builder
+	buf := make([]byte, toolStreamChunkSize)
+	for {
+		n, err := stream.Read(buf)
+		if n > 0 {
+			chunk := string(buf[:n])
+			output.WriteString(chunk)
+			if onChunk != nil {
+				onChunk(chunk)
+			}
+		}
+		if err == io.EOF {
+			return output.String(), nil
+		}
+		if err != nil {
+			return nil, fmt.Errorf("error reading tool output: %w", err)
+		}
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+	}
+}
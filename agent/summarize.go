@@ -0,0 +1,68 @@
+package agent
+
+import (
+	"context"
+	"fmt"
+	"strings"
+)
+
+// summarizePrompt asks the model to compress a transcript
+const summarizePrompt = "Summarize the following conversation so that it can replace it as context for continuing it. Keep the user's goals, decisions, facts learned from tools and open questions; drop pleasantries and repetition. Reply with the summary only."
+
+// Summarize compresses a conversation into its system prompt followed by a
+// single assistant message summarizing the rest, e.g. to continue a long
+// session in a new one. The system prompt is the first system message of
+// messages, or Config.SystemPrompt when there is none. maxTokens is sent as
+// max_tokens to bound the summary, and ignored when zero.
+func (a *Agent) Summarize(ctx context.Context, messages []ConversationMessage, maxTokens int) ([]ConversationMessage, error) {
+	system := ConversationMessage{Role: "system", Content: a.config.SystemPrompt}
+	if len(messages) > 0 && messages[0].Role == "system" {
+		system = messages[0]
+	}
+	transcript := conversationTranscript(messages)
+	if transcript == "" {
+		return nil, fmt.Errorf("conversation is empty")
+	}
+
+	summary, _, err := a.complete(ctx, apiRequest{
+		messages: []ConversationMessage{
+			{Role: "system", Content: summarizePrompt},
+			{Role: "user", Content: transcript},
+		},
+		maxTokens: maxTokens,
+	})
+	if err != nil {
+		return nil, err
+	}
+	if summary == "" {
+		return nil, fmt.Errorf("model returned an empty summary")
+	}
+
+	a.log().Debug().
+		Int("messages", len(messages)).
+		Int("summary_chars", len(summary)).
+		Msg("[Agent] Conversation summarized")
+
+	return []ConversationMessage{
+		system,
+		{Role: "assistant", Content: summary, CreatedAt: a.clock.Now()},
+	}, nil
+}
+
+// conversationTranscript renders the messages other than system and
+// transient ones as text for a side call, one line per message or tool call
+func conversationTranscript(messages []ConversationMessage) string {
+	var transcript strings.Builder
+	for _, msg := range messages {
+		if msg.Role == "system" || msg.Transient {
+			continue
+		}
+		if msg.Content != "" {
+			fmt.Fprintf(&transcript, "%s: %s\n", msg.Role, msg.Content)
+		}
+		for _, call := range msg.ToolCalls {
+			fmt.Fprintf(&transcript, "%s called %s(%s)\n", msg.Role, call.Function.Name, call.Function.Arguments)
+		}
+	}
+	return transcript.String()
+}
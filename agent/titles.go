@@ -0,0 +1,180 @@
+package agent
+
+import (
+	"context"
+	"fmt"
+	"strings"
+)
+
+// titleContextMessages is how many user/assistant messages GenerateTitle reads
+const titleContextMessages = 6
+
+// TurnSummary is the Data of EventTurnComplete when Config.SummarizeTurns is set
+type TurnSummary struct {
+	Summary   string   // One sentence describing what the agent did
+	ToolsUsed []string // Names of the tools called during the turn, in order
+}
+
+// complete makes a single call without tools and returns the reply text. It
+// does not touch any loop state, so it never counts against MaxLoops.
+func (a *Agent) complete(ctx context.Context, r apiRequest) (string, Usage, error) {
+	r.noTools = true
+	r.textOnly = true
+
+	resp, err := a.callAPI(ctx, r)
+	if err != nil {
+		return "", Usage{}, fmt.Errorf("API call error: %w", err)
+	}
+
+	var usage Usage
+	if resp.Usage != nil {
+		usage = *resp.Usage
+	}
+	if len(resp.Choices) == 0 {
+		return "", usage, fmt.Errorf("no response from API")
+	}
+	return strings.TrimSpace(resp.Choices[0].Message.Content), usage, nil
+}
+
+// GenerateTitle returns a short title for the conversation, generated from
+// its first messages with Config.TitleModel (default Config.Model). The title
+// is cached and only regenerated once the history has grown by more than
+// Config.TitleRefreshMessages messages. Tokens spent are reported by
+// AuxiliaryUsage, not by the turns.
+func (s *Session) GenerateTitle(ctx context.Context) (string, error) {
+	s.mu.RLock()
+	title, titleLen := s.title, s.titleLen
+	history := make([]ConversationMessage, len(s.messages))
+	copy(history, s.messages)
+	options := s.options
+	s.mu.RUnlock()
+
+	if title != "" && len(history)-titleLen <= s.agent.config.TitleRefreshMessages {
+		return title, nil
+	}
+
+	var transcript strings.Builder
+	count := 0
+	for _, msg := range history {
+		if (msg.Role != "user" && msg.Role != "assistant") || msg.Content == "" {
+			continue
+		}
+		fmt.Fprintf(&transcript, "%s: %s\n", msg.Role, msg.Content)
+		count++
+		if count == titleContextMessages {
+			break
+		}
+	}
+	if count == 0 {
+		return "", fmt.Errorf("conversation is empty")
+	}
+
+	title, usage, err := s.agent.complete(ctx, apiRequest{
+		model:   s.agent.config.TitleModel,
+		options: options,
+		messages: []ConversationMessage{
+			{Role: "system", Content: "Write a title of at most six words for the following conversation. Reply with the title only, without quotes."},
+			{Role: "user", Content: transcript.String()},
+		},
+	})
+	s.addAuxiliaryUsage(usage)
+	if err != nil {
+		return "", err
+	}
+	title = strings.Trim(title, `"' `)
+
+	s.mu.Lock()
+	s.title = title
+	s.titleLen = len(history)
+	s.mu.Unlock()
+
+	return title, nil
+}
+
+// Summary returns a summary of the conversation in at most maxWords words
+// (50 when zero), e.g. to label a stored thread. It is a side call with
+// Config.TitleModel (default Config.Model) that leaves the history
+// untouched; tokens spent are reported by AuxiliaryUsage.
+func (s *Session) Summary(ctx context.Context, maxWords int) (string, error) {
+	if maxWords <= 0 {
+		maxWords = 50
+	}
+
+	s.mu.RLock()
+	transcript := conversationTranscript(s.messages)
+	options := s.options
+	s.mu.RUnlock()
+	if transcript == "" {
+		return "", fmt.Errorf("conversation is empty")
+	}
+
+	summary, usage, err := s.agent.complete(ctx, apiRequest{
+		model:   s.agent.config.TitleModel,
+		options: options,
+		messages: []ConversationMessage{
+			{Role: "system", Content: fmt.Sprintf("Summarize the following conversation in at most %d words. Reply with the summary only.", maxWords)},
+			{Role: "user", Content: transcript},
+		},
+	})
+	s.addAuxiliaryUsage(usage)
+	if err != nil {
+		return "", err
+	}
+	if summary == "" {
+		return "", fmt.Errorf("model returned an empty summary")
+	}
+	return summary, nil
+}
+
+// AuxiliaryUsage returns the tokens spent on side calls such as titles and
+// turn summaries, which are not part of any turn's usage
+func (s *Session) AuxiliaryUsage() Usage {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	return s.auxUsage
+}
+
+// addAuxiliaryUsage accumulates the usage of a side call
+func (s *Session) addAuxiliaryUsage(usage Usage) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.auxUsage.PromptTokens += usage.PromptTokens
+	s.auxUsage.CompletionTokens += usage.CompletionTokens
+	s.auxUsage.TotalTokens += usage.TotalTokens
+}
+
+// summarizeTurn describes a completed turn in one sentence
+func (s *Session) summarizeTurn(message string, answer string, toolCalls []ToolCallRecord) *TurnSummary {
+	summary := &TurnSummary{}
+	for _, call := range toolCalls {
+		summary.ToolsUsed = append(summary.ToolsUsed, call.Name)
+	}
+
+	tools := "none"
+	if len(summary.ToolsUsed) > 0 {
+		tools = strings.Join(summary.ToolsUsed, ", ")
+	}
+
+	s.mu.RLock()
+	options := s.options
+	s.mu.RUnlock()
+
+	text, usage, err := s.agent.complete(s.ctx, apiRequest{
+		model:   s.agent.config.TitleModel,
+		options: options,
+		messages: []ConversationMessage{
+			{Role: "system", Content: "Describe in one sentence what the assistant did in this exchange, mentioning the tools it used. Reply with the sentence only."},
+			{Role: "user", Content: fmt.Sprintf("User: %s\nTools used: %s\nAssistant: %s", message, tools, answer)},
+		},
+	})
+	s.addAuxiliaryUsage(usage)
+	if err != nil {
+		s.agent.log().Warn().Err(err).Msg("[Session] Turn summary failed")
+		return nil
+	}
+
+	summary.Summary = text
+	return summary
+}
@@ -0,0 +1,87 @@
+package agent
+
+import (
+	"encoding/json"
+	"strings"
+)
+
+// Token estimation is deliberately conservative: about three characters per
+// token plus a fixed overhead per message, so estimates overshoot rather
+// than undershoot real tokenizers.
+const (
+	charsPerToken       = 3
+	tokensPerMessage    = 4
+	defaultSafetyMargin = 10
+)
+
+// modelContextWindows lists the context size in tokens of well-known models.
+// Names are matched after stripping a provider prefix such as "openai/".
+var modelContextWindows = map[string]int{
+	"gpt-4o":                  128000,
+	"gpt-4o-mini":             128000,
+	"gpt-4.1":                 1047576,
+	"gpt-4.1-mini":            1047576,
+	"gpt-4.1-nano":            1047576,
+	"gpt-4-turbo":             128000,
+	"gpt-4":                   8192,
+	"gpt-3.5-turbo":           16385,
+	"o1":                      200000,
+	"o3":                      200000,
+	"o3-mini":                 200000,
+	"o4-mini":                 200000,
+	"claude-3.5-sonnet":       200000,
+	"claude-3.7-sonnet":       200000,
+	"claude-sonnet-4":         200000,
+	"claude-opus-4":           200000,
+	"gemini-1.5-pro":          2097152,
+	"gemini-2.0-flash":        1048576,
+	"gemini-2.5-pro":          1048576,
+	"gemini-2.5-flash":        1048576,
+	"llama-3.1-8b-instant":    131072,
+	"llama-3.3-70b-versatile": 131072,
+	"grok-4":                  256000,
+	"grok-3":                  131072,
+	"grok-3-mini":             131072,
+	"deepseek-chat":           65536,
+	"deepseek-reasoner":       65536,
+}
+
+// contextWindow returns the context size of the configured model, or 0 when
+// it is unknown
+func (a *Agent) contextWindow(model string) int {
+	if a.config.ContextWindow > 0 {
+		return a.config.ContextWindow
+	}
+	if i := strings.LastIndex(model, "/"); i >= 0 {
+		model = model[i+1:]
+	}
+	return modelContextWindows[model]
+}
+
+// estimateTokens conservatively estimates the prompt tokens of a request
+// with the given messages and the registered tools. Transient messages are
+// not sent and therefore not counted.
+func (a *Agent) estimateTokens(messages []ConversationMessage) int {
+	chars := 0
+	count := 0
+	for _, msg := range messages {
+		if msg.Transient {
+			continue
+		}
+		count++
+		chars += len(msg.Role) + len(msg.Content)
+		for _, call := range msg.ToolCalls {
+			chars += len(call.ID) + len(call.Function.Name) + len(call.Function.Arguments)
+		}
+	}
+
+	a.toolsMu.RLock()
+	for _, tool := range a.tools {
+		if schema, err := json.Marshal(toAPITool(tool)); err == nil {
+			chars += len(schema)
+		}
+	}
+	a.toolsMu.RUnlock()
+
+	return chars/charsPerToken + count*tokensPerMessage
+}
@@ -0,0 +1,25 @@
+package agent
+
+import (
+	"context"
+)
+
+// toolContextKey namespaces the keys of WithToolContext so they cannot
+// collide with other context values
+type toolContextKey struct {
+	key any
+}
+
+// WithToolContext returns a copy of ctx carrying value under key for tool
+// executors. Pass the result to RunContext or NewSession to share services
+// such as database connections with every tool of the run or session,
+// without closures. Keys should be comparable, as with context.WithValue.
+func WithToolContext(ctx context.Context, key any, value any) context.Context {
+	return context.WithValue(ctx, toolContextKey{key}, value)
+}
+
+// ToolContextValue returns the value stored under key by WithToolContext, or
+// nil. Tool executors call it on the context they receive.
+func ToolContextValue(ctx context.Context, key any) any {
+	return ctx.Value(toolContextKey{key})
+}
@@ -0,0 +1,76 @@
+package agent
+
+import (
+	"math"
+	"sync"
+	"time"
+)
+
+// ToolRateLimited is the Data of EventToolRateLimited
+type ToolRateLimited struct {
+	Tool              string
+	RetryAfterSeconds int
+}
+
+// toolBucket is the token bucket of a tool with a RateLimit. It holds up to
+// RateLimit tokens and refills at RateLimit tokens per minute.
+type toolBucket struct {
+	tokens  float64
+	updated time.Time
+}
+
+// toolLimiter holds the token buckets of the rate-limited tools
+type toolLimiter struct {
+	mu      sync.Mutex
+	buckets map[string]*toolBucket
+}
+
+// allowTool takes a token from the tool's bucket. When the bucket is empty
+// it returns false and the wait until the next token.
+func (a *Agent) allowTool(tool *Tool) (bool, time.Duration) {
+	if tool == nil || tool.RateLimit <= 0 {
+		return true, 0
+	}
+
+	a.toolLimits.mu.Lock()
+	defer a.toolLimits.mu.Unlock()
+
+	now := a.clock.Now()
+	limit := float64(tool.RateLimit)
+	bucket, ok := a.toolLimits.buckets[tool.Name]
+	if !ok {
+		if a.toolLimits.buckets == nil {
+			a.toolLimits.buckets = make(map[string]*toolBucket)
+		}
+		bucket = &toolBucket{tokens: limit, updated: now}
+		a.toolLimits.buckets[tool.Name] = bucket
+	}
+
+	bucket.tokens = math.Min(limit, bucket.tokens+now.Sub(bucket.updated).Minutes()*limit)
+	bucket.updated = now
+	if bucket.tokens >= 1 {
+		bucket.tokens--
+		return true, 0
+	}
+	return false, time.Duration((1 - bucket.tokens) / limit * float64(time.Minute))
+}
+
+// rateLimited reports a call refused by Tool.RateLimit and returns the
+// result sent to the model instead of calling the handler
+func (l *loop) rateLimited(toolCall ToolCall, retryAfter time.Duration) any {
+	seconds := int(math.Ceil(retryAfter.Seconds()))
+	l.agent.log().Warn().
+		Str("tool", toolCall.Function.Name).
+		Int("retry_after_seconds", seconds).
+		Msg(l.logPrefix + " Tool rate limit exceeded")
+
+	l.emit(AgentEvent{
+		Type:       EventToolRateLimited,
+		Content:    toolCall.Function.Name,
+		Data:       ToolRateLimited{Tool: toolCall.Function.Name, RetryAfterSeconds: seconds},
+		Iteration:  l.loopCount,
+		ToolCallID: toolCall.ID,
+	})
+
+	return map[string]any{"error": "rate_limit_exceeded", "retry_after_seconds": seconds}
+}
@@ -0,0 +1,115 @@
+package agent
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+)
+
+// ToolInfo describes a registered tool
+type ToolInfo struct {
+	Name        string
+	Description string
+	Version     string
+	Changelog   []string
+}
+
+// ListTools returns the registered tools sorted by name
+func (a *Agent) ListTools() []ToolInfo {
+	a.toolsMu.RLock()
+	defer a.toolsMu.RUnlock()
+
+	infos := make([]ToolInfo, 0, len(a.tools))
+	for _, tool := range a.tools {
+		infos = append(infos, ToolInfo{
+			Name:        tool.Name,
+			Description: tool.Description,
+			Version:     tool.Version,
+			Changelog:   tool.Changelog,
+		})
+	}
+	sort.Slice(infos, func(i, j int) bool { return infos[i].Name < infos[j].Name })
+	return infos
+}
+
+// exportedTool is a tool schema as written by ExportToolSchemas
+type exportedTool struct {
+	apiTool
+	Version   string   `json:"version,omitempty"`
+	Changelog []string `json:"changelog,omitempty"`
+}
+
+// ExportToolSchemas returns the schemas of all registered tools as a JSON
+// array sorted by name. Each entry is the tool as sent to the API plus its
+// version and changelog, so deployments can be compared for drift.
+func (a *Agent) ExportToolSchemas() ([]byte, error) {
+	a.toolsMu.RLock()
+	exported := make([]exportedTool, 0, len(a.tools))
+	for _, tool := range a.tools {
+		exported = append(exported, exportedTool{
+			apiTool:   toAPITool(tool),
+			Version:   tool.Version,
+			Changelog: tool.Changelog,
+		})
+	}
+	a.toolsMu.RUnlock()
+
+	sort.Slice(exported, func(i, j int) bool {
+		return exported[i].Function.Name < exported[j].Function.Name
+	})
+
+	data, err := json.Marshal(exported)
+	if err != nil {
+		return nil, fmt.Errorf("error encoding tool schemas: %w", err)
+	}
+	return data, nil
+}
+
+// ToolSchema returns the definition of a registered tool exactly as sent in
+// API requests: a "tools" entry, or a "functions" entry when Config.ToolFormat
+// is ToolFormatFunctions
+func (a *Agent) ToolSchema(name string) (json.RawMessage, error) {
+	tool := a.lookupTool(name)
+	if tool == nil {
+		return nil, fmt.Errorf("tool not registered: %s", name)
+	}
+
+	var schema any = toAPITool(tool)
+	if a.config.ToolFormat == ToolFormatFunctions {
+		schema = toAPITool(tool).Function
+	}
+
+	data, err := json.Marshal(schema)
+	if err != nil {
+		return nil, fmt.Errorf("error encoding tool schema: %w", err)
+	}
+	return data, nil
+}
+
+// toAPITool converts a tool to the API format
+func toAPITool(tool *Tool) apiTool {
+	properties := make(map[string]apiParameter)
+	for name, param := range tool.Parameters {
+		apiParam := apiParameter{
+			Type:        param.Type,
+			Description: param.Description,
+		}
+		if param.Items != nil {
+			apiParam.Items = &apiItems{Type: param.Items.Type}
+		}
+		properties[name] = apiParam
+	}
+
+	return apiTool{
+		Type: "function",
+		Function: apiFunction{
+			Name:        tool.Name,
+			Description: tool.Description,
+			Parameters: apiParameters{
+				Type:       "object",
+				Properties: properties,
+				Required:   tool.Required,
+			},
+		},
+	}
+}
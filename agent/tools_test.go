@@ -0,0 +1,110 @@
+package agent_test
+
+import (
+	"context"
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/trogui/go-agent-sdk/agent"
+	"github.com/trogui/go-agent-sdk/agent/agenttest"
+)
+
+func TestUnencodableToolResult(t *testing.T) {
+	e := agenttest.NewEval(t, agent.Config{},
+		agenttest.Response{ToolCalls: []agenttest.ToolCall{{Name: "broken"}}},
+		agenttest.Response{Content: "The tool failed."},
+	)
+	e.Agent.RegisterTool(&agent.Tool{
+		Name:        "broken",
+		Description: "Returns a value JSON cannot encode",
+		Handler: func(json.RawMessage) (any, error) {
+			return map[string]any{"updates": make(chan int)}, nil
+		},
+	})
+
+	session := e.Agent.NewSession(t.Context())
+	defer session.Close()
+	if err := session.Send("go"); err != nil {
+		t.Fatal(err)
+	}
+
+	invalid := false
+	timeout := time.After(10 * time.Second)
+	for done := false; !done; {
+		select {
+		case event := <-session.Events():
+			switch event.Type {
+			case agent.EventToolResultInvalid:
+				invalid = event.Data == "broken"
+			case agent.EventTurnComplete:
+				done = true
+			case agent.EventError:
+				t.Fatalf("turn failed: %s", event.Content)
+			}
+		case <-timeout:
+			t.Fatal("timed out waiting for the turn")
+		}
+	}
+
+	if !invalid {
+		t.Error("EventToolResultInvalid was not emitted for the tool")
+	}
+	turns := session.Conversations()
+	if len(turns) != 1 || turns[0].AssistantMessage != "The tool failed." {
+		t.Fatalf("turns = %+v, want the run to complete", turns)
+	}
+	if call := turns[0].ToolCalls[0]; call.Error == "" {
+		t.Errorf("tool call record has no error: %+v", call)
+	}
+	messages := e.Provider.Requests()[1].Messages
+	if result := messages[len(messages)-1]; result.Role != "tool" || !strings.Contains(result.Content, "could not be encoded") {
+		t.Errorf("tool message = %+v, want the encoding error", result)
+	}
+}
+
+func TestConfigToolExecutor(t *testing.T) {
+	type call struct {
+		name, args string
+		tenant     any
+	}
+	var calls []call
+	e := agenttest.NewEval(t, agent.Config{
+		ToolExecutor: func(ctx context.Context, name string, args json.RawMessage) (any, error) {
+			calls = append(calls, call{name, string(args), agent.ToolContextValue(ctx, "tenant")})
+			return map[string]string{"sandboxed": name}, nil
+		},
+	},
+		agenttest.Response{ToolCalls: []agenttest.ToolCall{{Name: "lookup", Arguments: `{"q":"go"}`}}},
+		agenttest.Response{Content: "done"},
+	)
+	handled := false
+	e.Agent.RegisterTool(&agent.Tool{Name: "lookup", Description: "Schema only"})
+	e.Agent.RegisterTool(&agent.Tool{
+		Name:        "local",
+		Description: "Has a handler the executor overrides",
+		Handler: func(json.RawMessage) (any, error) {
+			handled = true
+			return "local", nil
+		},
+	})
+
+	ctx := agent.WithToolContext(context.Background(), "tenant", "acme")
+	resp, err := e.Agent.RunContext(ctx, "look it up")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(calls) != 1 || calls[0] != (call{"lookup", `{"q":"go"}`, "acme"}) {
+		t.Errorf("executor calls = %+v, want one lookup call with the tool context", calls)
+	}
+	if handled {
+		t.Error("a registered handler ran despite the executor")
+	}
+	if got := resp.ToolCalls[0].Result; got != `{"sandboxed":"lookup"}` {
+		t.Errorf("result = %s, want the executor's", got)
+	}
+	if len(e.Provider.Requests()[0].Tools) != 2 {
+		t.Errorf("sent %d tool schemas, want 2", len(e.Provider.Requests()[0].Tools))
+	}
+}
@@ -0,0 +1,72 @@
+package agent
+
+import (
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestTransportPoolSettings(t *testing.T) {
+	tests := []struct {
+		name        string
+		perHost     int
+		idleTimeout time.Duration
+		wantPerHost int
+		wantIdle    int
+		wantTimeout time.Duration
+	}{
+		{"defaults", 0, 0, 16, 100, 90 * time.Second},
+		{"custom", 32, time.Minute, 32, 100, time.Minute},
+		{"above the global limit", 200, 0, 200, 200, 90 * time.Second},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			a, err := New(Config{
+				APIKey:              "test",
+				APIURL:              "http://localhost/v1/chat/completions",
+				Model:               "test-model",
+				SystemPrompt:        "You are a test assistant.",
+				MaxIdleConnsPerHost: tt.perHost,
+				IdleConnTimeout:     tt.idleTimeout,
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			transport, ok := a.client.Transport.(*http.Transport)
+			if !ok {
+				t.Fatalf("transport is %T, want *http.Transport", a.client.Transport)
+			}
+			if transport.MaxIdleConnsPerHost != tt.wantPerHost {
+				t.Errorf("MaxIdleConnsPerHost = %d, want %d", transport.MaxIdleConnsPerHost, tt.wantPerHost)
+			}
+			if transport.MaxIdleConns != tt.wantIdle {
+				t.Errorf("MaxIdleConns = %d, want %d", transport.MaxIdleConns, tt.wantIdle)
+			}
+			if transport.IdleConnTimeout != tt.wantTimeout {
+				t.Errorf("IdleConnTimeout = %v, want %v", transport.IdleConnTimeout, tt.wantTimeout)
+			}
+		})
+	}
+}
+
+func TestTransportCustomClient(t *testing.T) {
+	client := &http.Client{}
+	a, err := New(Config{
+		APIKey:              "test",
+		APIURL:              "http://localhost/v1/chat/completions",
+		Model:               "test-model",
+		SystemPrompt:        "You are a test assistant.",
+		HTTPClient:          client,
+		MaxIdleConnsPerHost: 32,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if a.client != client {
+		t.Error("Config.HTTPClient was not used")
+	}
+	if client.Transport != nil {
+		t.Errorf("the pool settings altered Config.HTTPClient: %T", client.Transport)
+	}
+}
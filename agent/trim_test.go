@@ -0,0 +1,65 @@
+package agent
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestTrimMessages(t *testing.T) {
+	system := ConversationMessage{Role: "system", Content: "prompt"}
+	user1 := ConversationMessage{Role: "user", Content: "first"}
+	answer1 := ConversationMessage{Role: "assistant", Content: "answer"}
+	user2 := ConversationMessage{Role: "user", Content: "second"}
+	calls := ConversationMessage{Role: "assistant", ToolCalls: []ToolCall{
+		{ID: "a", Type: "function", Function: FunctionCall{Name: "lookup", Arguments: "{}"}},
+		{ID: "b", Type: "function", Function: FunctionCall{Name: "lookup", Arguments: "{}"}},
+	}}
+	resultA := ConversationMessage{Role: "tool", ToolCallID: "a", Content: "1"}
+	resultB := ConversationMessage{Role: "tool", ToolCallID: "b", Content: "2"}
+	call := ConversationMessage{Role: "assistant", ToolCalls: []ToolCall{
+		{ID: "c", Type: "function", Function: FunctionCall{Name: "lookup", Arguments: "{}"}},
+	}}
+	resultC := ConversationMessage{Role: "tool", ToolCallID: "c", Content: "3"}
+
+	history := []ConversationMessage{system, user1, answer1, user2, calls, resultA, resultB}
+	twoExchanges := []ConversationMessage{system, user1, answer1, user2, calls, resultA, resultB, call, resultC}
+
+	tests := []struct {
+		name     string
+		messages []ConversationMessage
+		max      int
+		want     []ConversationMessage
+	}{
+		{"max 1 keeps the pinned messages", []ConversationMessage{system, user1}, 1, []ConversationMessage{system, user1}},
+		{"max 1 with an exchange", history, 1, []ConversationMessage{system, user2, calls, resultA, resultB}},
+		{"max 2 keeps the latest exchange whole", history, 2, []ConversationMessage{system, user2, calls, resultA, resultB}},
+		{"max 3 keeps the latest exchange whole", history, 3, []ConversationMessage{system, user2, calls, resultA, resultB}},
+		{"max 3 drops the older exchange", twoExchanges, 3, []ConversationMessage{system, user2, call, resultC}},
+		{"max 5 drops a split exchange", twoExchanges, 5, []ConversationMessage{system, user2, call, resultC}},
+		{"max 7 keeps both exchanges", twoExchanges, 7, []ConversationMessage{system, user2, calls, resultA, resultB, call, resultC}},
+		{"under the cap", history, 10, history},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := trimMessages(tt.messages, tt.max)
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("trimMessages(%d) =\n%+v\nwant\n%+v", tt.max, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestMaxContextMessagesValidation(t *testing.T) {
+	for _, max := range []int{-1, 1} {
+		config := Config{APIKey: "test", APIURL: "http://localhost", Model: "m", SystemPrompt: "p", MaxContextMessages: max}
+		if err := config.applyDefaults(); err == nil {
+			t.Errorf("MaxContextMessages %d was accepted", max)
+		}
+	}
+	for _, max := range []int{0, 2} {
+		config := Config{APIKey: "test", APIURL: "http://localhost", Model: "m", SystemPrompt: "p", MaxContextMessages: max}
+		if err := config.applyDefaults(); err != nil {
+			t.Errorf("MaxContextMessages %d: %v", max, err)
+		}
+	}
+}
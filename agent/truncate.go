@@ -0,0 +1,108 @@
+package agent
+
+import (
+	"bytes"
+	"encoding/json"
+	"unicode/utf8"
+)
+
+// truncatedMarker ends string values cut by Config.MaxToolResultLength
+const truncatedMarker = "...[truncated]"
+
+// truncateResult shortens the string values of an encoded tool result until
+// it fits in limit bytes, cutting every string to the largest length that
+// fits. Keys, numbers and the structure are kept, so a result made
+// mostly of other values can stay over the limit.
+func (l *loop) truncateResult(toolCall ToolCall, result []byte, limit int) []byte {
+	decoder := json.NewDecoder(bytes.NewReader(result))
+	decoder.UseNumber()
+	var value any
+	if err := decoder.Decode(&value); err != nil {
+		return result
+	}
+
+	// Binary search for the largest cap that fits. Output shrinks with the
+	// cap, as strings are only cut when that makes them shorter.
+	best := result
+	low, high := 0, longestString(value)-len(truncatedMarker)-1
+	for low <= high {
+		keep := (low + high) / 2
+		encoded, err := json.Marshal(capStrings(value, keep))
+		if err != nil {
+			return result
+		}
+		if len(encoded) <= limit {
+			best = encoded
+			low = keep + 1
+		} else {
+			high = keep - 1
+			if keep == 0 {
+				best = encoded
+			}
+		}
+	}
+
+	if len(best) > limit {
+		l.agent.log().Warn().
+			Str("tool", toolCall.Function.Name).
+			Int("length", len(best)).
+			Int("limit", l.agent.config.MaxToolResultLength).
+			Msg(l.logPrefix + " Tool result still exceeds MaxToolResultLength after truncating its strings")
+	} else {
+		l.agent.log().Info().
+			Str("tool", toolCall.Function.Name).
+			Int("length", len(result)).
+			Int("limit", l.agent.config.MaxToolResultLength).
+			Msg(l.logPrefix + " Tool result truncated")
+	}
+	return best
+}
+
+// longestString returns the byte length of the longest string value in a
+// decoded JSON value
+func longestString(value any) int {
+	longest := 0
+	switch v := value.(type) {
+	case string:
+		longest = len(v)
+	case map[string]any:
+		for _, elem := range v {
+			longest = max(longest, longestString(elem))
+		}
+	case []any:
+		for _, elem := range v {
+			longest = max(longest, longestString(elem))
+		}
+	}
+	return longest
+}
+
+// capStrings returns a copy of a decoded JSON value whose string values are
+// cut after keep bytes, at a rune boundary, and marked. Strings that the
+// marker would not make shorter are kept.
+func capStrings(value any, keep int) any {
+	switch v := value.(type) {
+	case string:
+		if len(v) <= keep+len(truncatedMarker) {
+			return v
+		}
+		cut := keep
+		for cut > 0 && !utf8.RuneStart(v[cut]) {
+			cut--
+		}
+		return v[:cut] + truncatedMarker
+	case map[string]any:
+		capped := make(map[string]any, len(v))
+		for key, elem := range v {
+			capped[key] = capStrings(elem, keep)
+		}
+		return capped
+	case []any:
+		capped := make([]any, len(v))
+		for i, elem := range v {
+			capped[i] = capStrings(elem, keep)
+		}
+		return capped
+	}
+	return value
+}
@@ -0,0 +1,107 @@
+package agent
+
+import (
+	"bytes"
+	"encoding/json"
+	"strings"
+	"testing"
+	"unicode/utf8"
+
+	"github.com/rs/zerolog"
+)
+
+// lookupCall is the tool call the truncated results belong to
+var lookupCall = ToolCall{ID: "a", Type: "function", Function: FunctionCall{Name: "lookup", Arguments: "{}"}}
+
+// truncateLoop returns a loop whose agent logs to logs
+func truncateLoop(t *testing.T, logs *bytes.Buffer, limit int) *loop {
+	t.Helper()
+
+	logger := zerolog.New(logs)
+	a := testAgent(t, Config{Logger: &logger, MaxToolResultLength: limit})
+	return &loop{agent: a, logPrefix: "[test]"}
+}
+
+// decodeText decodes a result of the form {"text": ...}
+func decodeText(t *testing.T, result []byte) string {
+	t.Helper()
+
+	var value struct{ Text string }
+	if err := json.Unmarshal(result, &value); err != nil {
+		t.Fatalf("truncated result is not valid JSON: %v\n%s", err, result)
+	}
+	return value.Text
+}
+
+func TestTruncateResultUnderLimit(t *testing.T) {
+	var logs bytes.Buffer
+	l := truncateLoop(t, &logs, 100)
+
+	result := []byte(`{"text":"short"}`)
+	if got := l.truncateResult(lookupCall, result, 100); string(got) != string(result) {
+		t.Errorf("truncateResult() = %s, want %s unchanged", got, result)
+	}
+}
+
+func TestTruncateResultCutsLongString(t *testing.T) {
+	var logs bytes.Buffer
+	l := truncateLoop(t, &logs, 100)
+
+	long := strings.Repeat("x", 1000)
+	result, _ := json.Marshal(map[string]string{"text": long})
+	got := l.truncateResult(lookupCall, result, 100)
+	if len(got) > 100 {
+		t.Errorf("truncateResult() is %d bytes, want at most 100", len(got))
+	}
+	text := decodeText(t, got)
+	if !strings.HasSuffix(text, truncatedMarker) || !strings.HasPrefix(long, strings.TrimSuffix(text, truncatedMarker)) {
+		t.Errorf("truncated text = %q, want a prefix of the original ending in %q", text, truncatedMarker)
+	}
+	// The cap is the largest that fits, so one more byte would not
+	if len(got) < 99 {
+		t.Errorf("truncateResult() is %d bytes, want the largest cut that fits in 100", len(got))
+	}
+}
+
+func TestTruncateResultCutsAtRuneBoundary(t *testing.T) {
+	if got := capStrings("aéééééééééééééééééé", 2); got != "a"+truncatedMarker {
+		t.Errorf("capStrings() = %q, want %q", got, "a"+truncatedMarker)
+	}
+
+	var logs bytes.Buffer
+	l := truncateLoop(t, &logs, 101)
+	result, _ := json.Marshal(map[string]string{"text": strings.Repeat("é", 500)})
+	text := decodeText(t, l.truncateResult(lookupCall, result, 101))
+	// Encoding replaces a split rune with U+FFFD
+	if strings.ContainsRune(text, utf8.RuneError) || !strings.HasSuffix(text, truncatedMarker) {
+		t.Errorf("truncated text = %q, want whole runes ending in %q", text, truncatedMarker)
+	}
+}
+
+func TestTruncateResultWarnsWhenStillOverLimit(t *testing.T) {
+	zerolog.SetGlobalLevel(zerolog.WarnLevel)
+	t.Cleanup(func() { zerolog.SetGlobalLevel(zerolog.Disabled) })
+
+	var logs bytes.Buffer
+	l := truncateLoop(t, &logs, 20)
+
+	numbers := make([]int, 100)
+	result, _ := json.Marshal(map[string]any{"numbers": numbers, "text": strings.Repeat("x", 100)})
+	got := l.truncateResult(lookupCall, result, 20)
+	if len(got) <= 20 {
+		t.Fatalf("truncateResult() is %d bytes, want it to stay over the limit", len(got))
+	}
+	var value struct {
+		Numbers []int
+		Text    string
+	}
+	if err := json.Unmarshal(got, &value); err != nil {
+		t.Fatalf("truncated result is not valid JSON: %v\n%s", err, got)
+	}
+	if len(value.Numbers) != 100 || value.Text != truncatedMarker {
+		t.Errorf("truncateResult() = %s, want the numbers kept and the text cut to the marker", got)
+	}
+	if !strings.Contains(logs.String(), "still exceeds MaxToolResultLength") {
+		t.Errorf("no warning logged for a result over the limit:\n%s", logs.String())
+	}
+}
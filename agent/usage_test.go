@@ -0,0 +1,50 @@
+package agent
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func TestUsageDecodesProviderPayload(t *testing.T) {
+	body := []byte(`{
+		"id": "chatcmpl-B9MHDbslfkBeAs8l4bebGdFOJ6PeG",
+		"object": "chat.completion",
+		"created": 1741569952,
+		"model": "gpt-4o-2024-08-06",
+		"choices": [{
+			"index": 0,
+			"message": {"role": "assistant", "content": "Hello! How can I assist you today?"},
+			"finish_reason": "stop"
+		}],
+		"usage": {
+			"prompt_tokens": 19,
+			"completion_tokens": 10,
+			"total_tokens": 29,
+			"prompt_tokens_details": {"cached_tokens": 0, "audio_tokens": 0},
+			"completion_tokens_details": {"reasoning_tokens": 0, "audio_tokens": 0}
+		}
+	}`)
+
+	var resp apiResponse
+	if err := json.Unmarshal(body, &resp); err != nil {
+		t.Fatalf("decoding response: %v", err)
+	}
+	if resp.Usage == nil {
+		t.Fatal("usage was not decoded")
+	}
+	want := Usage{PromptTokens: 19, CompletionTokens: 10, TotalTokens: 29}
+	if *resp.Usage != want {
+		t.Errorf("usage = %+v, want %+v", *resp.Usage, want)
+	}
+}
+
+func TestUsageEncodesSnakeCase(t *testing.T) {
+	data, err := json.Marshal(Usage{PromptTokens: 1, CompletionTokens: 2, TotalTokens: 3})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := `{"prompt_tokens":1,"completion_tokens":2,"total_tokens":3}`
+	if string(data) != want {
+		t.Errorf("encoded usage = %s, want %s", data, want)
+	}
+}
@@ -0,0 +1,73 @@
+package agent
+
+import (
+	"runtime/debug"
+	"sync"
+)
+
+// modulePath is the module path of the SDK
+const modulePath = "github.com/trogui/go-agent-sdk"
+
+var (
+	versionOnce sync.Once
+	version     string
+)
+
+// Version returns the SDK module version the binary was built with, e.g.
+// "v0.2.1", or "(devel)" when it is unknown such as in a local checkout
+func Version() string {
+	versionOnce.Do(func() {
+		version = "(devel)"
+		info, ok := debug.ReadBuildInfo()
+		if !ok {
+			return
+		}
+		if info.Main.Path == modulePath && info.Main.Version != "" {
+			version = info.Main.Version
+			return
+		}
+		for _, dep := range info.Deps {
+			if dep.Path != modulePath {
+				continue
+			}
+			if dep.Replace != nil && dep.Replace.Version != "" {
+				dep = dep.Replace
+			}
+			if dep.Version != "" {
+				version = dep.Version
+			}
+			return
+		}
+	})
+	return version
+}
+
+// userAgent is the User-Agent header sent with every request
+func userAgent() string {
+	return "go-agent-sdk/" + Version()
+}
+
+// Introspection describes an agent's effective configuration
+type Introspection struct {
+	Version    string // SDK version, see Version
+	APIURL     string
+	Model      string
+	Provider   string
+	ToolFormat string
+	MaxLoops   int
+	Tools      []ToolInfo // Registered tools sorted by name
+}
+
+// Introspect returns the SDK version and the agent's effective
+// configuration, without secrets, for diagnostics and support requests
+func (a *Agent) Introspect() Introspection {
+	return Introspection{
+		Version:    Version(),
+		APIURL:     a.config.APIURL,
+		Model:      a.config.Model,
+		Provider:   a.config.Provider,
+		ToolFormat: a.config.ToolFormat,
+		MaxLoops:   a.config.MaxLoops,
+		Tools:      a.ListTools(),
+	}
+}
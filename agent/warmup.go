@@ -0,0 +1,96 @@
+package agent
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+)
+
+// Warmup opens a connection to the configured endpoint with a lightweight
+// HEAD request so that the first API call does not pay for DNS, TCP and TLS
+// setup. The response status is ignored; only transport errors are returned.
+func (a *Agent) Warmup(ctx context.Context) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodHead, a.config.APIURL, nil)
+	if err != nil {
+		return fmt.Errorf("error creating warmup request: %w", err)
+	}
+	req.Header.Set("User-Agent", userAgent())
+
+	start := a.clock.Now()
+	resp, err := a.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("error warming up connection: %w", err)
+	}
+	// Drain the body so the connection goes back to the pool
+	io.Copy(io.Discard, resp.Body)
+	resp.Body.Close()
+
+	a.log().Debug().
+		Dur("elapsed", a.clock.Now().Sub(start)).
+		Int("status", resp.StatusCode).
+		Msg("[Agent] Connection warmed up")
+	return nil
+}
+
+// PrimeCache sends the system prompt and tool definitions ahead of the first
+// message with a minimal completion (max_tokens 1), so that providers with
+// prompt caching cache the prefix and the first real turn starts faster. It
+// costs the prompt tokens of the prefix plus one completion token, reported
+// in the returned Usage.
+func (a *Agent) PrimeCache(ctx context.Context) (Usage, error) {
+	return a.prime(ctx, a.systemPrompt(ctx, ""), runOptions{})
+}
+
+// PrimeCache primes the provider's prompt cache with the session's system
+// prompt and tool definitions, like Agent.PrimeCache. Call it when the
+// session is created, before the user's first message. The tokens spent are
+// reported by AuxiliaryUsage.
+func (s *Session) PrimeCache(ctx context.Context) error {
+	s.mu.RLock()
+	prompt := s.messages[0].Content
+	options := s.options
+	s.mu.RUnlock()
+
+	usage, err := s.agent.prime(ctx, prompt, options)
+	s.addAuxiliaryUsage(usage)
+	return err
+}
+
+// prime makes the priming call of Agent.PrimeCache and Session.PrimeCache. A
+// placeholder user message follows the system prompt, since some providers
+// reject requests without one; it comes after the cached prefix.
+func (a *Agent) prime(ctx context.Context, prompt string, options runOptions) (Usage, error) {
+	start := a.clock.Now()
+	resp, err := a.callAPI(ctx, apiRequest{
+		messages: []ConversationMessage{
+			{Role: "system", Content: prompt},
+			{Role: "user", Content: "Hi"},
+		},
+		options:   options,
+		maxTokens: 1,
+	})
+	if err != nil {
+		return Usage{}, fmt.Errorf("error priming prompt cache: %w", err)
+	}
+
+	var usage Usage
+	if resp.Usage != nil {
+		usage = *resp.Usage
+	}
+	a.log().Debug().
+		Dur("elapsed", a.clock.Now().Sub(start)).
+		Int("prompt_tokens", usage.PromptTokens).
+		Msg("[Agent] Prompt cache primed")
+	return usage, nil
+}
+
+// keepWarm pings the endpoint every interval until ctx is done
+func (a *Agent) keepWarm(ctx context.Context, interval time.Duration) {
+	for sleep(ctx, a.clock, interval) == nil {
+		if err := a.Warmup(ctx); err != nil && ctx.Err() == nil {
+			a.log().Warn().Err(err).Msg("[Agent] Keep-warm ping failed")
+		}
+	}
+}
@@ -0,0 +1,82 @@
+package agent_test
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/trogui/go-agent-sdk/agent"
+	"github.com/trogui/go-agent-sdk/agent/agenttest"
+)
+
+func TestSessionPrimeCache(t *testing.T) {
+	usage := &agent.Usage{PromptTokens: 120, CompletionTokens: 1, TotalTokens: 121}
+	e := agenttest.NewEval(t, agent.Config{}, agenttest.Response{Content: "H", Usage: usage})
+	session := e.Agent.NewSession(t.Context())
+	defer session.Close()
+
+	if err := session.PrimeCache(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if maxTokens, _ := requestField(t, e.Provider.Requests()[0], "max_tokens"); string(maxTokens) != "1" {
+		t.Errorf("max_tokens = %s, want 1", maxTokens)
+	}
+	if got := session.AuxiliaryUsage(); got != *usage {
+		t.Errorf("AuxiliaryUsage() = %+v, want %+v", got, *usage)
+	}
+	if n := len(session.GetHistory()); n != 1 {
+		t.Errorf("history has %d messages, want only the system prompt", n)
+	}
+}
+
+// BenchmarkFirstRequest measures the first API call of a new agent against
+// a local TLS server, with and without a Warmup beforehand. The warm-up
+// itself is not timed.
+func BenchmarkFirstRequest(b *testing.B) {
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.Copy(io.Discard, r.Body)
+		w.Header().Set("Content-Type", "application/json")
+		io.WriteString(w, `{"choices":[{"message":{"role":"assistant","content":"done"},"finish_reason":"stop"}]}`)
+	}))
+	defer server.Close()
+
+	for _, warm := range []bool{false, true} {
+		name := "cold"
+		if warm {
+			name = "warm"
+		}
+		b.Run(name, func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				b.StopTimer()
+				// A new transport per iteration, so no connection is reused
+				transport := server.Client().Transport.(*http.Transport).Clone()
+				a, err := agent.New(agent.Config{
+					APIKey:       "test",
+					APIURL:       server.URL + "/v1/chat/completions",
+					Model:        "test-model",
+					SystemPrompt: "You are a test assistant.",
+					HTTPClient:   &http.Client{Transport: transport},
+				})
+				if err != nil {
+					b.Fatal(err)
+				}
+				if warm {
+					if err := a.Warmup(context.Background()); err != nil {
+						b.Fatal(err)
+					}
+				}
+				b.StartTimer()
+
+				if _, err := a.Run("hi"); err != nil {
+					b.Fatal(err)
+				}
+
+				b.StopTimer()
+				transport.CloseIdleConnections()
+				b.StartTimer()
+			}
+		})
+	}
+}
@@ -0,0 +1,99 @@
+package agent
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// XAIAPIURL is the chat completions endpoint of xAI's Grok API
+const XAIAPIURL = "https://api.x.ai/v1/chat/completions"
+
+// defaultXAIWarnThreshold is the remaining request count below which
+// XAIAdapter reports the rate limit as approaching
+const defaultXAIWarnThreshold = 5
+
+// XAIAdapter adapts the agent to xAI's Grok API. It tracks the
+// x-ratelimit-remaining-requests header and, as xAI answers 429 with a
+// Retry-After delay rather than a reset time, holds back further requests
+// until that delay has passed.
+type XAIAdapter struct {
+	// WarnThreshold is the remaining request count below which
+	// EventRateLimitApproaching is emitted, 5 when zero. A negative value
+	// disables the warning.
+	WarnThreshold int
+
+	mu      sync.Mutex
+	retryAt time.Time
+	clock   Clock
+}
+
+// NewXAIAdapter creates an XAIAdapter with the default threshold
+func NewXAIAdapter() *XAIAdapter {
+	return &XAIAdapter{WarnThreshold: defaultXAIWarnThreshold}
+}
+
+// setClock implements clockUser
+func (x *XAIAdapter) setClock(c Clock) {
+	x.mu.Lock()
+	defer x.mu.Unlock()
+
+	x.clock = c
+}
+
+// clockLocked returns the adapter's clock. x.mu must be held.
+func (x *XAIAdapter) clockLocked() Clock {
+	if x.clock == nil {
+		return realClock{}
+	}
+	return x.clock
+}
+
+// BeforeRequest waits out the Retry-After delay of a previous 429
+func (x *XAIAdapter) BeforeRequest(ctx context.Context, req *http.Request) error {
+	wait, _ := x.holdBack()
+	x.mu.Lock()
+	c := x.clockLocked()
+	x.mu.Unlock()
+
+	return sleep(ctx, c, wait)
+}
+
+// holdBack implements holdBacker
+func (x *XAIAdapter) holdBack() (time.Duration, string) {
+	x.mu.Lock()
+	defer x.mu.Unlock()
+
+	return x.retryAt.Sub(x.clockLocked().Now()), "[xAI] Rate limited, waiting before the next request"
+}
+
+// AfterResponse records the Retry-After delay of 429 responses and reports
+// when few requests remain
+func (x *XAIAdapter) AfterResponse(resp *http.Response) *RateLimitStatus {
+	retryAfter := time.Duration(0)
+	if seconds, err := strconv.Atoi(resp.Header.Get("Retry-After")); err == nil {
+		retryAfter = time.Duration(seconds) * time.Second
+	}
+
+	x.mu.Lock()
+	if resp.StatusCode == http.StatusTooManyRequests && retryAfter > 0 {
+		x.retryAt = x.clockLocked().Now().Add(retryAfter)
+	}
+	threshold := x.WarnThreshold
+	x.mu.Unlock()
+
+	if resp.StatusCode == http.StatusTooManyRequests {
+		return &RateLimitStatus{Remaining: 0, ResetIn: retryAfter}
+	}
+	if threshold == 0 {
+		threshold = defaultXAIWarnThreshold
+	}
+
+	remaining, err := strconv.Atoi(resp.Header.Get("x-ratelimit-remaining-requests"))
+	if err != nil || remaining >= threshold {
+		return nil
+	}
+	return &RateLimitStatus{Remaining: remaining}
+}
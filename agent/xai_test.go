@@ -0,0 +1,115 @@
+package agent_test
+
+import (
+	"bytes"
+	"net/http"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/rs/zerolog"
+	"github.com/trogui/go-agent-sdk/agent"
+	"github.com/trogui/go-agent-sdk/agent/agenttest"
+)
+
+func TestXAIAdapterWarnThreshold(t *testing.T) {
+	tests := []struct {
+		name      string
+		adapter   *agent.XAIAdapter
+		remaining int
+		warn      bool
+	}{
+		{"constructor, above default", agent.NewXAIAdapter(), 5, false},
+		{"constructor, below default", agent.NewXAIAdapter(), 4, true},
+		{"zero value, above default", &agent.XAIAdapter{}, 5, false},
+		{"zero value, below default", &agent.XAIAdapter{}, 4, true},
+		{"custom", &agent.XAIAdapter{WarnThreshold: 20}, 19, true},
+		{"disabled", &agent.XAIAdapter{WarnThreshold: -1}, 0, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resp := &http.Response{StatusCode: http.StatusOK, Header: http.Header{}}
+			resp.Header.Set("x-ratelimit-remaining-requests", strconv.Itoa(tt.remaining))
+
+			status := tt.adapter.AfterResponse(resp)
+			if (status != nil) != tt.warn {
+				t.Fatalf("AfterResponse() = %+v, want a warning: %v", status, tt.warn)
+			}
+			if status != nil && status.Remaining != tt.remaining {
+				t.Errorf("Remaining = %d, want %d", status.Remaining, tt.remaining)
+			}
+		})
+	}
+}
+
+// retryAfter adds a Retry-After header to the 429 responses of next
+type retryAfter struct {
+	next    http.RoundTripper
+	seconds string
+}
+
+func (r retryAfter) RoundTrip(req *http.Request) (*http.Response, error) {
+	resp, err := r.next.RoundTrip(req)
+	if err == nil && resp.StatusCode == http.StatusTooManyRequests {
+		resp.Header.Set("Retry-After", r.seconds)
+	}
+	return resp, err
+}
+
+func TestXAIAdapterRetryAfter(t *testing.T) {
+	start := time.Date(2025, 3, 1, 14, 0, 0, 0, time.UTC)
+	clock := &fakeClock{now: start}
+	var logs bytes.Buffer
+	logger := zerolog.New(&logs)
+	zerolog.SetGlobalLevel(zerolog.WarnLevel)
+	t.Cleanup(func() { zerolog.SetGlobalLevel(zerolog.Disabled) })
+	provider := agenttest.NewProvider(
+		agenttest.Response{Status: http.StatusTooManyRequests, Content: `{"error":{"message":"slow down"}}`},
+		agenttest.Response{Content: "hello"},
+	)
+	a, err := agent.New(agent.Config{
+		APIKey:       "test",
+		APIURL:       agent.XAIAPIURL,
+		Model:        "grok-4",
+		SystemPrompt: "You are a helpful assistant.",
+		Provider:     agent.ProviderXAI,
+		HTTPClient:   &http.Client{Transport: retryAfter{next: provider, seconds: "30"}},
+		Clock:        clock,
+		Logger:       &logger,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := a.Run("hi"); err == nil {
+		t.Fatal("Run succeeded despite the 429")
+	}
+	if elapsed := clock.Now().Sub(start); elapsed != 0 {
+		t.Fatalf("the first request waited %s", elapsed)
+	}
+
+	// The next request is held back for the Retry-After delay
+	resp, err := a.Run("hi")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.Content != "hello" {
+		t.Errorf("Content = %q, want hello", resp.Content)
+	}
+	if elapsed := clock.Now().Sub(start); elapsed != 30*time.Second {
+		t.Errorf("the second request waited %s, want 30s", elapsed)
+	}
+	if !strings.Contains(logs.String(), "[xAI] Rate limited") {
+		t.Errorf("the wait was not logged through Config.Logger:\n%s", logs.String())
+	}
+
+	// Once waited out, the delay no longer applies
+	provider.Repeat(agenttest.Response{Content: "hello"})
+	if _, err := a.Run("hi"); err != nil {
+		t.Fatal(err)
+	}
+	if elapsed := clock.Now().Sub(start); elapsed != 30*time.Second {
+		t.Errorf("a later request waited, total %s", elapsed)
+	}
+}
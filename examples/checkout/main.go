@@ -0,0 +1,123 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+
+	"github.com/rs/zerolog"
+	"github.com/trogui/go-agent-sdk/agent"
+)
+
+// Cart simulates a shopping cart
+type Cart struct {
+	items []string
+}
+
+// Add puts an item in the cart
+func (c *Cart) Add(item string) map[string]interface{} {
+	c.items = append(c.items, item)
+	return map[string]interface{}{
+		"added": item,
+		"items": c.items,
+	}
+}
+
+// Checkout places the order for the items in the cart
+func (c *Cart) Checkout() map[string]interface{} {
+	order := map[string]interface{}{
+		"order_id": "ORD-1001",
+		"items":    c.items,
+		"status":   "confirmed",
+	}
+	c.items = nil
+	return order
+}
+
+func main() {
+	// Set up logging
+	zerolog.SetGlobalLevel(zerolog.InfoLevel)
+
+	cart := &Cart{}
+
+	// Get API credentials
+	apiKey := os.Getenv("OPENROUTER_API_KEY")
+	if apiKey == "" {
+		log.Fatal("OPENROUTER_API_KEY environment variable is required")
+	}
+
+	// Create agent
+	ag, err := agent.New(agent.Config{
+		APIKey:       apiKey,
+		APIURL:       "https://openrouter.ai/api/v1/chat/completions",
+		Model:        "gpt-4o-mini",
+		SystemPrompt: "You are a shopping assistant. Use the tools to buy what the user asks for.",
+		MaxLoops:     10,
+	})
+	if err != nil {
+		log.Fatalf("Failed to create agent: %v", err)
+	}
+
+	// Register a two-step workflow: checkout only works once something has
+	// been added to the cart in this session
+	ag.RegisterTools(
+		&agent.Tool{
+			Name:        "add_to_cart",
+			Description: "Add an item to the shopping cart",
+			Parameters: map[string]agent.Parameter{
+				"item": {
+					Type:        "string",
+					Description: "The item to add",
+				},
+			},
+			Required: []string{"item"},
+			Handler: func(args json.RawMessage) (any, error) {
+				var payload struct {
+					Item string `json:"item"`
+				}
+				if err := json.Unmarshal(args, &payload); err != nil {
+					return nil, err
+				}
+
+				return cart.Add(payload.Item), nil
+			},
+		},
+		&agent.Tool{
+			Name:        "checkout",
+			Description: "Place the order for the items in the cart",
+			Precondition: func(ctx context.Context, state agent.SessionState) error {
+				if !state.Succeeded("add_to_cart") {
+					return errors.New("the cart is empty, call add_to_cart first")
+				}
+				return nil
+			},
+			Handler: func(args json.RawMessage) (any, error) {
+				return cart.Checkout(), nil
+			},
+		},
+	)
+
+	fmt.Println("=== Checkout Agent Example - Tool Preconditions ===")
+	fmt.Println()
+
+	prompt := "Please check out my order of a coffee mug."
+	fmt.Printf("Prompt: %s\n", prompt)
+	fmt.Println("---")
+
+	response, err := ag.Run(prompt)
+	if err != nil {
+		log.Fatalf("Failed to run agent: %v", err)
+	}
+
+	for _, call := range response.ToolCalls {
+		if call.Error != "" {
+			fmt.Printf("%s refused: %s\n", call.Name, call.Error)
+		} else {
+			fmt.Printf("%s: %s\n", call.Name, call.Result)
+		}
+	}
+	fmt.Printf("\nResponse: %s\n", response.Content)
+}
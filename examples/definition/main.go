@@ -0,0 +1,87 @@
+package main
+
+import (
+	"bytes"
+	_ "embed"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+
+	"github.com/rs/zerolog"
+	"github.com/trogui/go-agent-sdk/agent"
+)
+
+// defaultDefinition is used when no definition file is given, so that the
+// example runs from any directory
+//
+//go:embed agent.yaml
+var defaultDefinition []byte
+
+func main() {
+	// Set up logging
+	zerolog.SetGlobalLevel(zerolog.InfoLevel)
+
+	// Get API credentials; they are never part of the definition
+	apiKey := os.Getenv("OPENROUTER_API_KEY")
+	if apiKey == "" {
+		log.Fatal("OPENROUTER_API_KEY environment variable is required")
+	}
+
+	// Register the Go implementations the definition may enable
+	registry := agent.NewToolRegistry()
+	registry.Register("orders.lookup", &agent.Tool{
+		Description: "Look up an order by its ID",
+		Parameters: map[string]agent.Parameter{
+			"order_id": {
+				Type:        "string",
+				Description: "The order ID, e.g. ORD-1001",
+			},
+		},
+		Required: []string{"order_id"},
+		Handler: func(args json.RawMessage) (any, error) {
+			var payload struct {
+				OrderID string `json:"order_id"`
+			}
+			if err := json.Unmarshal(args, &payload); err != nil {
+				return nil, err
+			}
+			return map[string]any{
+				"order_id": payload.OrderID,
+				"status":   "shipped",
+				"items":    3,
+				"total":    59.97,
+			}, nil
+		},
+	})
+
+	// Load the definition given as argument, whose system_prompt_file is
+	// read next to it, or the embedded agent.yaml
+	var config agent.Config
+	var tools []agent.ToolRef
+	var err error
+	if len(os.Args) > 1 {
+		config, tools, err = agent.LoadDefinitionFile(os.Args[1])
+	} else {
+		config, tools, err = agent.LoadDefinition(bytes.NewReader(defaultDefinition))
+	}
+	if err != nil {
+		log.Fatalf("Invalid definition: %v", err)
+	}
+	config.APIKey = apiKey
+
+	// Create agent
+	ag, err := agent.New(config)
+	if err != nil {
+		log.Fatalf("Failed to create agent: %v", err)
+	}
+	if err := registry.Bind(ag, tools); err != nil {
+		log.Fatalf("Failed to bind tools: %v", err)
+	}
+
+	resp, err := ag.Run("What is the average price per item of order ORD-1001?")
+	if err != nil {
+		log.Fatalf("Run failed: %v", err)
+	}
+	fmt.Println(resp.Content)
+}
@@ -0,0 +1,80 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+
+	"github.com/rs/zerolog"
+	"github.com/trogui/go-agent-sdk/agent"
+)
+
+func main() {
+	// Set up logging
+	zerolog.SetGlobalLevel(zerolog.InfoLevel)
+
+	// Get API credentials from https://console.x.ai
+	apiKey := os.Getenv("XAI_API_KEY")
+	if apiKey == "" {
+		log.Fatal("XAI_API_KEY environment variable is required")
+	}
+
+	// Create agent
+	ag, err := agent.New(agent.Config{
+		APIKey:       apiKey,
+		APIURL:       agent.XAIAPIURL,
+		Model:        "grok-3-mini",
+		Provider:     agent.ProviderXAI,
+		SystemPrompt: "You are a helpful assistant. Use the calculator for any arithmetic and the datetime tool for dates.",
+		MaxLoops:     10,
+	})
+	if err != nil {
+		log.Fatalf("Failed to create agent: %v", err)
+	}
+
+	// Register tools
+	if err := ag.EnableBuiltins([]string{agent.BuiltinCalculator, agent.BuiltinDatetime}); err != nil {
+		log.Fatalf("Failed to enable builtins: %v", err)
+	}
+	ag.RegisterTool(&agent.Tool{
+		Name:        "get_launch_date",
+		Description: "Get the launch date of a rocket mission",
+		Parameters: map[string]agent.Parameter{
+			"mission": {
+				Type:        "string",
+				Description: "The mission name",
+			},
+		},
+		Required: []string{"mission"},
+		Handler: func(args json.RawMessage) (any, error) {
+			var payload struct {
+				Mission string `json:"mission"`
+			}
+			if err := json.Unmarshal(args, &payload); err != nil {
+				return nil, err
+			}
+
+			return map[string]interface{}{
+				"mission":     payload.Mission,
+				"launch_date": "2027-03-14T09:30:00Z",
+			}, nil
+		},
+	})
+
+	fmt.Println("=== Grok Agent Example - xAI provider ===")
+	fmt.Println()
+
+	prompt := "How many days are left until the Artemis IV launch, and what is that number squared?"
+	fmt.Printf("Prompt: %s\n", prompt)
+	fmt.Println("---")
+
+	response, err := ag.Run(prompt)
+	if err != nil {
+		log.Fatalf("Failed to run agent: %v", err)
+	}
+
+	fmt.Printf("Response: %s\n\n", response.Content)
+	fmt.Printf("Loops executed: %d\n", response.LoopCount)
+	fmt.Printf("Tool calls: %d\n", len(response.ToolCalls))
+}
@@ -145,6 +145,7 @@ func main() {
 		SystemPrompt: "You are a task management assistant. Help the user add, complete, and view the status of their tasks. Be concise and helpful.",
 		MaxLoops:     10,
 		Temperature:  0.7,
+		CoerceArgs:   true, // Accept {"id": "5"} from models that quote numbers
 	})
 	if err != nil {
 		log.Fatalf("Failed to create agent: %v", err)
@@ -273,27 +274,43 @@ func main() {
 
 		case agent.EventTurnComplete:
 			fmt.Printf("\nAgent: %s\n\n", event.Content)
+			if !askNext(reader, session) {
+				return
+			}
 
-			// Ask for next message
-			fmt.Print("You: ")
-			nextMsg, _ := reader.ReadString('\n')
-			nextMsg = strings.TrimSpace(nextMsg)
+		case agent.EventError:
+			fmt.Printf("\nAgent Error: %s\n", event.Content)
 
-			if nextMsg == "" || nextMsg == "exit" {
-				fmt.Println("\nGoodbye!")
+			// A failed turn leaves the history as it was before the message,
+			// so the conversation can go on unless the session is gone
+			if turnErr, ok := event.Data.(agent.TurnError); !ok || !turnErr.Recoverable {
 				session.Close()
 				return
 			}
+			fmt.Print("Please try again.\n\n")
+			if !askNext(reader, session) {
+				return
+			}
+		}
+	}
+}
 
-			fmt.Printf("User: %s\n", nextMsg)
-			session.Send(nextMsg)
+// askNext reads the next user message and sends it. It closes the session
+// and returns false when the user is done.
+func askNext(reader *bufio.Reader, session *agent.Session) bool {
+	fmt.Print("You: ")
+	nextMsg, _ := reader.ReadString('\n')
+	nextMsg = strings.TrimSpace(nextMsg)
 
-		case agent.EventError:
-			fmt.Printf("\nAgent Error: %s\n", event.Content)
-			session.Close()
-			return
-		}
+	if nextMsg == "" || nextMsg == "exit" {
+		fmt.Println("\nGoodbye!")
+		session.Close()
+		return false
 	}
+
+	fmt.Printf("User: %s\n", nextMsg)
+	session.Send(nextMsg)
+	return true
 }
 
 func truncate(s string, maxLen int) string {
@@ -6,6 +6,7 @@ import (
 	"fmt"
 	"log"
 	"os"
+	"strings"
 
 	"github.com/rs/zerolog"
 	"github.com/trogui/go-agent-sdk/agent"
@@ -103,6 +104,17 @@ func main() {
 				},
 			},
 			Required: []string{"city"},
+			// Accept "New York" as well as "new_york"
+			TransformArgs: func(args json.RawMessage) (json.RawMessage, error) {
+				var payload struct {
+					City string `json:"city"`
+				}
+				if err := json.Unmarshal(args, &payload); err != nil {
+					return nil, err
+				}
+				payload.City = strings.ReplaceAll(strings.ToLower(strings.TrimSpace(payload.City)), " ", "_")
+				return json.Marshal(payload)
+			},
 			Handler: func(args json.RawMessage) (any, error) {
 				var payload struct {
 					City string `json:"city"`
@@ -153,6 +165,10 @@ func main() {
 
 	response, err := ag.Run(prompt)
 	if err != nil {
+		if response != nil {
+			fmt.Printf("Partial run: %d loops, %d tokens, %d tool calls\n",
+				response.LoopCount, response.Usage.TotalTokens, len(response.ToolCalls))
+		}
 		log.Fatalf("Failed to run agent: %v", err)
 	}
 
@@ -173,6 +189,10 @@ func main() {
 
 	response2, err := ag.Run(prompt2)
 	if err != nil {
+		if response2 != nil {
+			fmt.Printf("Partial run: %d loops, %d tokens, %d tool calls\n",
+				response2.LoopCount, response2.Usage.TotalTokens, len(response2.ToolCalls))
+		}
 		log.Fatalf("Failed to run agent: %v", err)
 	}
 